@@ -0,0 +1,295 @@
+package shapeset
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/nat-n/geom"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* The graph of which shapes touch which, as implied by the MeshIds of the
+ * interface meshes.
+ *
+ * Each pair of shapes with a mesh between them is joined by an edge weighted
+ * by the surface area of the mesh. Borders, the curves along which three or
+ * more meshes meet, are described as junctions between all of the shapes
+ * either side of those meshes, with the length of the border. Junctions are
+ * only available once borders have been indexed.
+ */
+type AdjacencyGraph struct {
+	Nodes     []AdjacencyNode
+	Edges     []AdjacencyEdge
+	Junctions []AdjacencyJunction
+}
+
+type AdjacencyNode struct {
+	ShapeId ShapeId
+	Label   string
+	Color   string
+	// The total area of the interfaces between the shape and its neighbours
+	SurfaceArea float64
+}
+
+type AdjacencyEdge struct {
+	MeshId MeshId
+	Area   float64
+	Faces  int
+}
+
+type AdjacencyJunction struct {
+	BorderId BorderId
+	ShapeIds []ShapeId
+	Length   float64
+}
+
+// Builds the adjacency graph of the shapeset, with nodes ordered by ShapeId,
+// edges by MeshId and junctions by BorderId.
+func (ss *ShapeSet) AdjacencyGraph() (graph *AdjacencyGraph) {
+	graph = &AdjacencyGraph{
+		Nodes:     make([]AdjacencyNode, 0),
+		Edges:     make([]AdjacencyEdge, 0),
+		Junctions: make([]AdjacencyJunction, 0),
+	}
+
+	positions, mesh_faces := ss.sharedFaceBuffers()
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(mesh_faces))
+	for mesh_id, _ := range mesh_faces {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+
+	shape_areas := make(map[ShapeId]float64)
+	for shape_id, _ := range ss.Shapes {
+		shape_areas[shape_id] = 0
+	}
+	for _, mesh_id := range mesh_ids {
+		edge := AdjacencyEdge{MeshId: mesh_id, Faces: len(mesh_faces[mesh_id])}
+		for _, face := range mesh_faces[mesh_id] {
+			n := triangleNormal(positions[face[0]], positions[face[1]], positions[face[2]])
+			edge.Area += math.Sqrt(n.X*n.X+n.Y*n.Y+n.Z*n.Z) / 2
+		}
+		graph.Edges = append(graph.Edges, edge)
+		shape_areas[mesh_id[0]] += edge.Area
+		shape_areas[mesh_id[1]] += edge.Area
+	}
+
+	shape_ids := make([]ShapeId, 0, len(shape_areas))
+	for shape_id, _ := range shape_areas {
+		shape_ids = append(shape_ids, shape_id)
+	}
+	sort.Sort(ByShapeId(shape_ids))
+	for _, shape_id := range shape_ids {
+		graph.Nodes = append(graph.Nodes, AdjacencyNode{
+			ShapeId:     shape_id,
+			Label:       ss.Shapes[shape_id],
+			Color:       ss.ShapeColor(shape_id).Hex(),
+			SurfaceArea: shape_areas[shape_id],
+		})
+	}
+
+	for _, b := range ss.sortedBorders() {
+		graph.Junctions = append(graph.Junctions, AdjacencyJunction{
+			BorderId: b.Id,
+			ShapeIds: b.ShapeIds(),
+			Length:   b.Length(),
+		})
+	}
+	return
+}
+
+// Calculates the total length of the border's edges, counting edges shared by
+// several of its meshes once.
+func (b *Border) Length() (length float64) {
+	counted := make(map[[2]geom.Vec3]bool)
+	for _, e := range b.Edges {
+		if e.Collapsed || e.Removed {
+			continue
+		}
+		p, q := e.Vertex1().Vec3, e.Vertex2().Vec3
+		if q.X < p.X || (q.X == p.X && (q.Y < p.Y || (q.Y == p.Y && q.Z < p.Z))) {
+			p, q = q, p
+		}
+		if counted[[2]geom.Vec3{p, q}] {
+			continue
+		}
+		counted[[2]geom.Vec3{p, q}] = true
+		length += math.Sqrt((q.X-p.X)*(q.X-p.X) + (q.Y-p.Y)*(q.Y-p.Y) + (q.Z-p.Z)*(q.Z-p.Z))
+	}
+	return
+}
+
+// Writes the graph as JSON in the form of the AdjacencyGraph struct
+func (graph *AdjacencyGraph) WriteJSON(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	if err = json.NewEncoder(bw).Encode(graph); err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+// Writes the graph as Graphviz DOT, with junctions drawn as point nodes joined
+// to each of their shapes by dashed edges.
+func (graph *AdjacencyGraph) WriteDOT(w io.Writer) (err error) {
+	format := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', 6, 64)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "graph adjacency {")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(bw, "  s%d [label=%s, style=filled, fillcolor=\"%s\", area=%s];\n",
+			node.ShapeId, strconv.Quote(node.Label), node.Color, format(node.SurfaceArea))
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(bw, "  s%d -- s%d [weight=%s, label=\"%s\", faces=%d];\n",
+			edge.MeshId[0], edge.MeshId[1], format(edge.Area), format(edge.Area), edge.Faces)
+	}
+	for _, junction := range graph.Junctions {
+		fmt.Fprintf(bw, "  j%d [shape=point, length=%s];\n", junction.BorderId, format(junction.Length))
+		for _, shape_id := range junction.ShapeIds {
+			fmt.Fprintf(bw, "  j%d -- s%d [style=dashed];\n", junction.BorderId, shape_id)
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	Id       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	Id          string             `xml:"id,attr"`
+	EdgeDefault string             `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode      `xml:"node"`
+	Edges       []graphMLEdge      `xml:"edge"`
+	Hyperedges  []graphMLHyperedge `xml:"hyperedge"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLEndpoint struct {
+	Node string `xml:"node,attr"`
+}
+
+type graphMLHyperedge struct {
+	Id        string            `xml:"id,attr"`
+	Data      []graphMLData     `xml:"data"`
+	Endpoints []graphMLEndpoint `xml:"endpoint"`
+}
+
+// Writes the graph as GraphML, with junctions as hyperedges
+func (graph *AdjacencyGraph) WriteGraphML(w io.Writer) (err error) {
+	format := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"label", "node", "label", "string"},
+			{"color", "node", "color", "string"},
+			{"surface_area", "node", "surface_area", "double"},
+			{"area", "edge", "area", "double"},
+			{"faces", "edge", "faces", "int"},
+			{"length", "hyperedge", "length", "double"},
+		},
+		Graph: graphMLGraph{Id: "adjacency", EdgeDefault: "undirected"},
+	}
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			Id: "s" + node.ShapeId.ToString(),
+			Data: []graphMLData{
+				{"label", node.Label},
+				{"color", node.Color},
+				{"surface_area", format(node.SurfaceArea)},
+			},
+		})
+	}
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: "s" + edge.MeshId[0].ToString(),
+			Target: "s" + edge.MeshId[1].ToString(),
+			Data: []graphMLData{
+				{"area", format(edge.Area)},
+				{"faces", strconv.Itoa(edge.Faces)},
+			},
+		})
+	}
+	for _, junction := range graph.Junctions {
+		hyperedge := graphMLHyperedge{
+			Id:   "j" + junction.BorderId.ToString(),
+			Data: []graphMLData{{"length", format(junction.Length)}},
+		}
+		for _, shape_id := range junction.ShapeIds {
+			hyperedge.Endpoints = append(hyperedge.Endpoints, graphMLEndpoint{"s" + shape_id.ToString()})
+		}
+		doc.Graph.Hyperedges = append(doc.Graph.Hyperedges, hyperedge)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	enc := xml.NewEncoder(bw)
+	enc.Indent("", "  ")
+	if err = enc.Encode(doc); err != nil {
+		return
+	}
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// Writes the graph as GraphML, DOT or JSON according to the file extension,
+// which must be .graphml, .dot, .gv or .json
+func (graph *AdjacencyGraph) WriteFile(graph_file_path string) (err error) {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(graph_file_path)) {
+	case ".graphml":
+		write = graph.WriteGraphML
+	case ".dot", ".gv":
+		write = graph.WriteDOT
+	case ".json":
+		write = graph.WriteJSON
+	default:
+		err = errors.New("Unsupported graph file type: " + graph_file_path)
+		return
+	}
+
+	output_file, err := os.Create(graph_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	err = write(output_file)
+	return
+}
@@ -0,0 +1,119 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdjacencyGraph(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	graph := ss.AdjacencyGraph()
+
+	// the sides of each box have an area of 1
+	node_areas := []float64{14, 6, 6, 6}
+	if len(graph.Nodes) != len(node_areas) {
+		t.Fatalf("Expected a node for the exterior and each box, got %d", len(graph.Nodes))
+	}
+	for i, node := range graph.Nodes {
+		if node.ShapeId != ShapeId(i) || math.Abs(node.SurfaceArea-node_areas[i]) > 1e-9 ||
+			node.Color != ss.ShapeColor(node.ShapeId).Hex() {
+			t.Errorf("Expected shape %d to have a surface area of %g, got %+v", i, node_areas[i], node)
+		}
+	}
+	if graph.Nodes[2].Label != "middle" {
+		t.Errorf("Expected shape 2 to be labelled middle, got %s", graph.Nodes[2].Label)
+	}
+
+	edges := []AdjacencyEdge{
+		{MeshId{0, 1}, 5, 10}, {MeshId{0, 2}, 4, 8}, {MeshId{0, 3}, 5, 10},
+		{MeshId{1, 2}, 1, 2}, {MeshId{2, 3}, 1, 2},
+	}
+	if len(graph.Edges) != len(edges) {
+		t.Fatalf("Expected an edge per mesh, got %d", len(graph.Edges))
+	}
+	for i, edge := range graph.Edges {
+		if edge.MeshId != edges[i].MeshId || edge.Faces != edges[i].Faces ||
+			math.Abs(edge.Area-edges[i].Area) > 1e-9 {
+			t.Errorf("Expected edge %+v, got %+v", edges[i], edge)
+		}
+	}
+
+	// each border is the square where two boxes meet
+	if len(graph.Junctions) != len(boxRowBorders) {
+		t.Fatalf("Expected a junction per border, got %d", len(graph.Junctions))
+	}
+	for i, junction := range graph.Junctions {
+		shape_ids := []ShapeId{0, ShapeId(i + 1), ShapeId(i + 2)}
+		if junction.BorderId != boxRowBorders[i].Id || math.Abs(junction.Length-4) > 1e-9 ||
+			len(junction.ShapeIds) != 3 {
+			t.Errorf("Expected border %d to be a junction of length 4, got %+v", boxRowBorders[i].Id, junction)
+			continue
+		}
+		for j, shape_id := range shape_ids {
+			if junction.ShapeIds[j] != shape_id {
+				t.Errorf("Expected border %d to join shapes %v, got %v", junction.BorderId, shape_ids, junction.ShapeIds)
+			}
+		}
+	}
+}
+
+func TestWriteAdjacencyGraph(t *testing.T) {
+	graph := boxRowShapeSet(t).AdjacencyGraph()
+
+	var buf bytes.Buffer
+	if err := graph.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded AdjacencyGraph
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Nodes) != 4 || len(decoded.Edges) != 5 || len(decoded.Junctions) != 2 ||
+		decoded.Edges[1].MeshId != (MeshId{0, 2}) {
+		t.Errorf("Expected the graph to survive being written as JSON, got %+v", decoded)
+	}
+
+	buf.Reset()
+	if err := graph.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, statement := range []string{
+		`s2 [label="middle", style=filled`,
+		`s0 -- s2 [weight=4, label="4", faces=8];`,
+		"j1 -- s1 [style=dashed];",
+	} {
+		if !strings.Contains(dot, statement) {
+			t.Errorf("Expected the DOT graph to include %s, got:\n%s", statement, dot)
+		}
+	}
+
+	buf.Reset()
+	if err := graph.WriteGraphML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 5 || len(doc.Graph.Hyperedges) != 2 {
+		t.Fatalf("Expected 4 nodes, 5 edges and 2 hyperedges, got %d, %d and %d",
+			len(doc.Graph.Nodes), len(doc.Graph.Edges), len(doc.Graph.Hyperedges))
+	}
+	if edge := doc.Graph.Edges[3]; edge.Source != "s1" || edge.Target != "s2" || edge.Data[0].Value != "1" {
+		t.Errorf("Expected an edge of area 1 from s1 to s2, got %+v", edge)
+	}
+	if hyperedge := doc.Graph.Hyperedges[1]; len(hyperedge.Endpoints) != 3 ||
+		hyperedge.Endpoints[2].Node != "s3" || hyperedge.Data[0].Value != "4" {
+		t.Errorf("Expected a hyperedge of length 4 to s3, got %+v", hyperedge)
+	}
+
+	if err := graph.WriteFile(filepath.Join(t.TempDir(), "graph.csv")); err == nil {
+		t.Errorf("Expected an unsupported graph file type to be rejected")
+	}
+}
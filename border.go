@@ -23,9 +23,13 @@ func (b *Border) Len() int {
 	return len(b.Vertices)
 }
 
+// Calls cb with each mesh of the border, except for any which weren't read if
+// the shapeset was read partially.
 func (b *Border) EachMesh(cb func(*Mesh)) {
 	for _, mesh_id := range b.MeshIds {
-		cb(b.shapeSet.Meshes[mesh_id])
+		if !b.shapeSet.unloadedMeshes[mesh_id] {
+			cb(b.shapeSet.Meshes[mesh_id])
+		}
 	}
 }
 
@@ -258,4 +262,8 @@ func (ss *ShapeSet) ResetBorders() {
 		borderById:   make(map[BorderId]*Border),
 		borderByDesc: make(map[BorderDescription]*Border),
 	}
+	// meshes must not keep referring to borders of the old index
+	for _, m := range ss.Meshes {
+		m.Borders = make(map[BorderId]*Border)
+	}
 }
@@ -1,16 +1,13 @@
 package shapeset
 
 import (
-	"github.com/nat-n/geom"
-	"github.com/nat-n/gomesh/cuboid"
 	gomesh "github.com/nat-n/gomesh/mesh"
 	"sort"
 	"sync"
 )
 
 type boundaryDetails struct {
-	Verts       []gomesh.VertexI
-	BoundingBox cuboid.Cuboid
+	Verts []gomesh.VertexI
 }
 
 type boundarySet struct {
@@ -18,11 +15,24 @@ type boundarySet struct {
 	MeshId     MeshId
 }
 
+// Indexes the borders between meshes, linking only boundary vertices at
+// exactly the same position.
 func (ss *ShapeSet) IndexBorders() (err error) {
+	_, err = ss.IndexBordersWithTolerance(ExactWeld)
+	return
+}
+
+/* Indexes the borders between meshes, welding together boundary vertices of
+ * different meshes which lie within the given tolerance of one another, so
+ * that each mesh shares the position of the vertex from the lowest MeshId.
+ * Reports pairs of vertices which were near but not welded, and boundary
+ * vertices which weren't welded to any other mesh.
+ */
+func (ss *ShapeSet) IndexBordersWithTolerance(weld WeldTolerance) (report *WeldReport, err error) {
 	// Clear existing borders
 	ss.ResetBorders()
 
-	// Compose a map with the boundaries with bounding boxes for each mesh
+	// Identify the boundaries of each mesh concurrently
 	var wg sync.WaitGroup
 	new_boundaries := make(chan *boundarySet, 16)
 	for _, m := range ss.Meshes {
@@ -41,10 +51,7 @@ func (ss *ShapeSet) IndexBorders() (err error) {
 				for j, bv := range boundary {
 					wrapped_boundary[j] = bv
 				}
-				mesh1_boundaries[i] = &boundaryDetails{
-					wrapped_boundary,
-					*mesh1.SubsetBoundingBox(boundary),
-				}
+				mesh1_boundaries[i] = &boundaryDetails{wrapped_boundary}
 			}
 			new_boundaries <- &boundarySet{mesh1_boundaries, MeshIdFromString(mesh1.Name)}
 		}(m)
@@ -61,176 +68,60 @@ func (ss *ShapeSet) IndexBorders() (err error) {
 		wg.Done()
 	}
 
-	// The following block compares all borders to build up the vertexOccurances
-	//  map of a location onto a number of vertices from different meshes
-	vertexOccurances := make(map[geom.Vec3][]gomesh.VertexI)
-	new_vertex_occurances := make(chan map[geom.Vec3][]gomesh.VertexI, 16)
-	for mesh_id1, m1 := range ss.Meshes {
-		for mesh_id2, m2 := range ss.Meshes {
-			if mesh_id2.LessThan(mesh_id1) ||
-				!m1.BoundingBox.Expanded(0.01).Intersects(
-					m2.BoundingBox.Expanded(0.01)) {
-				// Make sure we only deal with each pair of meshes once,
-				//  and ignore pairs of meshes whose bounding boxes dont intersect
-				continue
-			}
-			wg.Add(1)
-			go func(mesh_id1, mesh_id2 MeshId, boundaries1, boundaries2 []*boundaryDetails) {
-				newVertexOccurances := make(map[geom.Vec3][]gomesh.VertexI)
-				for _, boundary1 := range boundaries1 {
-					for _, boundary2 := range boundaries2 {
-						if !boundary1.BoundingBox.Expanded(0.01).Intersects(
-							boundary2.BoundingBox.Expanded(0.01)) {
-							continue
-						}
-						// Identify any matching vertices between boundary1 and boundary2
-						// by using a sort wrapper to order the verts so that potentially
-						// colocated vertices occur consequtively.
-						verts := make([]gomesh.VertexI, 0)
-
-						// Loop over the vertices in both borders to fill in verts
-						for _, vert := range boundary1.Verts {
-							verts = append(verts, vert)
-						}
-						for _, vert := range boundary2.Verts {
-							verts = append(verts, vert)
-						}
-
-						// Sort verts so that any border vertex from m2 that is potentially
-						// collocated with a border vertex from m1 follows it directly in
-						// the array.
-						sort.Sort(gomesh.VerticesByPosition{verts})
-
-						// iterate over the sorted verts and identify pairs of verts from
-						// different meshes at the same location
-						prev_vert := verts[0]
-						for _, vert := range verts[1:] {
-							// This assumes that these vertices both appear in only one mesh already!!
-							// ... which will be true in the main use case.
-							cm, _ := vert.GetMeshLocation()
-							pm, _ := prev_vert.GetMeshLocation()
-							if pm != cm &&
-								prev_vert.GetX() == vert.GetX() &&
-								prev_vert.GetY() == vert.GetY() &&
-								prev_vert.GetZ() == vert.GetZ() {
-								// Found a matching vertex `vert`, which is shared between
-								//  boundary1 and boundary2.
-								// Record the mesh and index of both occurances of a vertex at
-								//  this location.
-								vec := geom.Vec3{vert.GetX(), vert.GetY(), vert.GetZ()}
-								newVertexOccurances[vec] = append(
-									newVertexOccurances[vec],
-									prev_vert,
-									vert,
-								)
-							}
-							prev_vert = vert
-						}
-					}
-				}
-
-				// Send newly discovered vertex occurances to the reciever to be added
-				// into the VertexOccurances map.
-				new_vertex_occurances <- newVertexOccurances
-
-			}(mesh_id1, mesh_id2, boundaries[mesh_id1], boundaries[mesh_id2])
-		}
-	}
-
-	// Recieve new_boundaries until they're all done
-	go func() {
-		wg.Wait()
-		close(new_vertex_occurances)
-	}()
-	for recieved_vertex_occurances := range new_vertex_occurances {
-		// this channel recieves once for each overlapping pair of boundaries
-		for vec, occurances := range recieved_vertex_occurances {
-			vertexOccurances[vec] = append(vertexOccurances[vec], occurances...)
-		}
-		wg.Done()
-	}
+	// Gather every boundary vertex in order of MeshId, so that everything
+	//  derived from them, down to the order in which borders are created, is
+	//  independent of the order in which goroutines happen to finish.
+	vertices := boundaryVertices(boundaries)
+	tolerance, near_miss := weldDistances(weld, vertices)
+
+	// Hash every boundary vertex into one spatial hash, and find the pairs of
+	//  vertices from different meshes within the near miss distance of each
+	//  other by looking only in the cells around each vertex.
+	hash := newWeldHash(near_miss, vertices)
+	candidates := hash.Candidates()
+
+	// Weld vertices from the closest candidates within tolerance, so that each
+	//  is welded to at most the nearest vertex of each other mesh, while
+	//  vertices of three or more meshes meeting at a junction still end up
+	//  together even if not every pair of them is within tolerance.
+	vertexOccurances, weld_report := clusterWeldCandidates(vertices, candidates, tolerance, near_miss)
+	report = weld_report
 
 	// Finally, unpack vertexOccurances to populate ss.BordersIndex and the
-	//  Borders object of each MeshWrapper.
-	new_mesh_border_verts := make(chan map[MeshId]map[BorderDescription][]gomesh.VertexI, 16)
-	for _, occurances := range vertexOccurances {
-		wg.Add(1)
-		go func(occurances []gomesh.VertexI) {
-			border_participants := make([]MeshId, 0)
-			mesh_border_verts := make(map[MeshId]map[BorderDescription][]gomesh.VertexI)
-
-			// uniqueify occurances of this vertex by mesh
-			sort.Sort(gomesh.VerticesByMesh{occurances})
-			unique_occurances := make([]gomesh.VertexI, 0, len(occurances))
-			var found bool
-			for _, occurance := range occurances {
-				found = false
-				for _, uo := range unique_occurances {
-					uo_m, _ := uo.GetMeshLocation()
-					occurance_m, _ := occurance.GetMeshLocation()
-					if occurance_m == uo_m {
-						found = true
-						break
-					}
-				}
-				if !found {
-					unique_occurances = append(unique_occurances, occurance)
-				}
-			}
-
-			// Derive the canonical description for the border this vertex belongs to
-			for _, occurance := range unique_occurances {
-				occurance_m, _ := occurance.GetMeshLocation()
-				border_participants = append(border_participants,
-					MeshIdFromString(occurance_m.GetName()))
-			}
-			sort.Sort(ByMeshIdPrecedence(border_participants))
-			border_desc := BorderDescriptionFromMeshIds(border_participants)
-
-			// Register this vertex as being the next item in the determined border
-			//  for each of the participating meshes.
-			for _, vert := range unique_occurances {
-				occurance_m, _ := vert.GetMeshLocation()
-				occurance_mid := MeshIdFromString(occurance_m.GetName())
-				if _, exists := mesh_border_verts[occurance_mid]; !exists {
-					mesh_border_verts[occurance_mid] = make(map[BorderDescription][]gomesh.VertexI)
-				}
-				mesh_border_verts[occurance_mid][border_desc] = append(
-					mesh_border_verts[occurance_mid][border_desc],
-					vert,
-				)
-			}
-
-			new_mesh_border_verts <- mesh_border_verts
-		}(occurances)
-	}
-
-	// Recieve new_mesh_border_verts until they're all done
-	go func() {
-		wg.Wait()
-		close(new_mesh_border_verts)
-	}()
+	//  Borders object of each MeshWrapper. Each cluster has at most one vertex
+	//  from each mesh, in order of MeshId. The border each location belongs to
+	//  is derived concurrently, but collected in order.
+	occurance_borders := make([]BorderDescription, len(vertexOccurances))
+	eachConcurrently(len(vertexOccurances), func(i int) {
+		// Derive the canonical description for the border this vertex belongs to
+		occurances := vertexOccurances[i]
+		border_participants := make([]MeshId, 0, len(occurances))
+		for _, occurance := range occurances {
+			border_participants = append(border_participants, vertexMeshId(occurance))
+		}
+		occurance_borders[i] = BorderDescriptionFromMeshIds(border_participants)
+	})
 
 	// A slice of all border descriptions as strings for the sake of sorting
 	border_desc_strings := make([]string, 0)
 	encountered_border_descs := make(map[BorderDescription]map[MeshId][]gomesh.VertexI)
 
-	for recieved_mesh_border_verts := range new_mesh_border_verts {
-		for mesh_id, border_verts := range recieved_mesh_border_verts {
-			for border_desc, verts := range border_verts {
-				// Lookup/Create am int-string border id for a border of this description
-				_, border_seen := encountered_border_descs[border_desc]
-				if !border_seen {
-					border_desc_strings = append(border_desc_strings, border_desc.ToString())
-					encountered_border_descs[border_desc] = make(map[MeshId][]gomesh.VertexI)
-				}
-				encountered_border_descs[border_desc][mesh_id] = append(
-					encountered_border_descs[border_desc][mesh_id],
-					verts...,
-				)
-			}
+	for i, occurances := range vertexOccurances {
+		border_desc := occurance_borders[i]
+		// Lookup/Create am int-string border id for a border of this description
+		if _, border_seen := encountered_border_descs[border_desc]; !border_seen {
+			border_desc_strings = append(border_desc_strings, border_desc.ToString())
+			encountered_border_descs[border_desc] = make(map[MeshId][]gomesh.VertexI)
+		}
+		// Register this vertex as being the next item in the determined border
+		//  for each of the participating meshes.
+		for _, vert := range occurances {
+			mesh_id := vertexMeshId(vert)
+			encountered_border_descs[border_desc][mesh_id] = append(
+				encountered_border_descs[border_desc][mesh_id],
+				vert,
+			)
 		}
-		wg.Done()
 	}
 
 	// Create borders in string sorted order
@@ -242,13 +133,15 @@ func (ss *ShapeSet) IndexBorders() (err error) {
 	for border_desc, bmap := range encountered_border_descs {
 		border := ss.BordersIndex.BorderFor(border_desc)
 
-		// single out border vertices of first mesh to be the border vertices
-		var first_mesh_verts []gomesh.VertexI
-		for first_mesh_id, border_verts := range bmap {
-			first_mesh_verts = border_verts
-			delete(bmap, first_mesh_id)
-			break
+		// single out border vertices of the lowest mesh to be the border vertices,
+		// so that welded vertices consistently take its positions
+		border_mesh_ids := make(ByMeshIdPrecedence, 0, len(bmap))
+		for mesh_id, _ := range bmap {
+			border_mesh_ids = append(border_mesh_ids, mesh_id)
 		}
+		sort.Sort(border_mesh_ids)
+		first_mesh_verts := bmap[border_mesh_ids[0]]
+		delete(bmap, border_mesh_ids[0])
 		for _, v1I := range first_mesh_verts {
 			v1 := v1I.(*Vertex)
 			v1.Border = border
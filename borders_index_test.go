@@ -0,0 +1,149 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestIndexBordersIsDeterministic(t *testing.T) {
+	positions, mesh_faces := boxRow()
+	var first map[BorderId]string
+	for run := 0; run < 10; run++ {
+		ss := shapeSetFromBuffers(t, positions, mesh_faces)
+		if err := ss.IndexBorders(); err != nil {
+			t.Fatal(err)
+		}
+		summaries := borderSummaries(ss)
+		if run == 0 {
+			first = summaries
+			continue
+		}
+		if !reflect.DeepEqual(summaries, first) {
+			t.Fatalf("Borders differ between runs:\n%v\n%v", first, summaries)
+		}
+	}
+
+	expected := map[BorderId]string{1: "0-1_0-2_1-2", 2: "0-2_0-3_2-3"}
+	ss := shapeSetFromBuffers(t, positions, mesh_faces)
+	if err := ss.IndexBorders(); err != nil {
+		t.Fatal(err)
+	}
+	for border_id, description := range expected {
+		b := ss.BordersIndex.BorderFor(border_id)
+		if b == nil {
+			t.Fatalf("Missing border %d", border_id)
+		}
+		if desc := b.Description(); desc.ToString() != description || b.Len() != 4 {
+			t.Errorf("Expected border %d to be %s with 4 vertices, got %s with %d",
+				border_id, description, desc.ToString(), b.Len())
+		}
+	}
+	if report := ss.VerifyBorders(); !report.Ok() {
+		t.Errorf("Expected consistent borders, got %+v", report.Violations)
+	}
+}
+
+// Moves the vertices of a mesh by offset, giving it its own copies of any
+// positions it shares with other meshes.
+func offsetMesh(
+	positions []geom.Vec3,
+	mesh_faces map[MeshId][][3]int,
+	mesh_id MeshId,
+	offset geom.Vec3,
+) ([]geom.Vec3, map[MeshId][][3]int) {
+	positions = append([]geom.Vec3{}, positions...)
+	mesh_faces = flipFaces(mesh_faces, mesh_id)
+	moved := make(map[int]int)
+	for fi, face := range mesh_faces[mesh_id] {
+		for i, pi := range face {
+			index, exists := moved[pi]
+			if !exists {
+				index = len(positions)
+				p := positions[pi]
+				positions = append(positions, geom.Vec3{p.X + offset.X, p.Y + offset.Y, p.Z + offset.Z})
+				moved[pi] = index
+			}
+			mesh_faces[mesh_id][fi][i] = index
+		}
+	}
+	return positions, mesh_faces
+}
+
+func TestIndexBordersWithTolerance(t *testing.T) {
+	positions, mesh_faces := boxRow()
+	positions, mesh_faces = offsetMesh(positions, mesh_faces, MeshId{1, 2}, geom.Vec3{0.001, 0.001, 0})
+
+	// exactly, the mesh between shapes 1 and 2 is left out of the border
+	ss := shapeSetFromBuffers(t, positions, mesh_faces)
+	report, err := ss.IndexBordersWithTolerance(ExactWeld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Unpartnered) != 4 {
+		t.Errorf("Expected 4 unpartnered vertices, got %d", len(report.Unpartnered))
+	}
+	if ss.BordersIndex.BorderFor(BorderDescriptionFromString("0-1_0-2")) == nil {
+		t.Errorf("Expected a border without mesh 1-2, got %v", borderSummaries(ss))
+	}
+
+	// within tolerance it's welded in, at the positions of the lowest mesh
+	ss = shapeSetFromBuffers(t, positions, mesh_faces)
+	report, err = ss.IndexBordersWithTolerance(WeldTolerance{Distance: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Unpartnered) != 0 || len(report.NearMisses) != 0 {
+		t.Errorf("Expected every vertex to be welded, got %+v", report)
+	}
+	b := ss.BordersIndex.BorderFor(BorderDescriptionFromString("0-1_0-2_1-2"))
+	if b == nil || b.Len() != 4 {
+		t.Fatalf("Expected a border of 4 vertices with mesh 1-2, got %v", borderSummaries(ss))
+	}
+	for _, v := range b.Vertices {
+		if v.X != 1 {
+			t.Errorf("Expected welded vertices to take the positions of mesh 0-1, got %v", v.Vec3)
+		}
+	}
+	if report := ss.VerifyBorders(); !report.Ok() {
+		t.Errorf("Expected consistent borders, got %+v", report.Violations)
+	}
+}
+
+func TestWeldHashCandidates(t *testing.T) {
+	// vertices scattered over several cells, including negative coordinates,
+	// with some at exactly the same positions
+	random := rand.New(rand.NewSource(1))
+	vertices := make([]weldVertex, 0)
+	for i := 0; i < 600; i++ {
+		position := geom.Vec3{random.Float64()*4 - 2, random.Float64()*4 - 2, random.Float64() - 0.5}
+		if i%10 == 9 {
+			position = vertices[i-1].Position
+		}
+		vertices = append(vertices, weldVertex{MeshId: MeshId{0, ShapeId(i%3 + 1)}, Position: position})
+	}
+
+	for _, distance := range []float64{0, 0.1, 0.3} {
+		// compare against every pair of vertices from different meshes
+		expected := make([]weldCandidate, 0)
+		for i, v := range vertices {
+			for j := i + 1; j < len(vertices); j++ {
+				p, q := v.Position, vertices[j].Position
+				d := math.Sqrt((q.X-p.X)*(q.X-p.X) + (q.Y-p.Y)*(q.Y-p.Y) + (q.Z-p.Z)*(q.Z-p.Z))
+				if v.MeshId != vertices[j].MeshId && d <= distance {
+					expected = append(expected, weldCandidate{i, j, d})
+				}
+			}
+		}
+		if distance == 0 && len(expected) == 0 {
+			t.Fatalf("Expected some vertices at the same positions")
+		}
+		candidates := newWeldHash(distance, vertices).Candidates()
+		if !reflect.DeepEqual(candidates, expected) {
+			t.Errorf("Expected %d candidates within %g in order, got %d",
+				len(expected), distance, len(candidates))
+		}
+	}
+}
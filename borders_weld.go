@@ -0,0 +1,410 @@
+package shapeset
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* The distance within which boundary vertices of different meshes are welded
+ * together when indexing borders. If Relative, distances are multiples of the
+ * length of the diagonal of the bounding box of the boundary vertices. Pairs of
+ * vertices which aren't welded but lie within NearMiss of one another are
+ * reported, which defaults to twice the Distance.
+ */
+type WeldTolerance struct {
+	Distance float64
+	Relative bool
+	NearMiss float64
+}
+
+// Only welds vertices at exactly the same position
+var ExactWeld = WeldTolerance{}
+
+const weldNearMissFactor = 2
+
+// A pair of boundary vertices from different meshes which weren't welded to
+// each other
+type WeldPair struct {
+	MeshIds   [2]MeshId
+	Positions [2]geom.Vec3
+	Distance  float64
+}
+
+// A boundary vertex which wasn't welded to a vertex of any other mesh
+type UnpartneredVertex struct {
+	MeshId   MeshId
+	Position geom.Vec3
+}
+
+type WeldReport struct {
+	// The absolute distances applied
+	Tolerance float64
+	NearMiss  float64
+	// The number of locations at which vertices of different meshes were welded
+	Welded int
+	// Pairs within the near miss distance which weren't welded, including any
+	// within tolerance which would have welded two vertices of a mesh together
+	NearMisses  []WeldPair
+	Unpartnered []UnpartneredVertex
+}
+
+/* Parses a weld tolerance as given on the command line, as a distance,
+ * optionally followed by a comma and the near miss distance. Distances ending
+ * in % are percentages of the diagonal of the bounding box, in which case both
+ * must be.
+ */
+func ParseWeldTolerance(spec string) (weld WeldTolerance, err error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) > 2 {
+		err = errors.New("Weld tolerance must be <distance> or <distance>,<near miss>")
+		return
+	}
+	weld.Relative = strings.HasSuffix(parts[0], "%")
+	distances := make([]float64, len(parts))
+	for i, part := range parts {
+		if strings.HasSuffix(part, "%") != weld.Relative {
+			err = errors.New("Weld tolerance and near miss must both be relative or absolute")
+			return
+		}
+		distances[i], err = strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+		if err != nil {
+			return
+		}
+		if distances[i] < 0 {
+			err = errors.New("Weld tolerance must not be negative")
+			return
+		}
+		if weld.Relative {
+			distances[i] /= 100
+		}
+	}
+	weld.Distance = distances[0]
+	if len(distances) > 1 {
+		weld.NearMiss = distances[1]
+	}
+	return
+}
+
+// Resolves the weld tolerance and near miss distance to absolute distances,
+// measuring relative distances against the bounds of the boundary vertices as
+// they currently are.
+func weldDistances(weld WeldTolerance, vertices []weldVertex) (tolerance, near_miss float64) {
+	tolerance, near_miss = weld.Distance, weld.NearMiss
+	if near_miss == 0 {
+		near_miss = weldNearMissFactor * tolerance
+	}
+	near_miss = math.Max(near_miss, tolerance)
+	if weld.Relative && len(vertices) > 0 {
+		var bounds MeshContents
+		for i, v := range vertices {
+			bounds.extendBounds(i == 0, v.Position.X, v.Position.Y, v.Position.Z)
+		}
+		size := geom.Vec3{
+			bounds.Max.X - bounds.Min.X,
+			bounds.Max.Y - bounds.Min.Y,
+			bounds.Max.Z - bounds.Min.Z,
+		}
+		diagonal := math.Sqrt(size.X*size.X + size.Y*size.Y + size.Z*size.Z)
+		tolerance, near_miss = tolerance*diagonal, near_miss*diagonal
+	}
+	return
+}
+
+func vertexMeshId(v gomesh.VertexI) MeshId {
+	m, _ := v.GetMeshLocation()
+	return MeshIdFromString(m.GetName())
+}
+
+func vertexPosition(v gomesh.VertexI) geom.Vec3 {
+	return geom.Vec3{v.GetX(), v.GetY(), v.GetZ()}
+}
+
+// A boundary vertex, with its mesh and position resolved for matching
+type weldVertex struct {
+	Vertex   gomesh.VertexI
+	MeshId   MeshId
+	Position geom.Vec3
+}
+
+// Lists the vertices of every boundary once each, in order of MeshId and then
+// of the mesh's boundaries.
+func boundaryVertices(boundaries map[MeshId][]*boundaryDetails) (vertices []weldVertex) {
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(boundaries))
+	for mesh_id, _ := range boundaries {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+	listed := make(map[gomesh.VertexI]bool)
+	for _, mesh_id := range mesh_ids {
+		for _, boundary := range boundaries[mesh_id] {
+			for _, v := range boundary.Verts {
+				if listed[v] {
+					continue
+				}
+				listed[v] = true
+				vertices = append(vertices, weldVertex{v, mesh_id, vertexPosition(v)})
+			}
+		}
+	}
+	return
+}
+
+// A pair of boundary vertices from different meshes within the near miss
+// distance of one another, as indices into the list of boundary vertices with
+// I < J.
+type weldCandidate struct {
+	I, J     int
+	Distance float64
+}
+
+/* A spatial hash of vertices in cubic cells with sides of the search distance,
+ * so that the vertices within that distance of a point lie in the 27 cells
+ * around it. A distance of zero hashes vertices by their exact position.
+ *
+ * Cells are divided between shards which are built concurrently, each cell
+ * listing the indices of its vertices in ascending order.
+ */
+type weldHash struct {
+	distance float64
+	vertices []weldVertex
+	shards   []map[[3]int64][]int
+}
+
+func newWeldHash(distance float64, vertices []weldVertex) (hash *weldHash) {
+	hash = &weldHash{
+		distance: distance,
+		vertices: vertices,
+		shards:   make([]map[[3]int64][]int, runtime.NumCPU()),
+	}
+	keys := make([][3]int64, len(vertices))
+	eachConcurrently(len(vertices), func(i int) {
+		keys[i] = hash.cell(vertices[i].Position)
+	})
+	shard_vertices := make([][]int, len(hash.shards))
+	for i, key := range keys {
+		shard := hash.shardOf(key)
+		shard_vertices[shard] = append(shard_vertices[shard], i)
+	}
+
+	var wg sync.WaitGroup
+	for shard := range hash.shards {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			cells := make(map[[3]int64][]int)
+			for _, i := range shard_vertices[shard] {
+				cells[keys[i]] = append(cells[keys[i]], i)
+			}
+			hash.shards[shard] = cells
+		}(shard)
+	}
+	wg.Wait()
+	return
+}
+
+func (hash *weldHash) cell(p geom.Vec3) (key [3]int64) {
+	for axis, x := range [3]float64{p.X, p.Y, p.Z} {
+		if hash.distance == 0 {
+			// equal coordinates must share a key, including 0 and -0
+			if x == 0 {
+				x = 0
+			}
+			key[axis] = int64(math.Float64bits(x))
+		} else {
+			key[axis] = int64(math.Floor(x / hash.distance))
+		}
+	}
+	return
+}
+
+func (hash *weldHash) shardOf(key [3]int64) int {
+	mixed := uint64(key[0])*73856093 ^ uint64(key[1])*19349663 ^ uint64(key[2])*83492791
+	return int(mixed % uint64(len(hash.shards)))
+}
+
+// Calls fn with the index of each hashed vertex of a different mesh within the
+// search distance of the vertex at index i, and its distance from it.
+func (hash *weldHash) EachNear(i int, fn func(j int, distance float64)) {
+	v := hash.vertices[i]
+	p := v.Position
+	visit := func(key [3]int64) {
+		for _, j := range hash.shards[hash.shardOf(key)][key] {
+			if hash.vertices[j].MeshId == v.MeshId {
+				continue
+			}
+			q := hash.vertices[j].Position
+			distance := math.Sqrt((q.X-p.X)*(q.X-p.X) + (q.Y-p.Y)*(q.Y-p.Y) + (q.Z-p.Z)*(q.Z-p.Z))
+			if distance <= hash.distance {
+				fn(j, distance)
+			}
+		}
+	}
+	key := hash.cell(p)
+	if hash.distance == 0 {
+		visit(key)
+		return
+	}
+	for dz := int64(-1); dz <= 1; dz++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for dx := int64(-1); dx <= 1; dx++ {
+				visit([3]int64{key[0] + dx, key[1] + dy, key[2] + dz})
+			}
+		}
+	}
+}
+
+// Finds every pair of hashed vertices from different meshes within the search
+// distance of each other concurrently, ordered by their indices.
+func (hash *weldHash) Candidates() (candidates []weldCandidate) {
+	vertex_candidates := make([][]weldCandidate, len(hash.vertices))
+	eachConcurrently(len(hash.vertices), func(i int) {
+		hash.EachNear(i, func(j int, distance float64) {
+			if j > i {
+				vertex_candidates[i] = append(vertex_candidates[i], weldCandidate{i, j, distance})
+			}
+		})
+	})
+	candidates = make([]weldCandidate, 0)
+	for i, near := range vertex_candidates {
+		sort.Slice(near, func(a, b int) bool { return near[a].J < near[b].J })
+		candidates = append(candidates, near...)
+		vertex_candidates[i] = nil
+	}
+	return
+}
+
+/* Groups vertices into clusters to be welded, and reports the candidates which
+ * weren't welded and the boundary vertices left out of every cluster. Clusters
+ * are ordered by their first vertex, and list their vertices in order.
+ *
+ * Candidates within tolerance are taken from the closest, each joining the
+ * clusters of its vertices unless that would put two vertices of the same mesh
+ * in one cluster. So each vertex is welded to no more than one vertex of each
+ * other mesh, the nearest available, and vertices of three or more meshes
+ * meeting at a junction still end up together even if not every pair of them
+ * is within tolerance. Welds don't chain along a border whose vertices are
+ * closer together than the tolerance, as the candidates which would chain them
+ * are reported as near misses instead.
+ */
+func clusterWeldCandidates(
+	vertices []weldVertex,
+	candidates []weldCandidate,
+	tolerance, near_miss float64,
+) (clusters [][]gomesh.VertexI, report *WeldReport) {
+	parents := make([]int, len(vertices))
+	cluster_meshes := make([][]MeshId, len(vertices))
+	for i := range parents {
+		parents[i] = i
+		cluster_meshes[i] = []MeshId{vertices[i].MeshId}
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	shares_mesh := func(a, b int) bool {
+		for _, mesh_a := range cluster_meshes[a] {
+			for _, mesh_b := range cluster_meshes[b] {
+				if mesh_a == mesh_b {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	within := make([]weldCandidate, 0)
+	for _, candidate := range candidates {
+		if candidate.Distance <= tolerance {
+			within = append(within, candidate)
+		}
+	}
+	sort.SliceStable(within, func(i, j int) bool {
+		return within[i].Distance < within[j].Distance
+	})
+	clustered := make([]bool, len(vertices))
+	for _, candidate := range within {
+		a, b := find(candidate.I), find(candidate.J)
+		if a == b || shares_mesh(a, b) {
+			continue
+		}
+		clustered[candidate.I], clustered[candidate.J] = true, true
+		// the root of each cluster is kept as its lowest index
+		if b < a {
+			a, b = b, a
+		}
+		parents[b] = a
+		cluster_meshes[a] = append(cluster_meshes[a], cluster_meshes[b]...)
+		cluster_meshes[b] = nil
+	}
+
+	report = &WeldReport{
+		Tolerance:   tolerance,
+		NearMiss:    near_miss,
+		NearMisses:  make([]WeldPair, 0),
+		Unpartnered: make([]UnpartneredVertex, 0),
+	}
+
+	cluster_indices := make(map[int]int)
+	for i, v := range vertices {
+		if !clustered[i] {
+			report.Unpartnered = append(report.Unpartnered, UnpartneredVertex{
+				MeshId:   v.MeshId,
+				Position: v.Position,
+			})
+			continue
+		}
+		root := find(i)
+		index, exists := cluster_indices[root]
+		if !exists {
+			index = len(clusters)
+			cluster_indices[root] = index
+			clusters = append(clusters, make([]gomesh.VertexI, 0, 2))
+		}
+		clusters[index] = append(clusters[index], v.Vertex)
+	}
+	report.Welded = len(clusters)
+
+	// every candidate whose vertices weren't welded together is a near miss,
+	// including those within tolerance which would have welded two vertices of
+	// one mesh together
+	for _, candidate := range candidates {
+		if clustered[candidate.I] && clustered[candidate.J] &&
+			find(candidate.I) == find(candidate.J) {
+			continue
+		}
+		v1, v2 := vertices[candidate.I], vertices[candidate.J]
+		report.NearMisses = append(report.NearMisses, WeldPair{
+			MeshIds:   [2]MeshId{v1.MeshId, v2.MeshId},
+			Positions: [2]geom.Vec3{v1.Position, v2.Position},
+			Distance:  candidate.Distance,
+		})
+	}
+	sort.SliceStable(report.NearMisses, func(i, j int) bool {
+		return report.NearMisses[i].Distance < report.NearMisses[j].Distance
+	})
+	return
+}
+
+// Writes the report as indented JSON
+func (report *WeldReport) WriteJSON(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(report); err != nil {
+		return
+	}
+	return bw.Flush()
+}
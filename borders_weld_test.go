@@ -0,0 +1,202 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"math"
+	"testing"
+)
+
+// Two rows of boundary vertices a unit apart along x, from the meshes 0-1 and
+// 0-2, with the second row offset by 0.1.
+func weldRows(count int) (vertices []weldVertex) {
+	for _, mesh_id := range []MeshId{{0, 1}, {0, 2}} {
+		offset := 0.1 * float64(mesh_id[1]-1)
+		for i := 0; i < count; i++ {
+			vertices = append(vertices, weldVertex{
+				MeshId:   mesh_id,
+				Position: geom.Vec3{float64(i) + offset, 0, 0},
+			})
+		}
+	}
+	return
+}
+
+// Welds the vertices within tolerance, identifying the vertices of each
+// cluster by their indices
+func weldClusters(vertices []weldVertex, tolerance float64) (clusters [][]int, report *WeldReport) {
+	indices := make(map[gomesh.VertexI]int)
+	for i := range vertices {
+		vertices[i].Vertex = &Vertex{}
+		indices[vertices[i].Vertex] = i
+	}
+	near_miss := weldNearMissFactor * tolerance
+	candidates := newWeldHash(near_miss, vertices).Candidates()
+	vertex_clusters, report := clusterWeldCandidates(vertices, candidates, tolerance, near_miss)
+	for _, cluster := range vertex_clusters {
+		cluster_indices := make([]int, 0, len(cluster))
+		for _, v := range cluster {
+			cluster_indices = append(cluster_indices, indices[v])
+		}
+		clusters = append(clusters, cluster_indices)
+	}
+	return
+}
+
+func TestParseWeldTolerance(t *testing.T) {
+	cases := []struct {
+		spec     string
+		expected WeldTolerance
+	}{
+		{"0", WeldTolerance{}},
+		{"0.01", WeldTolerance{Distance: 0.01}},
+		{"0.01,0.05", WeldTolerance{Distance: 0.01, NearMiss: 0.05}},
+		{"1%", WeldTolerance{Distance: 0.01, Relative: true}},
+		{"1%,2%", WeldTolerance{Distance: 0.01, Relative: true, NearMiss: 0.02}},
+	}
+	for _, c := range cases {
+		weld, err := ParseWeldTolerance(c.spec)
+		if err != nil || weld != c.expected {
+			t.Errorf("ParseWeldTolerance(%q) = %+v, %v, expected %+v", c.spec, weld, err, c.expected)
+		}
+	}
+	for _, spec := range []string{"-1", "1%,0.5", "x", "1,2,3"} {
+		if _, err := ParseWeldTolerance(spec); err == nil {
+			t.Errorf("ParseWeldTolerance(%q) should fail", spec)
+		}
+	}
+}
+
+// Checks that each vertex of the first row is welded to just the vertex of the
+// second row with the same index.
+func checkRowsWelded(t *testing.T, clusters [][]int, count int) {
+	if len(clusters) != count {
+		t.Fatalf("Expected %d welds, got %d", count, len(clusters))
+	}
+	for i, cluster := range clusters {
+		if len(cluster) != 2 || cluster[0] != i || cluster[1] != i+count {
+			t.Errorf("Expected vertices %d and %d to be welded, got %v", i, i+count, cluster)
+		}
+	}
+}
+
+func TestWeldBelowVertexSpacing(t *testing.T) {
+	clusters, report := weldClusters(weldRows(10), 0.2)
+	checkRowsWelded(t, clusters, 10)
+	if report.Welded != 10 {
+		t.Errorf("Expected 10 welds to be reported, got %d", report.Welded)
+	}
+	if len(report.NearMisses) != 0 || len(report.Unpartnered) != 0 {
+		t.Errorf("Expected no near misses or unpartnered vertices, got %d and %d",
+			len(report.NearMisses), len(report.Unpartnered))
+	}
+}
+
+func TestWeldAboveVertexSpacing(t *testing.T) {
+	// with a tolerance spanning several vertices each must still only be welded
+	// to its nearest partner, rather than the whole row collapsing together
+	clusters, report := weldClusters(weldRows(10), 2.5)
+	checkRowsWelded(t, clusters, 10)
+	if len(report.Unpartnered) != 0 {
+		t.Errorf("Expected no unpartnered vertices, got %d", len(report.Unpartnered))
+	}
+	if len(report.NearMisses) == 0 {
+		t.Errorf("Expected the pairs which weren't welded to be near misses")
+	}
+	for _, near_miss := range report.NearMisses {
+		if near_miss.Distance < 0.85 {
+			t.Errorf("Nearest partners weren't welded: %+v", near_miss)
+		}
+	}
+}
+
+func TestWeldJunction(t *testing.T) {
+	// three meshes meet, but the first and last vertices aren't within tolerance
+	vertices := []weldVertex{
+		{MeshId: MeshId{0, 1}, Position: geom.Vec3{0, 0, 0}},
+		{MeshId: MeshId{0, 2}, Position: geom.Vec3{0.09, 0, 0}},
+		{MeshId: MeshId{1, 2}, Position: geom.Vec3{0.18, 0, 0}},
+	}
+	clusters, report := weldClusters(vertices, 0.1)
+	if len(clusters) != 1 || len(clusters[0]) != 3 {
+		t.Fatalf("Expected one weld of 3 vertices, got %v", clusters)
+	}
+	if len(report.Unpartnered) != 0 {
+		t.Errorf("Expected no unpartnered vertices, got %d", len(report.Unpartnered))
+	}
+}
+
+func TestWeldSameMeshCollision(t *testing.T) {
+	// both vertices of mesh 0-1 are within tolerance of the vertex of mesh 0-2,
+	// but only the nearer may be welded to it
+	vertices := []weldVertex{
+		{MeshId: MeshId{0, 1}, Position: geom.Vec3{0, 0, 0}},
+		{MeshId: MeshId{0, 1}, Position: geom.Vec3{0.05, 0, 0}},
+		{MeshId: MeshId{0, 2}, Position: geom.Vec3{0.02, 0, 0}},
+	}
+	clusters, report := weldClusters(vertices, 0.1)
+	if len(clusters) != 1 || len(clusters[0]) != 2 {
+		t.Fatalf("Expected one weld of 2 vertices, got %v", clusters)
+	}
+	if len(report.Unpartnered) != 1 || report.Unpartnered[0].Position.X != 0.05 {
+		t.Errorf("Expected the farther vertex to be unpartnered, got %+v", report.Unpartnered)
+	}
+	if len(report.NearMisses) != 1 || math.Abs(report.NearMisses[0].Distance-0.03) > 1e-9 {
+		t.Errorf("Expected the collision to be reported as a near miss, got %+v", report.NearMisses)
+	}
+}
+
+func TestWeldExactOnly(t *testing.T) {
+	vertices := weldRows(10)
+	vertices = append(vertices, weldVertex{MeshId: MeshId{0, 3}, Position: geom.Vec3{1, 0, 0}})
+	clusters, report := weldClusters(vertices, 0)
+	if len(clusters) != 1 || len(clusters[0]) != 2 {
+		t.Fatalf("Expected one weld of 2 vertices, got %v", clusters)
+	}
+	if len(report.Unpartnered) != 19 {
+		t.Errorf("Expected 19 unpartnered vertices, got %d", len(report.Unpartnered))
+	}
+}
+
+func TestWeldDistances(t *testing.T) {
+	vertices := []weldVertex{
+		{Position: geom.Vec3{1, 0, 2}},
+		{Position: geom.Vec3{4, 4, 2}},
+		{Position: geom.Vec3{2, 1, 2}},
+	}
+	for _, c := range []struct {
+		weld                 WeldTolerance
+		tolerance, near_miss float64
+	}{
+		{WeldTolerance{Distance: 0.1}, 0.1, 0.2},
+		{WeldTolerance{Distance: 0.1, NearMiss: 0.05}, 0.1, 0.1},
+		// the boundary vertices span a diagonal of 5
+		{WeldTolerance{Distance: 0.01, Relative: true}, 0.05, 0.1},
+		{WeldTolerance{Distance: 0.01, NearMiss: 0.03, Relative: true}, 0.05, 0.15},
+	} {
+		tolerance, near_miss := weldDistances(c.weld, vertices)
+		if math.Abs(tolerance-c.tolerance) > 1e-9 || math.Abs(near_miss-c.near_miss) > 1e-9 {
+			t.Errorf("Expected %+v to resolve to %g and %g, got %g and %g",
+				c.weld, c.tolerance, c.near_miss, tolerance, near_miss)
+		}
+	}
+}
+
+func TestIndexBordersWithRelativeTolerance(t *testing.T) {
+	// the boxes are scaled up tenfold after their bounds were first measured
+	positions, mesh_faces := boxRow()
+	ss := shapeSetFromBuffers(t, positions, mesh_faces)
+	ss.BoundingBox()
+	ss.ScaleAndCenter(30)
+	report, err := ss.IndexBordersWithTolerance(WeldTolerance{Distance: 0.01, Relative: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the boundaries are the squares where the boxes meet, 10 apart
+	if expected := 0.01 * math.Sqrt(300); math.Abs(report.Tolerance-expected) > 1e-9 {
+		t.Errorf("Expected a tolerance of %g, got %g", expected, report.Tolerance)
+	}
+	if report.Welded != 8 || len(report.Unpartnered) != 0 {
+		t.Errorf("Expected the 8 corners of the borders to be welded, got %+v", report)
+	}
+}
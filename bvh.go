@@ -0,0 +1,350 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+/* A bounding volume hierarchy over the faces of every mesh in a shapeset, for
+ * answering point location and ray picking queries.
+ *
+ * The hierarchy is a snapshot of the shapeset when it was built, so must be
+ * rebuilt after the meshes are modified. Queries don't modify it, so may be
+ * made concurrently.
+ */
+type BVH struct {
+	positions  []geom.Vec3
+	faces      [][3]int
+	faceMeshes []MeshId
+	faceIndex  []int // the index of each face within its mesh
+	nodes      []bvhNode
+}
+
+// A node of the hierarchy bounding either two child nodes, or if it's a leaf
+// (Count > 0), Count faces from First.
+type bvhNode struct {
+	Min, Max    [3]float64
+	Left, Right int
+	First       int
+	Count       int
+}
+
+const bvhLeafSize = 4
+
+type Ray struct {
+	Origin    geom.Vec3
+	Direction geom.Vec3
+}
+
+// Describes the nearest face of an interface mesh crossed by a ray
+type RayHit struct {
+	MeshId MeshId
+	// The index of the face within the mesh
+	Face int
+	// The barycentric coordinates of the hit relative to the face's vertices
+	Barycentric [3]float64
+	Point       geom.Vec3
+	// The distance to the hit in multiples of the ray direction
+	Distance float64
+	// The shapes in front of and behind the face, i.e. MeshId[0] and MeshId[1]
+	Front ShapeId
+	Back  ShapeId
+	// The shape the ray passes into through the face
+	Entering ShapeId
+}
+
+// Builds a BVH over the current faces of every mesh
+func (ss *ShapeSet) BuildBVH() (bvh *BVH) {
+	positions, mesh_faces := ss.sharedFaceBuffers()
+	return newBVH(positions, mesh_faces)
+}
+
+func newBVH(positions []geom.Vec3, mesh_faces map[MeshId][][3]int) (bvh *BVH) {
+	bvh = &BVH{positions: positions}
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(mesh_faces))
+	for mesh_id, _ := range mesh_faces {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+	for _, mesh_id := range mesh_ids {
+		for i, face := range mesh_faces[mesh_id] {
+			bvh.faces = append(bvh.faces, face)
+			bvh.faceMeshes = append(bvh.faceMeshes, mesh_id)
+			bvh.faceIndex = append(bvh.faceIndex, i)
+		}
+	}
+
+	// the faces are reordered in place so that each leaf holds a contiguous run
+	order := make([]int, len(bvh.faces))
+	centroids := make([][3]float64, len(bvh.faces))
+	for i, face := range bvh.faces {
+		order[i] = i
+		for _, pi := range face {
+			p := positions[pi]
+			centroids[i][0] += p.X / 3
+			centroids[i][1] += p.Y / 3
+			centroids[i][2] += p.Z / 3
+		}
+	}
+	if len(order) > 0 {
+		bvh.buildRange(order, centroids, 0, len(order))
+	}
+
+	faces := make([][3]int, len(order))
+	face_meshes := make([]MeshId, len(order))
+	face_index := make([]int, len(order))
+	for i, fi := range order {
+		faces[i], face_meshes[i], face_index[i] = bvh.faces[fi], bvh.faceMeshes[fi], bvh.faceIndex[fi]
+	}
+	bvh.faces, bvh.faceMeshes, bvh.faceIndex = faces, face_meshes, face_index
+	return
+}
+
+// Recursively builds nodes over the faces order[first:first+count] by
+// splitting at the median centroid along the longest axis of the centroids'
+// bounds, returning the index of the node.
+func (bvh *BVH) buildRange(order []int, centroids [][3]float64, first, count int) int {
+	node := bvhNode{
+		Min: [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)},
+		Max: [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)},
+	}
+	centroid_min := node.Min
+	centroid_max := node.Max
+	for _, fi := range order[first : first+count] {
+		for _, pi := range bvh.faces[fi] {
+			p := bvh.positions[pi]
+			for axis, x := range [3]float64{p.X, p.Y, p.Z} {
+				node.Min[axis] = math.Min(node.Min[axis], x)
+				node.Max[axis] = math.Max(node.Max[axis], x)
+			}
+		}
+		for axis := 0; axis < 3; axis++ {
+			centroid_min[axis] = math.Min(centroid_min[axis], centroids[fi][axis])
+			centroid_max[axis] = math.Max(centroid_max[axis], centroids[fi][axis])
+		}
+	}
+
+	index := len(bvh.nodes)
+	bvh.nodes = append(bvh.nodes, node)
+	if count <= bvhLeafSize {
+		bvh.nodes[index].First = first
+		bvh.nodes[index].Count = count
+		return index
+	}
+
+	split_axis := 0
+	for axis := 1; axis < 3; axis++ {
+		if centroid_max[axis]-centroid_min[axis] > centroid_max[split_axis]-centroid_min[split_axis] {
+			split_axis = axis
+		}
+	}
+	faces := order[first : first+count]
+	sort.Slice(faces, func(i, j int) bool {
+		return centroids[faces[i]][split_axis] < centroids[faces[j]][split_axis]
+	})
+	half := count / 2
+	left := bvh.buildRange(order, centroids, first, half)
+	right := bvh.buildRange(order, centroids, first+half, count-half)
+	bvh.nodes[index].Left = left
+	bvh.nodes[index].Right = right
+	return index
+}
+
+// Finds the distance along the ray at which it enters the node's bounds, if it
+// does so before max_t.
+func (node *bvhNode) rayEntry(origin, inv_dir [3]float64, max_t float64) (t float64, hit bool) {
+	t_min, t_max := 0.0, max_t
+	for axis := 0; axis < 3; axis++ {
+		t1 := (node.Min[axis] - origin[axis]) * inv_dir[axis]
+		t2 := (node.Max[axis] - origin[axis]) * inv_dir[axis]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		// NaN arises for rays parallel to and within a slab's boundary
+		if !math.IsNaN(t1) {
+			t_min = math.Max(t_min, t1)
+		}
+		if !math.IsNaN(t2) {
+			t_max = math.Min(t_max, t2)
+		}
+		if t_min > t_max {
+			return
+		}
+	}
+	return t_min, true
+}
+
+/* Finds the nearest face crossed by the ray at a positive distance, or nil if
+ * the ray crosses no faces. The direction need not be normalized.
+ */
+func (bvh *BVH) Raycast(origin, direction geom.Vec3) (hit *RayHit) {
+	if len(bvh.nodes) == 0 {
+		return
+	}
+	o := [3]float64{origin.X, origin.Y, origin.Z}
+	inv_dir := [3]float64{1 / direction.X, 1 / direction.Y, 1 / direction.Z}
+
+	nearest_t := math.Inf(1)
+	nearest_face := -1
+	var nearest_uv [2]float64
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &bvh.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if _, entered := node.rayEntry(o, inv_dir, nearest_t); !entered {
+			continue
+		}
+		if node.Count > 0 {
+			for fi := node.First; fi < node.First+node.Count; fi++ {
+				face := bvh.faces[fi]
+				t, u, v, crossed := rayTriangle(origin, direction,
+					bvh.positions[face[0]], bvh.positions[face[1]], bvh.positions[face[2]])
+				if crossed && t < nearest_t {
+					nearest_t, nearest_face, nearest_uv = t, fi, [2]float64{u, v}
+				}
+			}
+			continue
+		}
+		// visit the nearer child first by pushing it last
+		left_t, left_hit := bvh.nodes[node.Left].rayEntry(o, inv_dir, nearest_t)
+		right_t, right_hit := bvh.nodes[node.Right].rayEntry(o, inv_dir, nearest_t)
+		if left_hit && right_hit && left_t < right_t {
+			stack = append(stack, node.Right, node.Left)
+		} else {
+			if left_hit {
+				stack = append(stack, node.Left)
+			}
+			if right_hit {
+				stack = append(stack, node.Right)
+			}
+		}
+	}
+	if nearest_face < 0 {
+		return
+	}
+
+	face := bvh.faces[nearest_face]
+	a, b, c := bvh.positions[face[0]], bvh.positions[face[1]], bvh.positions[face[2]]
+	mesh_id := bvh.faceMeshes[nearest_face]
+	u, v := nearest_uv[0], nearest_uv[1]
+	hit = &RayHit{
+		MeshId:      mesh_id,
+		Face:        bvh.faceIndex[nearest_face],
+		Barycentric: [3]float64{1 - u - v, u, v},
+		Point: geom.Vec3{
+			origin.X + direction.X*nearest_t,
+			origin.Y + direction.Y*nearest_t,
+			origin.Z + direction.Z*nearest_t,
+		},
+		Distance: nearest_t,
+		Front:    mesh_id[0],
+		Back:     mesh_id[1],
+		Entering: mesh_id[0],
+	}
+	// normals point towards the front shape, so a ray travelling against the
+	// normal passes from the front shape into the back shape
+	normal := triangleNormal(a, b, c)
+	if normal.X*direction.X+normal.Y*direction.Y+normal.Z*direction.Z < 0 {
+		hit.Entering = mesh_id[1]
+	}
+	return
+}
+
+// Intersects a ray with the triangle abc by the Möller–Trumbore algorithm,
+// giving the distance along the ray and the barycentric coordinates of b and c
+func rayTriangle(origin, dir, a, b, c geom.Vec3) (t, u, v float64, crossed bool) {
+	const epsilon = 1e-12
+	e1 := geom.Vec3{b.X - a.X, b.Y - a.Y, b.Z - a.Z}
+	e2 := geom.Vec3{c.X - a.X, c.Y - a.Y, c.Z - a.Z}
+	p := geom.Vec3{
+		dir.Y*e2.Z - dir.Z*e2.Y,
+		dir.Z*e2.X - dir.X*e2.Z,
+		dir.X*e2.Y - dir.Y*e2.X,
+	}
+	det := e1.X*p.X + e1.Y*p.Y + e1.Z*p.Z
+	if math.Abs(det) < epsilon {
+		return
+	}
+	inv_det := 1 / det
+	s := geom.Vec3{origin.X - a.X, origin.Y - a.Y, origin.Z - a.Z}
+	u = (s.X*p.X + s.Y*p.Y + s.Z*p.Z) * inv_det
+	if u < 0 || u > 1 {
+		return
+	}
+	q := geom.Vec3{
+		s.Y*e1.Z - s.Z*e1.Y,
+		s.Z*e1.X - s.X*e1.Z,
+		s.X*e1.Y - s.Y*e1.X,
+	}
+	v = (dir.X*q.X + dir.Y*q.Y + dir.Z*q.Z) * inv_det
+	if v < 0 || u+v > 1 {
+		return
+	}
+	t = (e2.X*q.X + e2.Y*q.Y + e2.Z*q.Z) * inv_det
+	crossed = t > epsilon
+	return
+}
+
+// An arbitrary direction, chosen not to be aligned with any axis or diagonal
+// so that rays are unlikely to run exactly along the edges of regular meshes.
+var shapeAtDirection = normalize(geom.Vec3{0.5377, 0.8319, 0.1371})
+
+/* Determines which shape contains the point, by finding the nearest face in an
+ * arbitrary direction and which side of it the point is on. Points outside
+ * every mesh are in the exterior, shape 0.
+ */
+func (bvh *BVH) ShapeAt(point geom.Vec3) ShapeId {
+	hit := bvh.Raycast(point, shapeAtDirection)
+	if hit == nil {
+		return 0
+	}
+	// the point is in the shape the ray leaves through the face
+	if hit.Entering == hit.Front {
+		return hit.Back
+	}
+	return hit.Front
+}
+
+// Runs fn for each index up to n concurrently across all cores
+func eachConcurrently(n int, fn func(i int)) {
+	const chunk_size = 256
+	var wg sync.WaitGroup
+	pending := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range pending {
+				for i := start; i < start+chunk_size && i < n; i++ {
+					fn(i)
+				}
+			}
+		}()
+	}
+	for start := 0; start < n; start += chunk_size {
+		pending <- start
+	}
+	close(pending)
+	wg.Wait()
+}
+
+// Determines the shape containing each of the points, as by ShapeAt
+func (bvh *BVH) ShapesAt(points []geom.Vec3) (shape_ids []ShapeId) {
+	shape_ids = make([]ShapeId, len(points))
+	eachConcurrently(len(points), func(i int) {
+		shape_ids[i] = bvh.ShapeAt(points[i])
+	})
+	return
+}
+
+// Casts each of the rays as by Raycast, hits are nil for rays which miss
+func (bvh *BVH) RaycastAll(rays []Ray) (hits []*RayHit) {
+	hits = make([]*RayHit, len(rays))
+	eachConcurrently(len(rays), func(i int) {
+		hits[i] = bvh.Raycast(rays[i].Origin, rays[i].Direction)
+	})
+	return
+}
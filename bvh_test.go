@@ -0,0 +1,117 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	"math"
+	"testing"
+)
+
+func TestRaycast(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	bvh := ss.BuildBVH()
+	for _, c := range []struct {
+		origin, direction geom.Vec3
+		mesh_id           MeshId
+		distance          float64
+		entering          ShapeId
+	}{
+		{geom.Vec3{-1, 0.3, 0.6}, geom.Vec3{2, 0, 0}, MeshId{0, 1}, 0.5, 1},
+		{geom.Vec3{1.5, 0.3, 0.6}, geom.Vec3{1, 0, 0}, MeshId{2, 3}, 0.5, 3},
+		{geom.Vec3{1.5, 0.3, 0.6}, geom.Vec3{-1, 0, 0}, MeshId{1, 2}, 0.5, 1},
+		{geom.Vec3{2.5, 0.3, 0.6}, geom.Vec3{0, 0, 1}, MeshId{0, 3}, 0.4, 0},
+	} {
+		hit := bvh.Raycast(c.origin, c.direction)
+		if hit == nil {
+			t.Errorf("Expected the ray from %v along %v to hit mesh %s", c.origin, c.direction, c.mesh_id.ToString())
+			continue
+		}
+		if hit.MeshId != c.mesh_id || math.Abs(hit.Distance-c.distance) > 1e-9 || hit.Entering != c.entering ||
+			hit.Front != c.mesh_id[0] || hit.Back != c.mesh_id[1] {
+			t.Errorf("Expected the ray from %v along %v to enter %d through %s at %g, got %+v",
+				c.origin, c.direction, c.entering, c.mesh_id.ToString(), c.distance, hit)
+		}
+
+		// the barycentric coordinates locate the hit on the face
+		var corners [3]geom.Vec3
+		for fi := range bvh.faces {
+			if bvh.faceMeshes[fi] == hit.MeshId && bvh.faceIndex[fi] == hit.Face {
+				for i, pi := range bvh.faces[fi] {
+					corners[i] = bvh.positions[pi]
+				}
+			}
+		}
+		var point geom.Vec3
+		for i, weight := range hit.Barycentric {
+			if weight < -1e-9 || weight > 1+1e-9 {
+				t.Errorf("Expected barycentric coordinates within the face, got %v", hit.Barycentric)
+			}
+			point.X += weight * corners[i].X
+			point.Y += weight * corners[i].Y
+			point.Z += weight * corners[i].Z
+		}
+		if math.Abs(point.X-hit.Point.X) > 1e-9 || math.Abs(point.Y-hit.Point.Y) > 1e-9 ||
+			math.Abs(point.Z-hit.Point.Z) > 1e-9 {
+			t.Errorf("Expected the barycentric coordinates to give %v, got %v", hit.Point, point)
+		}
+	}
+
+	// rays pointing away from every face miss
+	if hit := bvh.Raycast(geom.Vec3{-1, 0.3, 0.6}, geom.Vec3{-1, 0, 0}); hit != nil {
+		t.Errorf("Expected a ray away from the boxes to miss, got %+v", hit)
+	}
+	empty := New("empty", nil, nil).BuildBVH()
+	if hit := empty.Raycast(geom.Vec3{}, geom.Vec3{1, 0, 0}); hit != nil || empty.ShapeAt(geom.Vec3{}) != 0 {
+		t.Errorf("Expected a BVH without faces to have no hits")
+	}
+}
+
+func TestShapeAt(t *testing.T) {
+	bvh := boxRowShapeSet(t).BuildBVH()
+	for point, shape_id := range map[geom.Vec3]ShapeId{
+		{0.5, 0.5, 0.5}:  1,
+		{1.5, 0.5, 0.5}:  2,
+		{2.9, 0.1, 0.99}: 3,
+		{-0.5, 0.5, 0.5}: 0,
+		{1.5, 2, 0.5}:    0,
+		{5, 5, 5}:        0,
+	} {
+		if actual := bvh.ShapeAt(point); actual != shape_id {
+			t.Errorf("Expected %v to be in shape %d, got %d", point, shape_id, actual)
+		}
+	}
+}
+
+func TestShapesAtAndRaycastAll(t *testing.T) {
+	bvh := boxRowShapeSet(t).BuildBVH()
+	// enough points to be split between workers, on a grid which avoids the
+	// faces of the boxes
+	points := make([]geom.Vec3, 0)
+	rays := make([]Ray, 0)
+	for x := -0.45; x < 3.5; x += 0.1 {
+		for y := -0.45; y < 1.5; y += 0.1 {
+			for z := 0.05; z < 1; z += 0.1 {
+				points = append(points, geom.Vec3{x, y, z})
+				rays = append(rays, Ray{Origin: geom.Vec3{x, y, z}, Direction: geom.Vec3{0, 0, 1}})
+			}
+		}
+	}
+	shape_ids := bvh.ShapesAt(points)
+	hits := bvh.RaycastAll(rays)
+	if len(shape_ids) != len(points) || len(hits) != len(rays) {
+		t.Fatalf("Expected a result for each of %d points, got %d and %d", len(points), len(shape_ids), len(hits))
+	}
+	for i, point := range points {
+		expected := ShapeId(0)
+		if point.X > 0 && point.X < 3 && point.Y > 0 && point.Y < 1 {
+			expected = ShapeId(math.Floor(point.X)) + 1
+		}
+		if shape_ids[i] != expected {
+			t.Errorf("Expected %v to be in shape %d, got %d", point, expected, shape_ids[i])
+		}
+		// rays up from inside a box leave it through its top
+		if (hits[i] != nil) != (expected != 0) ||
+			(hits[i] != nil && hits[i].MeshId != (MeshId{0, expected})) {
+			t.Errorf("Expected the ray up from %v to leave shape %d, got %+v", point, expected, hits[i])
+		}
+	}
+}
@@ -3,9 +3,9 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/nat-n/geom"
 	"github.com/nat-n/piper"
 	"github.com/nat-n/shapeset"
-	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -13,13 +13,38 @@ import (
 
 /* Commands:
  * create
+ * create-from-volume
+ * create-from-shapes
+ * create-with-naming
  * load
  * save
+ * save-quantised
  * save-meshes
+ * save-meshes-as
+ * save-meshes-named
  * index-borders
+ * index-borders-within
+ * verify-borders
+ * validate
+ * repair-orientation
  * simplify-borders
  * reload-vertices
  * create-region
+ * create-region-lazy
+ * create-region-obj
+ * create-region-gltf
+ * export-shapes
+ * export-shapes-as
+ * export-vtk
+ * export-borders
+ * slice
+ * rasterize
+ * rasterize-like
+ * shape-at
+ * raycast
+ * distance
+ * signed-distance
+ * adjacency
  * center-and-scale
  */
 
@@ -38,6 +63,78 @@ func create(data interface{}, flags map[string]piper.Flag, args []string) (resul
 	return
 }
 
+func create_with_naming(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Creating Shapeset with mesh naming " + args[0])
+	}
+
+	naming, err := shapeset.ParseMeshNaming(args[0])
+	if err != nil {
+		return
+	}
+	meshes_dir := args[1]
+	labels_path := args[2]
+
+	ss, report, err := shapeset.CreateNewWithNaming(meshes_dir, labels_path, naming)
+	if err != nil {
+		return
+	}
+
+	// report mesh files which were skipped
+	for file_name, reason := range report.Unmatched {
+		fmt.Fprintln(os.Stderr, "Skipped "+file_name+": "+reason)
+	}
+	for file_name, first_file := range report.Duplicates {
+		fmt.Fprintln(os.Stderr, "Skipped "+file_name+": same mesh as "+first_file)
+	}
+
+	result = interface{}(ss)
+	return
+}
+
+func create_from_volume(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Creating Shapeset from label volume")
+	}
+
+	volume_path := args[0]
+	labels_path := args[1]
+
+	// labels are optional, shapes are otherwise named by their label values
+	table := &shapeset.LabelTable{Labels: make(map[string]string)}
+	if labels_path != "-" {
+		table, err = shapeset.ReadLabelTable(labels_path)
+		if err != nil {
+			return
+		}
+	}
+
+	volume, err := shapeset.ReadLabelVolumeFile(volume_path)
+	if err != nil {
+		return
+	}
+	ss := shapeset.FromLabelVolume("New ShapeSet", volume, table.Labels)
+	ss.ApplyLabelTable(table)
+
+	result = interface{}(ss)
+	return
+}
+
+func create_from_shapes(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Creating Shapeset from closed shape meshes")
+	}
+
+	meshes_dir := args[0]
+	labels_path := args[1]
+
+	var ss *shapeset.ShapeSet
+	ss, err = shapeset.CreateFromShapeMeshes(meshes_dir, labels_path)
+
+	result = interface{}(ss)
+	return
+}
+
 func load(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
 	if _, verbose := flags["verbose"]; verbose {
 		fmt.Println("Loading ShapeSet")
@@ -56,16 +153,30 @@ func save(data interface{}, flags map[string]piper.Flag, args []string) (result
 	if _, verbose := flags["verbose"]; verbose {
 		fmt.Println("Saving ShapeSet to file")
 	}
-	// Serialize JSON and stream to a file
+	// Serialize as JSON, or as the binary container format for .ssb files, and
+	// stream to a file
 	ss := data.(*shapeset.ShapeSet)
 	output_path := args[0]
-	output_file, err := os.Create(output_path)
-	defer output_file.Close()
+	err = ss.WriteFile(output_path)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func save_quantised(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Saving quantised ShapeSet to file")
+	}
+	ss := data.(*shapeset.ShapeSet)
+	bits, err := strconv.ParseUint(args[0], 10, 8)
 	if err != nil {
 		return
 	}
-	ss_writer := io.Writer(output_file)
-	err = ss.Save(&ss_writer)
+	output_path := args[1]
+	err = ss.WriteQuantisedFile(output_path, uint8(bits))
 	if err != nil {
 		return
 	}
@@ -75,16 +186,31 @@ func save(data interface{}, flags map[string]piper.Flag, args []string) (result
 }
 
 func save_meshes(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
-	if _, verbose := flags["verbose"]; verbose {
-		fmt.Println("Saving meshes to directory")
+	return write_meshes(data, flags, args[0], "obj", shapeset.DefaultMeshNaming)
+}
+
+func save_meshes_as(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	return write_meshes(data, flags, args[1], args[0], shapeset.DefaultMeshNaming)
+}
+
+func save_meshes_named(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	naming, err := shapeset.ParseMeshNaming(args[0])
+	if err != nil {
+		return
 	}
-	ss := data.(*shapeset.ShapeSet)
-	meshes_dir := args[0]
+	return write_meshes(data, flags, args[2], args[1], naming)
+}
 
-	// ensure meshes_dir ends with a slash
-	if meshes_dir[len(meshes_dir)-1] != 47 {
-		meshes_dir += "/"
+func write_meshes(
+	data interface{},
+	flags map[string]piper.Flag,
+	meshes_dir, format_name string,
+	naming *shapeset.MeshNaming,
+) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Saving meshes to directory as " + format_name)
 	}
+	ss := data.(*shapeset.ShapeSet)
 
 	// ensure meshes_dir is a directory
 	path_stat, err := os.Stat(meshes_dir)
@@ -92,10 +218,10 @@ func save_meshes(data interface{}, flags map[string]piper.Flag, args []string) (
 		panic(errors.New("Provided path for saving meshes is not a directory"))
 	}
 
-	// write meshes as obj files into the given directory
-	for _, mw := range ss.Meshes {
-		new_mesh_filename := meshes_dir + mw.Mesh.Name + ".obj"
-		mw.Mesh.WriteOBJFile(new_mesh_filename)
+	// write meshes as files of the given format into the given directory
+	err = ss.WriteMeshFiles(meshes_dir, format_name, naming)
+	if err != nil {
+		return
 	}
 
 	result = data
@@ -116,6 +242,140 @@ func index_borders(data interface{}, flags map[string]piper.Flag, args []string)
 	return
 }
 
+func index_borders_within(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Indexing borders within tolerance")
+	}
+	ss := data.(*shapeset.ShapeSet)
+	report_path := args[1]
+
+	weld, err := shapeset.ParseWeldTolerance(args[0])
+	if err != nil {
+		return
+	}
+	report, err := ss.IndexBordersWithTolerance(weld)
+	if err != nil {
+		return
+	}
+
+	if report_path == "-" {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		var report_file *os.File
+		report_file, err = os.Create(report_path)
+		if err != nil {
+			return
+		}
+		defer report_file.Close()
+		err = report.WriteJSON(report_file)
+	}
+	if err != nil {
+		return
+	}
+	if len(report.NearMisses) > 0 || len(report.Unpartnered) > 0 {
+		fmt.Fprintf(os.Stderr, "Welded %d locations, with %d near misses and %d unpartnered vertices\n",
+			report.Welded, len(report.NearMisses), len(report.Unpartnered))
+	}
+
+	result = data
+	return
+}
+
+func verify_borders(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Verifying borders")
+	}
+	ss := data.(*shapeset.ShapeSet)
+
+	report := ss.VerifyBorders()
+	for _, violation := range report.Violations {
+		location := ""
+		if violation.MeshId != nil {
+			location = " in mesh " + violation.MeshId.ToString()
+		}
+		fmt.Fprintln(os.Stderr, violation.Kind+": border "+violation.BorderId.ToString()+
+			location+": "+violation.Detail)
+	}
+	if !report.Ok() {
+		err = errors.New("Border verification failed with " +
+			strconv.Itoa(len(report.Violations)) + " violations across " +
+			strconv.Itoa(report.Borders) + " borders")
+		return
+	}
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Printf("Verified %d borders with %d vertices and %d edges\n",
+			report.Borders, report.Vertices, report.Edges)
+	}
+
+	result = data
+	return
+}
+
+func validate(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Validating shapes")
+	}
+	ss := data.(*shapeset.ShapeSet)
+	report_path := args[0]
+
+	report := ss.ValidateShapes()
+	if report_path == "-" {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		var report_file *os.File
+		report_file, err = os.Create(report_path)
+		if err != nil {
+			return
+		}
+		defer report_file.Close()
+		err = report.WriteJSON(report_file)
+	}
+	if err != nil {
+		return
+	}
+
+	if !report.Valid {
+		invalid := 0
+		for _, shape := range report.Shapes {
+			if !shape.Valid {
+				invalid++
+			}
+		}
+		err = errors.New("Shape validation failed for " + strconv.Itoa(invalid) +
+			" of " + strconv.Itoa(len(report.Shapes)) + " shapes")
+		return
+	}
+
+	result = data
+	return
+}
+
+func repair_orientation(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Repairing mesh orientation")
+	}
+	ss := data.(*shapeset.ShapeSet)
+
+	report := ss.RepairOrientation()
+	for _, repair := range report.Repairs {
+		if repair.DeterminedBy == shapeset.OrientationUndetermined {
+			fmt.Fprintf(os.Stderr, "Couldn't orient component %d of mesh %s with %d faces\n",
+				repair.Component, repair.MeshId.ToString(), repair.Faces)
+		} else if _, verbose := flags["verbose"]; verbose {
+			fmt.Printf("Flipped %d of %d faces of component %d of mesh %s by %s\n",
+				repair.FlippedFaces, repair.Faces, repair.Component,
+				repair.MeshId.ToString(), repair.DeterminedBy)
+		}
+	}
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Printf("Flipped %d faces across %d components of %d meshes\n",
+			report.FlippedFaces, report.Components, report.Meshes)
+	}
+
+	result = data
+	return
+}
+
 func simplify_borders(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
 	if _, verbose := flags["verbose"]; verbose {
 		fmt.Println("Simplifying borders")
@@ -138,7 +398,10 @@ func reload_vertices(data interface{}, flags map[string]piper.Flag, args []strin
 	ss := data.(*shapeset.ShapeSet)
 	meshes_dir := args[0]
 
-	ss.ReloadVertices(meshes_dir)
+	err = ss.ReloadVertices(meshes_dir)
+	if err != nil {
+		return
+	}
 
 	result = interface{}(ss)
 	return
@@ -153,24 +416,344 @@ func create_region(data interface{}, flags map[string]piper.Flag, args []string)
 	mesh_path := args[1]
 
 	// parse list of int shape ids from first argument
+	shape_ids := parse_shape_ids(shapes_str)
+
+	// write the region in the mesh format implied by the file extension
+	m, _ := ss.ComposeRegion(shape_ids...)
+	err = shapeset.WriteMeshFile(&m, mesh_path)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func export_shapes(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	return write_shapes(data, flags, args[0], "obj")
+}
+
+func export_shapes_as(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	return write_shapes(data, flags, args[1], args[0])
+}
+
+func write_shapes(data interface{}, flags map[string]piper.Flag, shapes_dir, format_name string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Exporting shapes to directory as " + format_name)
+	}
+	ss := data.(*shapeset.ShapeSet)
+
+	err = ss.WriteShapeFiles(shapes_dir, format_name)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func create_region_lazy(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Creating region " + args[1] + " from " + args[0])
+	}
+	ss_path := args[0]
+	shape_ids := parse_shape_ids(args[1])
+	mesh_path := args[2]
+
+	// read only the meshes needed for the region
+	lss, err := shapeset.OpenLazy(ss_path)
+	if err != nil {
+		return
+	}
+	defer lss.Close()
+	m, err := lss.ComposeRegion(shape_ids...)
+	if err != nil {
+		return
+	}
+	err = shapeset.WriteMeshFile(&m, mesh_path)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func create_region_obj(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Creating grouped OBJ of region " + args[0])
+	}
+	ss := data.(*shapeset.ShapeSet)
+	shape_ids := parse_shape_ids(args[0])
+	obj_path := args[1]
+
+	// write the region with a group per interface mesh, and its materials
+	err = ss.WriteRegionOBJFile(obj_path, shape_ids...)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func create_region_gltf(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Creating glTF of regions " + args[0])
+	}
+	ss := data.(*shapeset.ShapeSet)
+	regions_str := args[0]
+	glb_path := args[1]
+
+	// parse colon seperated list of regions from first argument
+	regions := make([][]int, 0)
+	for _, region_str := range strings.Split(regions_str, ":") {
+		regions = append(regions, parse_shape_ids(region_str))
+	}
+
+	err = ss.WriteGLBFile(glb_path, regions...)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func slice(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Slicing ShapeSet through " + args[0] + " along " + args[1])
+	}
+	ss := data.(*shapeset.ShapeSet)
+	plane := shapeset.Plane{
+		Origin: parse_vec3(args[0]),
+		Normal: parse_vec3(args[1]),
+	}
+	slice_path := args[2]
+
+	section, err := ss.Slice(plane)
+	if err != nil {
+		return
+	}
+	err = ss.WriteSliceFile(slice_path, section)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func rasterize(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Rasterizing ShapeSet with spacing " + args[0])
+	}
+	ss := data.(*shapeset.ShapeSet)
+	spacing, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return
+	}
+	volume_path := args[1]
+
+	// cover every mesh, with a voxel of exterior on each side
+	origin, dims := ss.RasterGrid(spacing, 1)
+	return write_raster(data, ss, [3]float64{spacing, spacing, spacing}, origin, dims, false, volume_path)
+}
+
+func rasterize_like(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Rasterizing ShapeSet onto the grid of " + args[0])
+	}
+	ss := data.(*shapeset.ShapeSet)
+	reference, err := shapeset.ReadLabelVolumeFile(args[0])
+	if err != nil {
+		return
+	}
+	volume_path := args[1]
+
+	// the raster shares the space of the reference volume
+	return write_raster(data, ss, reference.Spacing, reference.Origin, reference.Dims,
+		reference.RAS, volume_path)
+}
+
+func write_raster(
+	data interface{},
+	ss *shapeset.ShapeSet,
+	spacing, origin [3]float64,
+	dims [3]int,
+	ras bool,
+	volume_path string,
+) (result interface{}, err error) {
+	volume, err := ss.Rasterize(spacing, origin, dims)
+	if err != nil {
+		return
+	}
+	volume.RAS = ras
+	err = shapeset.WriteLabelVolumeFile(volume, volume_path)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func shape_at(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	ss := data.(*shapeset.ShapeSet)
+	point := parse_vec3(args[0])
+
+	shape_id := ss.BuildBVH().ShapeAt(point)
+	fmt.Println(strconv.Itoa(int(shape_id)) + "\t" + ss.Shapes[shape_id])
+
+	result = data
+	return
+}
+
+func raycast(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	ss := data.(*shapeset.ShapeSet)
+	origin := parse_vec3(args[0])
+	direction := parse_vec3(args[1])
+
+	hit := ss.BuildBVH().Raycast(origin, direction)
+	if hit == nil {
+		fmt.Println("No hit")
+	} else {
+		fmt.Printf("mesh %s face %d at (%g, %g, %g) distance %g, entering %d %s\n",
+			hit.MeshId.ToString(), hit.Face, hit.Point.X, hit.Point.Y, hit.Point.Z,
+			hit.Distance, hit.Entering, ss.Shapes[hit.Entering])
+	}
+
+	result = data
+	return
+}
+
+func distance(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	ss := data.(*shapeset.ShapeSet)
+	shape_num, err := strconv.Atoi(args[0])
+	if err != nil {
+		return
+	}
+	shape_id := shapeset.ShapeId(shape_num)
+	point := parse_vec3(args[1])
+
+	bvh := ss.BuildBVH()
+	nearest := bvh.NearestSurface(point)
+	if nearest == nil {
+		err = errors.New("ShapeSet has no faces")
+		return
+	}
+	fmt.Printf("nearest mesh %s face %d at (%g, %g, %g) distance %g\n",
+		nearest.MeshId.ToString(), nearest.Face,
+		nearest.Point.X, nearest.Point.Y, nearest.Point.Z, nearest.Distance)
+	fmt.Printf("signed distance to %d %s: %g\n",
+		shape_num, ss.Shapes[shape_id], bvh.SignedDistance(shape_id, point))
+
+	result = data
+	return
+}
+
+func signed_distance(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Sampling signed distance field of region " + args[0])
+	}
+	ss := data.(*shapeset.ShapeSet)
+	shape_ids := make([]shapeset.ShapeId, 0)
+	for _, shape_num := range parse_shape_ids(args[0]) {
+		shape_ids = append(shape_ids, shapeset.ShapeId(shape_num))
+	}
+	spacing, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return
+	}
+	volume_path := args[2]
+
+	// cover every mesh, with a margin to capture distances outside the region
+	origin, dims := ss.RasterGrid(spacing, 4)
+	field, err := ss.BuildBVH().SampleSignedDistance(shape_ids,
+		[3]float64{spacing, spacing, spacing}, origin, dims)
+	if err != nil {
+		return
+	}
+	err = shapeset.WriteDistanceFieldFile(field, volume_path)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func adjacency(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Exporting shape adjacency graph to " + args[0])
+	}
+	ss := data.(*shapeset.ShapeSet)
+	graph_path := args[0]
+
+	err = ss.AdjacencyGraph().WriteFile(graph_path)
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+// Parses a vector given as a comma seperated string of three numbers
+func parse_vec3(vec_str string) (v geom.Vec3) {
+	string_segments := strings.Split(vec_str, ",")
+	if len(string_segments) != 3 {
+		panic(errors.New("Invalid vector: Expected three components in: " + vec_str))
+	}
+	components := [3]float64{}
+	for i, seg := range string_segments {
+		num, err := strconv.ParseFloat(seg, 64)
+		if err != nil {
+			panic(errors.New("Invalid vector: Couldn't parse number from: " + seg))
+		}
+		components[i] = num
+	}
+	return geom.Vec3{components[0], components[1], components[2]}
+}
+
+// Parses a region definition given as a comma seperated string of shape ids
+func parse_shape_ids(shapes_str string) []int {
 	string_segments := strings.Split(shapes_str, ",")
 	shape_ids := make([]int, 0, len(string_segments))
-	var num int
 	for _, seg := range string_segments {
-		num, err = strconv.Atoi(seg)
+		num, err := strconv.Atoi(seg)
 		if err != nil {
 			panic(errors.New("Invalid region definition: Couldn't parse int from: " +
 				seg))
 		}
 		shape_ids = append(shape_ids, num)
 	}
+	return shape_ids
+}
 
-	m, _ := ss.ComposeRegion(shape_ids...)
-	obj_file, err := os.Create(mesh_path)
+func export_vtk(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Exporting ShapeSet as VTK PolyData")
+	}
+	ss := data.(*shapeset.ShapeSet)
+	err = ss.WriteVTKFile(args[0])
+	if err != nil {
+		return
+	}
+
+	result = data
+	return
+}
+
+func export_borders(data interface{}, flags map[string]piper.Flag, args []string) (result interface{}, err error) {
+	if _, verbose := flags["verbose"]; verbose {
+		fmt.Println("Exporting borders as polylines")
+	}
+	ss := data.(*shapeset.ShapeSet)
+	err = ss.WriteBordersFile(args[0])
 	if err != nil {
 		return
 	}
-	m.WriteOBJ(obj_file)
 
 	result = data
 	return
@@ -205,11 +788,35 @@ func main() {
 
 	cli.RegisterCommand(piper.Command{
 		Name:        "create",
-		Description: "create new shapeset from meshes and labels",
+		Description: "create new shapeset from obj, ply or stl meshes and labels",
 		Args:        []string{"meshes directory", "labels file"},
 		Task:        create,
 	})
 
+	cli.RegisterCommand(piper.Command{
+		Name: "create-from-volume",
+		Description: ("create new shapeset from a NRRD or NIfTI label volume and " +
+			"labels, or - to name shapes by their label values"),
+		Args: []string{"label volume file", "labels file"},
+		Task: create_from_volume,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "create-from-shapes",
+		Description: ("create new shapeset from a closed mesh per shape, named " +
+			"by shape id, by splitting them into interface meshes"),
+		Args: []string{"meshes directory", "labels file"},
+		Task: create_from_shapes,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "create-with-naming",
+		Description: ("create new shapeset from meshes named according to " +
+			"ids:<template> or labels:<template>, e.g. labels:{a}__{b}"),
+		Args: []string{"mesh naming", "meshes directory", "labels file"},
+		Task: create_with_naming,
+	})
+
 	cli.RegisterCommand(piper.Command{
 		Name:        "load",
 		Description: "load shapeset from file",
@@ -219,11 +826,19 @@ func main() {
 
 	cli.RegisterCommand(piper.Command{
 		Name:        "save",
-		Description: "save shapeset to file",
+		Description: "save shapeset to file, as binary if the file extension is .ssb",
 		Args:        []string{"shapeset file"},
 		Task:        save,
 	})
 
+	cli.RegisterCommand(piper.Command{
+		Name: "save-quantised",
+		Description: ("save shapeset to a binary file with vertex positions " +
+			"quantised to the given number of bits per axis, e.g. 16"),
+		Args: []string{"bits", "shapeset file"},
+		Task: save_quantised,
+	})
+
 	cli.RegisterCommand(piper.Command{
 		Name:        "save-meshes",
 		Description: "save meshes as obj files",
@@ -231,12 +846,63 @@ func main() {
 		Task:        save_meshes,
 	})
 
+	cli.RegisterCommand(piper.Command{
+		Name: "save-meshes-as",
+		Description: ("save meshes as files of the given format, one of obj, " +
+			"ply, ply-ascii or stl"),
+		Args: []string{"mesh format", "meshes directory"},
+		Task: save_meshes_as,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "save-meshes-named",
+		Description: ("save meshes as files of the given format, named " +
+			"according to ids:<template> or labels:<template>"),
+		Args: []string{"mesh naming", "mesh format", "meshes directory"},
+		Task: save_meshes_named,
+	})
+
 	cli.RegisterCommand(piper.Command{
 		Name:        "index-borders",
 		Description: "find mesh borders and create new shape set wide border index",
 		Task:        index_borders,
 	})
 
+	cli.RegisterCommand(piper.Command{
+		Name: "index-borders-within",
+		Description: ("index borders, welding vertices of different meshes within " +
+			"a tolerance given as a distance, or a percentage of the bounding box " +
+			"diagonal like 0.01%, optionally followed by a comma and the near miss " +
+			"distance to report, and write a JSON report to the given file or - " +
+			"for stdout"),
+		Args: []string{"weld tolerance", "report file"},
+		Task: index_borders_within,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "verify-borders",
+		Description: ("checks the consistency of the borders index, reporting " +
+			"violations and failing if there are any"),
+		Task: verify_borders,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "validate",
+		Description: ("check that the surface of every shape is closed, manifold " +
+			"and consistently oriented outwards, and write a JSON report to the " +
+			"given file or - for stdout"),
+		Args: []string{"report file"},
+		Task: validate,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "repair-orientation",
+		Description: ("flip faces of meshes which don't face the lower of their " +
+			"shape ids, as determined by ray parity and neighbouring meshes"),
+		Args: []string{},
+		Task: repair_orientation,
+	})
+
 	cli.RegisterCommand(piper.Command{
 		Name:        "simplify-borders",
 		Description: "apply edge collapse simplification to all borders",
@@ -253,12 +919,131 @@ func main() {
 
 	cli.RegisterCommand(piper.Command{
 		Name: "create-region",
-		Description: ("creates a mesh of a specified region as an obj file, " +
-			"accepts shape ids as a comma seperated string of integers"),
-		Args: []string{"region shape ids", "output obj file"},
+		Description: ("creates a mesh of a specified region as an obj, ply or " +
+			"stl file, accepts shape ids as a comma seperated string of integers"),
+		Args: []string{"region shape ids", "output mesh file"},
 		Task: create_region,
 	})
 
+	cli.RegisterCommand(piper.Command{
+		Name: "export-shapes",
+		Description: ("save a closed mesh of every shape as obj files named by " +
+			"shape label"),
+		Args: []string{"shapes directory"},
+		Task: export_shapes,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "export-shapes-as",
+		Description: ("save a closed mesh of every shape as files of the given " +
+			"format, one of obj, ply, ply-ascii or stl"),
+		Args: []string{"mesh format", "shapes directory"},
+		Task: export_shapes_as,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "create-region-lazy",
+		Description: ("creates a mesh of a specified region directly from a " +
+			"binary shapeset file, reading only the meshes the region needs"),
+		Args: []string{"binary shapeset file", "shape ids", "output mesh file"},
+		Task: create_region_lazy,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "create-region-obj",
+		Description: ("creates an obj of a region with a group per interface " +
+			"mesh, and an mtl with a material per neighbouring shape"),
+		Args: []string{"region shape ids", "output obj file"},
+		Task: create_region_obj,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "create-region-gltf",
+		Description: ("creates a binary glTF scene of one or more regions, " +
+			"accepts regions as colon seperated lists of comma seperated shape ids"),
+		Args: []string{"regions shape ids", "output glb file"},
+		Task: create_region_gltf,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "export-vtk",
+		Description: ("writes all meshes as VTK PolyData with shape ids as cell " +
+			"data, as xml for .vtp files or legacy format for .vtk files"),
+		Args: []string{"output vtp or vtk file"},
+		Task: export_vtk,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "export-borders",
+		Description: ("writes borders as polylines, as obj line elements for .obj " +
+			"files or with border descriptions and shape labels for .json files"),
+		Args: []string{"output obj or json file"},
+		Task: export_borders,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "slice",
+		Description: ("writes the cross-section through a plane as svg or " +
+			"geojson, given a point on the plane and its normal as x,y,z"),
+		Args: []string{"plane origin", "plane normal", "output svg or json file"},
+		Task: slice,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "rasterize",
+		Description: ("writes a NRRD or NIfTI label volume of the shapeset " +
+			"with the given voxel spacing, covering every mesh"),
+		Args: []string{"voxel spacing", "output volume file"},
+		Task: rasterize,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "rasterize-like",
+		Description: ("writes a NRRD or NIfTI label volume of the shapeset " +
+			"on the same grid as the given volume, e.g. its source segmentation"),
+		Args: []string{"reference volume file", "output volume file"},
+		Task: rasterize_like,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name:        "shape-at",
+		Description: "prints the id and label of the shape containing the point x,y,z",
+		Args:        []string{"point"},
+		Task:        shape_at,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "raycast",
+		Description: ("prints the first interface mesh face hit by the ray from " +
+			"origin x,y,z in direction x,y,z"),
+		Args: []string{"ray origin", "ray direction"},
+		Task: raycast,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "distance",
+		Description: ("prints the nearest point on any mesh to the point x,y,z, " +
+			"and its signed distance to the boundary of the given shape"),
+		Args: []string{"shape id", "point"},
+		Task: distance,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "signed-distance",
+		Description: ("writes the signed distance field of a region, negative " +
+			"inside, as a NRRD or NIfTI volume with the given voxel spacing"),
+		Args: []string{"region shape ids", "voxel spacing", "output volume file"},
+		Task: signed_distance,
+	})
+
+	cli.RegisterCommand(piper.Command{
+		Name: "adjacency",
+		Description: ("writes the graph of touching shapes, with contact areas " +
+			"and border junctions, as graphml, dot or json"),
+		Args: []string{"output graph file"},
+		Task: adjacency,
+	})
+
 	cli.RegisterCommand(piper.Command{
 		Name: "center-and-scale",
 		Description: ("transforms the whole shapeset so that its bounding box is " +
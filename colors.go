@@ -0,0 +1,59 @@
+package shapeset
+
+import (
+	"math"
+)
+
+type Color [4]float64 // RGBA, each component in [0, 1]
+
+// Returns the display color of a shape, as given by its metadata if it has
+// one. Otherwise colors are derived deterministically from the ShapeId by
+// stepping around the hue circle by the golden angle, so that shapes with
+// neighbouring ids are easy to tell apart.
+func (ss *ShapeSet) ShapeColor(shape_id ShapeId) Color {
+	if metadata, exists := ss.Metadata[shape_id]; exists && metadata.Color != nil {
+		return *metadata.Color
+	}
+	hue := math.Mod(float64(shape_id)*0.618033988749895, 1)
+	if hue < 0 {
+		hue += 1
+	}
+	r, g, b := hsvToRGB(hue, 0.55, 0.9)
+	return Color{r, g, b, 1}
+}
+
+// Converts a color from hue, saturation and value, each in [0, 1], to RGB
+func hsvToRGB(h, s, v float64) (r, g, b float64) {
+	h6 := h * 6
+	sector := math.Floor(h6)
+	f := h6 - sector
+	p := v * (1 - s)
+	q := v * (1 - s*f)
+	t := v * (1 - s*(1-f))
+	switch int(sector) % 6 {
+	case 0:
+		return v, t, p
+	case 1:
+		return q, v, p
+	case 2:
+		return p, v, t
+	case 3:
+		return p, q, v
+	case 4:
+		return t, p, v
+	default:
+		return v, p, q
+	}
+}
+
+// Formats a color as a hex string in the form #rrggbb
+func (c Color) Hex() string {
+	const digits = "0123456789abcdef"
+	hex := []byte("#000000")
+	for i := 0; i < 3; i++ {
+		channel := int(math.Round(math.Max(0, math.Min(1, c[i])) * 255))
+		hex[1+i*2] = digits[channel/16]
+		hex[2+i*2] = digits[channel%16]
+	}
+	return string(hex)
+}
@@ -3,6 +3,7 @@ package shapeset
 import (
 	"github.com/nat-n/geom"
 	"github.com/nat-n/gomesh/mesh"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -11,25 +12,10 @@ import (
 // the given values.
 func (ss *ShapeSet) ComposeRegion(shape_ids ...int) (result mesh.Mesh, err error) {
 	// initialise the output mesh with an appropriate name
-	shape_names := make([]string, 0, len(shape_ids))
-	for _, shape_id := range shape_ids {
-		shape_names = append(shape_names, ss.Shapes[ShapeId(shape_id)])
-	}
-	new_mesh_name := strings.Join(shape_names, "_")
-	result = *mesh.New(new_mesh_name)
+	result = *mesh.New(ss.regionName(shape_ids...))
 
 	// Collect meshes, and determine whether each mesh's normals will need inverting
-	meshes := make(map[*Mesh]bool)
-	for _, m := range ss.Meshes {
-		shape_id_strs := strings.Split(m.GetName(), "-")
-		shape_id_1, _ := strconv.ParseInt(shape_id_strs[0], 10, 64)
-		shape_id_2, _ := strconv.ParseInt(shape_id_strs[1], 10, 64)
-		// must invert if only front shape of mesh fragment is included in region
-		must_invert := intInSlice(int(shape_id_1), shape_ids)
-		if must_invert != intInSlice(int(shape_id_2), shape_ids) {
-			meshes[m] = must_invert
-		}
-	}
+	meshes := ss.regionMeshes(shape_ids...)
 
 	// tracks which borders have already had at least one mesh included
 	result_verts := make(map[geom.Vec3]*Vertex)
@@ -72,3 +58,105 @@ func (ss *ShapeSet) ComposeRegion(shape_ids ...int) (result mesh.Mesh, err error
 
 	return
 }
+
+// Collects the meshes which make up the surface of the region defined by the
+// shapes indexed by the given values, mapped onto whether each mesh's faces
+// will need inverting to face out of the region.
+func (ss *ShapeSet) regionMeshes(shape_ids ...int) (meshes map[*Mesh]bool) {
+	meshes = make(map[*Mesh]bool)
+	for _, m := range ss.Meshes {
+		shape_id_strs := strings.Split(m.GetName(), "-")
+		shape_id_1, _ := strconv.ParseInt(shape_id_strs[0], 10, 64)
+		shape_id_2, _ := strconv.ParseInt(shape_id_strs[1], 10, 64)
+		// must invert if only front shape of mesh fragment is included in region
+		must_invert := intInSlice(int(shape_id_1), shape_ids)
+		if must_invert != intInSlice(int(shape_id_2), shape_ids) {
+			meshes[m] = must_invert
+		}
+	}
+	return
+}
+
+// Derives a name for the region defined by the given shapes from their labels.
+func (ss *ShapeSet) regionName(shape_ids ...int) string {
+	shape_names := make([]string, 0, len(shape_ids))
+	for _, shape_id := range shape_ids {
+		shape_names = append(shape_names, ss.Shapes[ShapeId(shape_id)])
+	}
+	return strings.Join(shape_names, "_")
+}
+
+// A patch of the surface of a region contributed by a single interface mesh,
+// with its faces wound to face out of the region.
+type regionPatch struct {
+	Mesh     *Mesh
+	Inner    ShapeId // the shape on the inside of the region
+	Outer    ShapeId // the shape on the outside of the region
+	Vertices []*Vertex
+	Faces    [][3]int
+}
+
+// Collects the surface patches of the region defined by the shapes indexed by
+// the given values, ordered by MeshId.
+func (ss *ShapeSet) regionPatches(shape_ids ...int) (patches []*regionPatch) {
+	meshes := ss.regionMeshes(shape_ids...)
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(meshes))
+	for m, _ := range meshes {
+		mesh_ids = append(mesh_ids, m.Id())
+	}
+	sort.Sort(mesh_ids)
+
+	for _, mesh_id := range mesh_ids {
+		m := ss.Meshes[mesh_id]
+		must_invert := meshes[m]
+		patch := &regionPatch{Mesh: m, Inner: mesh_id[1], Outer: mesh_id[0]}
+		if must_invert {
+			patch.Inner, patch.Outer = mesh_id[0], mesh_id[1]
+		}
+
+		vertex_indices := make(map[*Vertex]int)
+		m.Faces.Each(func(f mesh.FaceI) {
+			var face [3]int
+			i := 0
+			f.EachVertex(func(vi mesh.VertexI) {
+				v := vi.(*Vertex)
+				index, encountered := vertex_indices[v]
+				if !encountered {
+					index = len(patch.Vertices)
+					patch.Vertices = append(patch.Vertices, v)
+					vertex_indices[v] = index
+				}
+				face[i] = index
+				i++
+			})
+			if must_invert {
+				// swap first and second vertices to invert the face
+				face[0], face[1] = face[1], face[0]
+			}
+			patch.Faces = append(patch.Faces, face)
+		})
+		patches = append(patches, patch)
+	}
+	return
+}
+
+// Calculates area weighted vertex normals for the patch from its faces.
+func (p *regionPatch) Normals() (normals []geom.Vec3) {
+	normals = make([]geom.Vec3, len(p.Vertices))
+	for _, face := range p.Faces {
+		n := triangleNormal(
+			p.Vertices[face[0]].Vec3,
+			p.Vertices[face[1]].Vec3,
+			p.Vertices[face[2]].Vec3,
+		)
+		for _, vi := range face {
+			normals[vi].X += n.X
+			normals[vi].Y += n.Y
+			normals[vi].Z += n.Z
+		}
+	}
+	for i := range normals {
+		normals[i] = normalize(normals[i])
+	}
+	return
+}
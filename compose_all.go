@@ -0,0 +1,196 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Composes a closed surface mesh for every shape in the shapeset, as
+ * ComposeRegion would for each shape on its own.
+ *
+ * Rather than matching up the vertices of every mesh once per shape, vertex
+ * positions are deduplicated across all meshes once up front, so that the
+ * shapes can then be assembled concurrently from plain index buffers.
+ */
+func (ss *ShapeSet) ComposeAllShapes() (results map[ShapeId]*gomesh.Mesh, err error) {
+	positions, mesh_faces := ss.sharedFaceBuffers()
+
+	shape_ids := make([]ShapeId, 0, len(ss.Shapes))
+	for shape_id, _ := range ss.Shapes {
+		shape_ids = append(shape_ids, shape_id)
+	}
+
+	results = make(map[ShapeId]*gomesh.Mesh)
+	var results_lock sync.Mutex
+	var wg sync.WaitGroup
+	pending := make(chan ShapeId)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shape_id := range pending {
+				m, shape_err := ss.composeShape(shape_id, positions, mesh_faces)
+				results_lock.Lock()
+				if shape_err != nil && err == nil {
+					err = shape_err
+				}
+				results[shape_id] = m
+				results_lock.Unlock()
+			}
+		}()
+	}
+	for _, shape_id := range shape_ids {
+		pending <- shape_id
+	}
+	close(pending)
+	wg.Wait()
+	return
+}
+
+// Collects the faces of every mesh as indices into a single list of distinct
+// vertex positions shared by all meshes.
+func (ss *ShapeSet) sharedFaceBuffers() (positions []geom.Vec3, mesh_faces map[MeshId][][3]int) {
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(ss.Meshes))
+	for mesh_id, _ := range ss.Meshes {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+
+	position_indices := make(map[geom.Vec3]int)
+	mesh_faces = make(map[MeshId][][3]int)
+	for _, mesh_id := range mesh_ids {
+		faces := make([][3]int, 0, ss.Meshes[mesh_id].Faces.Len())
+		ss.Meshes[mesh_id].Faces.Each(func(f gomesh.FaceI) {
+			var face [3]int
+			i := 0
+			f.EachVertex(func(vi gomesh.VertexI) {
+				v3 := vi.(*Vertex).Vec3
+				index, encountered := position_indices[v3]
+				if !encountered {
+					index = len(positions)
+					positions = append(positions, v3)
+					position_indices[v3] = index
+				}
+				face[i] = index
+				i++
+			})
+			faces = append(faces, face)
+		})
+		mesh_faces[mesh_id] = faces
+	}
+	return
+}
+
+// Assembles the surface of a single shape from the shared face buffers
+func (ss *ShapeSet) composeShape(
+	shape_id ShapeId,
+	positions []geom.Vec3,
+	mesh_faces map[MeshId][][3]int,
+) (m *gomesh.Mesh, err error) {
+	mesh_ids := make(ByMeshIdPrecedence, 0)
+	for mesh_id, _ := range mesh_faces {
+		if (mesh_id[0] == shape_id) != (mesh_id[1] == shape_id) {
+			mesh_ids = append(mesh_ids, mesh_id)
+		}
+	}
+	sort.Sort(mesh_ids)
+
+	local_indices := make(map[int]int)
+	local_positions := make([]geom.Vec3, 0)
+	triangles := make([][3]int, 0)
+	for _, mesh_id := range mesh_ids {
+		// faces point towards the front shape, so must be inverted to face out
+		// of the shape if it is the front shape
+		must_invert := mesh_id[0] == shape_id
+		for _, face := range mesh_faces[mesh_id] {
+			var triangle [3]int
+			for i, pi := range face {
+				index, encountered := local_indices[pi]
+				if !encountered {
+					index = len(local_positions)
+					local_positions = append(local_positions, positions[pi])
+					local_indices[pi] = index
+				}
+				triangle[i] = index
+			}
+			if must_invert {
+				triangle[0], triangle[1] = triangle[1], triangle[0]
+			}
+			triangles = append(triangles, triangle)
+		}
+	}
+
+	return meshFromBuffers(ss.regionName(int(shape_id)), local_positions, triangles)
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Derives a file name for each shape from its label, replacing characters
+// which aren't safe in file names. Shapes with empty or clashing names are
+// distinguished by their ShapeId, and then by a count if that still clashes.
+func (ss *ShapeSet) ShapeFileNames() (file_names map[ShapeId]string) {
+	shape_ids := make([]int, 0, len(ss.Shapes))
+	for shape_id, _ := range ss.Shapes {
+		shape_ids = append(shape_ids, int(shape_id))
+	}
+	sort.Ints(shape_ids)
+
+	file_names = make(map[ShapeId]string)
+	taken := make(map[string]bool)
+	for _, sid := range shape_ids {
+		shape_id := ShapeId(sid)
+		name := unsafeFileNameChars.ReplaceAllString(ss.Shapes[shape_id], "_")
+		name = strings.Trim(name, "._")
+		if name == "" {
+			name = shape_id.ToString()
+		}
+		// the distinguished name may itself have been taken, so count up from
+		// it until it's unique
+		candidate := name
+		for suffix := 1; taken[strings.ToLower(candidate)]; suffix++ {
+			candidate = name + "_" + shape_id.ToString()
+			if suffix > 1 {
+				candidate += "_" + strconv.Itoa(suffix)
+			}
+		}
+		name = candidate
+		taken[strings.ToLower(name)] = true
+		file_names[shape_id] = name
+	}
+	return
+}
+
+// Writes the closed surface of every shape into the given directory as files
+// of the named mesh format, named after the shape labels.
+func (ss *ShapeSet) WriteShapeFiles(dir_path, format_name string) (err error) {
+	format, err := MeshFormatNamed(format_name)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(dir_path, 0755); err != nil {
+		return
+	}
+
+	shape_meshes, err := ss.ComposeAllShapes()
+	if err != nil {
+		return
+	}
+	for shape_id, file_name := range ss.ShapeFileNames() {
+		err = format.WriteFile(
+			shape_meshes[shape_id],
+			filepath.Join(dir_path, file_name+format.Extension),
+		)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
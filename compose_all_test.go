@@ -0,0 +1,66 @@
+package shapeset
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestComposeAllShapes(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	results, err := ss.ComposeAllShapes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected a surface for each of 3 shapes, got %d", len(results))
+	}
+	for shape_id, m := range results {
+		// each shape is its own closed, outward facing, box
+		box_triangles := orientedTriangles(closedBox(t, float64(shape_id-1)))
+		triangles := orientedTriangles(m)
+		if m.Faces.Len() != 12 || len(triangles) != len(box_triangles) {
+			t.Errorf("Expected shape %d to have the 12 faces of a box, got %d", shape_id, m.Faces.Len())
+		}
+		for triangle, _ := range box_triangles {
+			if !triangles[triangle] {
+				t.Errorf("Expected shape %d to have the triangle %v", shape_id, triangle)
+			}
+		}
+		if m.GetName() != ss.Shapes[shape_id] {
+			t.Errorf("Expected shape %d to be named %s, got %s", shape_id, ss.Shapes[shape_id], m.GetName())
+		}
+	}
+}
+
+func TestShapeFileNames(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	ss.Shapes = map[ShapeId]string{1: "a b", 2: "a_b", 3: "", 4: "A/B", 5: "a_b_2", 6: "..."}
+	expected := map[ShapeId]string{1: "a_b", 2: "a_b_2", 3: "3", 4: "A_B_4", 5: "a_b_2_5", 6: "6"}
+	file_names := ss.ShapeFileNames()
+	for shape_id, name := range expected {
+		if file_names[shape_id] != name {
+			t.Errorf("Expected shape %d to be written as %s, got %s", shape_id, name, file_names[shape_id])
+		}
+	}
+}
+
+func TestWriteShapeFiles(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	dir := t.TempDir()
+	if err := ss.WriteShapeFiles(dir, "ply-ascii"); err != nil {
+		t.Fatal(err)
+	}
+	for _, label := range boxRowLabels {
+		m, err := ReadMeshFile(filepath.Join(dir, label+".ply"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Faces.Len() != 12 {
+			t.Errorf("Expected %s to be written as a box of 12 faces, got %d", label, m.Faces.Len())
+		}
+	}
+
+	if err := ss.WriteShapeFiles(dir, "vrml"); err == nil {
+		t.Errorf("Expected an unknown mesh format to be rejected")
+	}
+}
@@ -0,0 +1,257 @@
+package shapeset
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/nat-n/geom"
+	"io"
+	"math"
+	"strings"
+)
+
+// The closest point on an interface mesh to some query point
+type SurfacePoint struct {
+	MeshId MeshId
+	// The index of the face within the mesh
+	Face int
+	// The barycentric coordinates of the point relative to the face's vertices
+	Barycentric [3]float64
+	Point       geom.Vec3
+	Distance    float64
+}
+
+// Calculates the squared distance from p to the node's bounds, which is zero
+// if p lies within them.
+func (node *bvhNode) squaredDistance(p [3]float64) (d2 float64) {
+	for axis := 0; axis < 3; axis++ {
+		if p[axis] < node.Min[axis] {
+			d2 += (node.Min[axis] - p[axis]) * (node.Min[axis] - p[axis])
+		} else if p[axis] > node.Max[axis] {
+			d2 += (p[axis] - node.Max[axis]) * (p[axis] - node.Max[axis])
+		}
+	}
+	return
+}
+
+// Finds the closest point on any interface mesh to the given point, or nil if
+// there are no faces.
+func (bvh *BVH) NearestSurface(point geom.Vec3) *SurfacePoint {
+	return bvh.nearestSurface(point, nil)
+}
+
+// Finds the closest point on the faces of the meshes accepted by include, or
+// on any mesh if include is nil.
+func (bvh *BVH) nearestSurface(point geom.Vec3, include func(MeshId) bool) (nearest *SurfacePoint) {
+	if len(bvh.nodes) == 0 {
+		return
+	}
+	p := [3]float64{point.X, point.Y, point.Z}
+
+	nearest_d2 := math.Inf(1)
+	nearest_face := -1
+	var nearest_point geom.Vec3
+	var nearest_bary [3]float64
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &bvh.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if node.squaredDistance(p) >= nearest_d2 {
+			continue
+		}
+		if node.Count > 0 {
+			for fi := node.First; fi < node.First+node.Count; fi++ {
+				if include != nil && !include(bvh.faceMeshes[fi]) {
+					continue
+				}
+				face := bvh.faces[fi]
+				closest, bary := closestPointOnTriangle(point,
+					bvh.positions[face[0]], bvh.positions[face[1]], bvh.positions[face[2]])
+				dx, dy, dz := closest.X-point.X, closest.Y-point.Y, closest.Z-point.Z
+				if d2 := dx*dx + dy*dy + dz*dz; d2 < nearest_d2 {
+					nearest_d2, nearest_face = d2, fi
+					nearest_point, nearest_bary = closest, bary
+				}
+			}
+			continue
+		}
+		// visit the nearer child first by pushing it last
+		left_d2 := bvh.nodes[node.Left].squaredDistance(p)
+		right_d2 := bvh.nodes[node.Right].squaredDistance(p)
+		if left_d2 < right_d2 {
+			stack = append(stack, node.Right, node.Left)
+		} else {
+			stack = append(stack, node.Left, node.Right)
+		}
+	}
+	if nearest_face < 0 {
+		return
+	}
+
+	nearest = &SurfacePoint{
+		MeshId:      bvh.faceMeshes[nearest_face],
+		Face:        bvh.faceIndex[nearest_face],
+		Barycentric: nearest_bary,
+		Point:       nearest_point,
+		Distance:    math.Sqrt(nearest_d2),
+	}
+	return
+}
+
+/* Finds the closest point to p on the triangle abc and its barycentric
+ * coordinates, by determining which of the triangle's vertex, edge or face
+ * regions p projects onto, as described in Ericson's Real-Time Collision
+ * Detection.
+ */
+func closestPointOnTriangle(p, a, b, c geom.Vec3) (closest geom.Vec3, bary [3]float64) {
+	dot := func(u, v geom.Vec3) float64 { return u.X*v.X + u.Y*v.Y + u.Z*v.Z }
+	sub := func(u, v geom.Vec3) geom.Vec3 { return geom.Vec3{u.X - v.X, u.Y - v.Y, u.Z - v.Z} }
+	at := func(u, v, w float64) (geom.Vec3, [3]float64) {
+		return geom.Vec3{
+			u*a.X + v*b.X + w*c.X,
+			u*a.Y + v*b.Y + w*c.Y,
+			u*a.Z + v*b.Z + w*c.Z,
+		}, [3]float64{u, v, w}
+	}
+
+	ab, ac, ap := sub(b, a), sub(c, a), sub(p, a)
+	d1, d2 := dot(ab, ap), dot(ac, ap)
+	if d1 <= 0 && d2 <= 0 {
+		return at(1, 0, 0)
+	}
+	bp := sub(p, b)
+	d3, d4 := dot(ab, bp), dot(ac, bp)
+	if d3 >= 0 && d4 <= d3 {
+		return at(0, 1, 0)
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		v := d1 / (d1 - d3)
+		return at(1-v, v, 0)
+	}
+	cp := sub(p, c)
+	d5, d6 := dot(ab, cp), dot(ac, cp)
+	if d6 >= 0 && d5 <= d6 {
+		return at(0, 0, 1)
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		w := d2 / (d2 - d6)
+		return at(1-w, 0, w)
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && (d4-d3) >= 0 && (d5-d6) >= 0 {
+		w := (d4 - d3) / ((d4 - d3) + (d5 - d6))
+		return at(0, 1-w, w)
+	}
+	denom := va + vb + vc
+	if denom == 0 {
+		// degenerate triangle, every region test above has failed only through
+		// rounding, so fall back on its first vertex
+		return at(1, 0, 0)
+	}
+	v, w := vb/denom, vc/denom
+	return at(1-v-w, v, w)
+}
+
+// Calculates the distance from the point to the boundary of the shape, which
+// is negative if the point lies within it.
+func (bvh *BVH) SignedDistance(shape_id ShapeId, point geom.Vec3) float64 {
+	return bvh.RegionSignedDistance([]ShapeId{shape_id}, point)
+}
+
+/* Calculates the distance from the point to the boundary of the region made up
+ * of the given shapes, which is negative if the point lies within it. The
+ * boundary is made up of the meshes between shapes in the region and shapes
+ * outside it. If the region has no boundary the distance is infinite.
+ */
+func (bvh *BVH) RegionSignedDistance(shape_ids []ShapeId, point geom.Vec3) float64 {
+	in_region := make(map[ShapeId]bool)
+	for _, shape_id := range shape_ids {
+		in_region[shape_id] = true
+	}
+	return bvh.regionSignedDistance(in_region, point)
+}
+
+func (bvh *BVH) regionSignedDistance(in_region map[ShapeId]bool, point geom.Vec3) float64 {
+	distance := math.Inf(1)
+	nearest := bvh.nearestSurface(point, func(mesh_id MeshId) bool {
+		return in_region[mesh_id[0]] != in_region[mesh_id[1]]
+	})
+	if nearest != nil {
+		distance = nearest.Distance
+	}
+	if in_region[bvh.ShapeAt(point)] {
+		return -distance
+	}
+	return distance
+}
+
+/* A regular grid of signed distances from the boundary of a region, indexed
+ * with x varying fastest, with samples at Origin + Spacing * index.
+ */
+type DistanceField struct {
+	Dims    [3]int
+	Spacing [3]float64
+	Origin  [3]float64
+	Values  []float32
+}
+
+// Samples the signed distance from the boundary of the region made up of the
+// given shapes onto a regular grid, concurrently.
+func (bvh *BVH) SampleSignedDistance(
+	shape_ids []ShapeId,
+	spacing, origin [3]float64,
+	dims [3]int,
+) (field *DistanceField, err error) {
+	for axis := 0; axis < 3; axis++ {
+		if dims[axis] < 1 || !(spacing[axis] > 0) {
+			err = errors.New("Distance field dimensions and spacing must be positive")
+			return
+		}
+	}
+	count, err := voxelCount(dims)
+	if err != nil {
+		return
+	}
+	in_region := make(map[ShapeId]bool)
+	for _, shape_id := range shape_ids {
+		in_region[shape_id] = true
+	}
+
+	field = &DistanceField{
+		Dims:    dims,
+		Spacing: spacing,
+		Origin:  origin,
+		Values:  make([]float32, count),
+	}
+	eachConcurrently(len(field.Values), func(i int) {
+		x, y, z := i%dims[0], (i/dims[0])%dims[1], i/(dims[0]*dims[1])
+		point := geom.Vec3{
+			origin[0] + spacing[0]*float64(x),
+			origin[1] + spacing[1]*float64(y),
+			origin[2] + spacing[2]*float64(z),
+		}
+		field.Values[i] = float32(bvh.regionSignedDistance(in_region, point))
+	})
+	return
+}
+
+func (field *DistanceField) encodeValues(w io.Writer) error {
+	return binary.Write(w, binary.LittleEndian, field.Values)
+}
+
+// Writes a distance field as float32 voxels of a NRRD (.nrrd) or NIfTI-1
+// (.nii, .nii.gz) file
+func WriteDistanceFieldFile(field *DistanceField, volume_file_path string) (err error) {
+	lower_path := strings.ToLower(volume_file_path)
+	switch {
+	case strings.HasSuffix(lower_path, ".nrrd"):
+		return writeNRRDFile(volume_file_path, field.Dims, field.Spacing, field.Origin,
+			false, "float32", field.encodeValues)
+	case strings.HasSuffix(lower_path, ".nii"), strings.HasSuffix(lower_path, ".nii.gz"):
+		return writeNIfTIFile(volume_file_path, field.Dims, field.Spacing, field.Origin,
+			"float32", field.encodeValues)
+	}
+	err = errors.New("Unsupported volume file type: " + volume_file_path)
+	return
+}
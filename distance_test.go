@@ -0,0 +1,126 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNearestSurface(t *testing.T) {
+	bvh := boxRowShapeSet(t).BuildBVH()
+	for _, c := range []struct {
+		point    geom.Vec3
+		nearest  geom.Vec3
+		mesh_ids []MeshId
+	}{
+		{geom.Vec3{1.5, 0.5, 2}, geom.Vec3{1.5, 0.5, 1}, []MeshId{{0, 2}}},
+		{geom.Vec3{1.1, 0.5, 0.5}, geom.Vec3{1, 0.5, 0.5}, []MeshId{{1, 2}}},
+		// nearest points on edges may be on any of the meshes which meet there
+		{geom.Vec3{-1, -1, 0.5}, geom.Vec3{0, 0, 0.5}, []MeshId{{0, 1}}},
+		{geom.Vec3{2, -1, 0.5}, geom.Vec3{2, 0, 0.5}, []MeshId{{0, 2}, {0, 3}, {2, 3}}},
+	} {
+		nearest := bvh.NearestSurface(c.point)
+		if nearest == nil {
+			t.Fatalf("Expected a surface point nearest to %v", c.point)
+		}
+		on_mesh := false
+		for _, mesh_id := range c.mesh_ids {
+			on_mesh = on_mesh || nearest.MeshId == mesh_id
+		}
+		distance := math.Sqrt(math.Pow(c.point.X-c.nearest.X, 2) +
+			math.Pow(c.point.Y-c.nearest.Y, 2) + math.Pow(c.point.Z-c.nearest.Z, 2))
+		if !on_mesh || math.Abs(nearest.Distance-distance) > 1e-9 ||
+			math.Abs(nearest.Point.X-c.nearest.X) > 1e-9 || math.Abs(nearest.Point.Y-c.nearest.Y) > 1e-9 ||
+			math.Abs(nearest.Point.Z-c.nearest.Z) > 1e-9 {
+			t.Errorf("Expected %v to be nearest to %v on %v, got %+v", c.point, c.nearest, c.mesh_ids, nearest)
+		}
+		if sum := nearest.Barycentric[0] + nearest.Barycentric[1] + nearest.Barycentric[2]; math.Abs(sum-1) > 1e-9 {
+			t.Errorf("Expected barycentric coordinates summing to 1, got %v", nearest.Barycentric)
+		}
+	}
+
+	if nearest := New("empty", nil, nil).BuildBVH().NearestSurface(geom.Vec3{}); nearest != nil {
+		t.Errorf("Expected no surface point without faces, got %+v", nearest)
+	}
+}
+
+func TestSignedDistance(t *testing.T) {
+	bvh := boxRowShapeSet(t).BuildBVH()
+	for _, c := range []struct {
+		shape_ids []ShapeId
+		point     geom.Vec3
+		distance  float64
+	}{
+		{[]ShapeId{2}, geom.Vec3{1.5, 0.5, 0.5}, -0.5},
+		{[]ShapeId{2}, geom.Vec3{1.2, 0.5, 0.5}, -0.2},
+		{[]ShapeId{2}, geom.Vec3{0.5, 0.5, 0.5}, 0.5},
+		{[]ShapeId{1}, geom.Vec3{3.5, 0.5, 0.5}, 2.5},
+		{[]ShapeId{1}, geom.Vec3{0.5, 0.5, 3}, 2},
+		// the mesh between shapes in the region isn't part of its boundary
+		{[]ShapeId{1, 2}, geom.Vec3{1.1, 0.5, 0.5}, -0.5},
+		{[]ShapeId{1, 2, 3}, geom.Vec3{4, 0.5, 0.5}, 1},
+		{[]ShapeId{9}, geom.Vec3{0.5, 0.5, 0.5}, math.Inf(1)},
+	} {
+		distance := bvh.RegionSignedDistance(c.shape_ids, c.point)
+		if math.Abs(distance-c.distance) > 1e-9 && distance != c.distance {
+			t.Errorf("Expected %v to be %g from the region %v, got %g", c.point, c.distance, c.shape_ids, distance)
+		}
+		if len(c.shape_ids) == 1 && distance != bvh.SignedDistance(c.shape_ids[0], c.point) {
+			t.Errorf("Expected the signed distance of a single shape to match its region")
+		}
+	}
+}
+
+func TestSampleSignedDistance(t *testing.T) {
+	bvh := boxRowShapeSet(t).BuildBVH()
+	spacing, origin, dims := [3]float64{0.25, 0.5, 0.5}, [3]float64{0.5, 0, 0.25}, [3]int{9, 3, 2}
+	field, err := bvh.SampleSignedDistance([]ShapeId{2}, spacing, origin, dims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(field.Values) != 9*3*2 {
+		t.Fatalf("Expected a sample per voxel, got %d", len(field.Values))
+	}
+	for i, value := range field.Values {
+		x, y, z := i%dims[0], (i/dims[0])%dims[1], i/(dims[0]*dims[1])
+		point := geom.Vec3{
+			origin[0] + spacing[0]*float64(x),
+			origin[1] + spacing[1]*float64(y),
+			origin[2] + spacing[2]*float64(z),
+		}
+		if expected := float32(bvh.SignedDistance(2, point)); value != expected {
+			t.Errorf("Expected the sample at %v to be %g, got %g", point, expected, value)
+		}
+	}
+
+	nrrd_file_path := filepath.Join(t.TempDir(), "distance.nrrd")
+	if err = WriteDistanceFieldFile(field, nrrd_file_path); err != nil {
+		t.Fatal(err)
+	}
+	header, err := os.ReadFile(nrrd_file_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(header), "\ntype: float\n") {
+		t.Errorf("Expected the distance field to be written as floats")
+	}
+	// the values are rounded to labels when read as a label volume
+	lv, err := ReadNRRDFile(nrrd_file_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.Dims != dims || lv.Spacing != spacing || lv.Origin != origin {
+		t.Errorf("Expected the grid of the distance field, got %v spaced %v from %v",
+			lv.Dims, lv.Spacing, lv.Origin)
+	}
+
+	if _, err = bvh.SampleSignedDistance([]ShapeId{2}, [3]float64{1, 0, 1}, origin, dims); err == nil {
+		t.Errorf("Expected a zero spacing to be rejected")
+	}
+	if _, err = bvh.SampleSignedDistance([]ShapeId{2}, spacing, origin, [3]int{1 << 20, 1 << 20, 1}); err == nil {
+		t.Errorf("Expected oversized dimensions to be rejected")
+	}
+}
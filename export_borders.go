@@ -0,0 +1,233 @@
+package shapeset
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Export of borders as polylines, i.e. the curves along which three or more
+ * meshes meet, either as OBJ line elements or as JSON of the form:
+ *
+ * [
+ *   {
+ *     "BorderId": 1,
+ *     "Description": "0-1_0-2_1-2",
+ *     "Shapes": {"1": "label", "2": "label"},
+ *     "Chains": [[[x, y, z], ...], ...]
+ *   },
+ *   ...
+ * ]
+ *
+ * Chains of closed loops end with a repeat of their first vertex.
+ */
+
+// Orders the edges of the border into chains of consecutive vertices. Chains
+// run between vertices where the border ends or branches, whatever remains is
+// made up of closed loops. Vertices of the border not on any of its edges make
+// up chains of their own.
+func (b *Border) Chains() (chains [][]*Vertex) {
+	// collect edges touching each vertex, in order of first occurrence
+	vertex_order := make([]*Vertex, 0)
+	vertex_edges := make(map[*Vertex][]*Edge)
+	add_vertex_edge := func(v *Vertex, e *Edge) {
+		if _, seen := vertex_edges[v]; !seen {
+			vertex_order = append(vertex_order, v)
+		}
+		vertex_edges[v] = append(vertex_edges[v], e)
+	}
+	for _, e := range b.Edges {
+		if e.Collapsed || e.Removed {
+			continue
+		}
+		add_vertex_edge(e.Vertex1(), e)
+		add_vertex_edge(e.Vertex2(), e)
+	}
+
+	visited := make(map[*Edge]bool)
+	walk := func(start *Vertex, first_edge *Edge) (chain []*Vertex) {
+		chain = []*Vertex{start}
+		v, e := start, first_edge
+		for e != nil {
+			visited[e] = true
+			if e.Vertex1() == v {
+				v = e.Vertex2()
+			} else {
+				v = e.Vertex1()
+			}
+			chain = append(chain, v)
+			// continue only through vertices which neither end nor branch the border
+			e = nil
+			if len(vertex_edges[v]) == 2 {
+				for _, next_edge := range vertex_edges[v] {
+					if !visited[next_edge] {
+						e = next_edge
+					}
+				}
+			}
+		}
+		return
+	}
+
+	// open chains start from vertices where the border ends or branches
+	for _, v := range vertex_order {
+		if len(vertex_edges[v]) == 2 {
+			continue
+		}
+		for _, e := range vertex_edges[v] {
+			if !visited[e] {
+				chains = append(chains, walk(v, e))
+			}
+		}
+	}
+
+	// any remaining edges form closed loops
+	for _, v := range vertex_order {
+		for _, e := range vertex_edges[v] {
+			if !visited[e] {
+				chains = append(chains, walk(v, e))
+			}
+		}
+	}
+
+	for _, v := range b.Vertices {
+		if _, on_edge := vertex_edges[v]; !on_edge {
+			chains = append(chains, []*Vertex{v})
+		}
+	}
+	return
+}
+
+// Lists the ids of the shapes either side of the meshes that meet at the border
+func (b *Border) ShapeIds() (shape_ids []ShapeId) {
+	seen := make(map[ShapeId]bool)
+	for _, mesh_id := range b.MeshIds {
+		for _, shape_id := range mesh_id {
+			if !seen[shape_id] {
+				seen[shape_id] = true
+				shape_ids = append(shape_ids, shape_id)
+			}
+		}
+	}
+	sort.Sort(ByShapeId(shape_ids))
+	return
+}
+
+type ByShapeId []ShapeId
+
+func (a ByShapeId) Len() int           { return len(a) }
+func (a ByShapeId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByShapeId) Less(i, j int) bool { return a[i] < a[j] }
+
+// Lists all borders of the shapeset ordered by BorderId
+func (ss *ShapeSet) sortedBorders() (borders []*Border) {
+	ss.BordersIndex.Each(func(b *Border) {
+		borders = append(borders, b)
+	})
+	sort.Slice(borders, func(i, j int) bool { return borders[i].Id < borders[j].Id })
+	return
+}
+
+// Writes every border as an OBJ object made up of line elements, one per chain
+func (ss *ShapeSet) WriteBordersOBJ(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	vertex_count := 0
+	for _, b := range ss.sortedBorders() {
+		desc := b.Description()
+		fmt.Fprintf(bw, "o border_%d\n# %s\n", b.Id, desc.ToString())
+		for _, chain := range b.Chains() {
+			indices := make([]string, 0, len(chain))
+			for _, v := range chain {
+				if len(indices) > 0 && v == chain[0] {
+					// close loops by referring back to the first vertex
+					indices = append(indices, indices[0])
+					continue
+				}
+				fmt.Fprintf(bw, "v %s %s %s\n",
+					strconv.FormatFloat(v.X, 'g', -1, 64),
+					strconv.FormatFloat(v.Y, 'g', -1, 64),
+					strconv.FormatFloat(v.Z, 'g', -1, 64))
+				vertex_count++
+				indices = append(indices, strconv.Itoa(vertex_count))
+			}
+			if len(indices) == 1 {
+				fmt.Fprintf(bw, "p %s\n", indices[0])
+			} else {
+				fmt.Fprintf(bw, "l %s\n", strings.Join(indices, " "))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+type borderCurves struct {
+	BorderId    BorderId
+	Description string
+	Shapes      map[string]string
+	Chains      [][][3]float64
+}
+
+// Writes every border as JSON with its chains and the labels of the shapes
+// which meet at it.
+func (ss *ShapeSet) WriteBordersJSON(w io.Writer) (err error) {
+	curves := make([]borderCurves, 0)
+	for _, b := range ss.sortedBorders() {
+		desc := b.Description()
+		curve := borderCurves{
+			BorderId:    b.Id,
+			Description: desc.ToString(),
+			Shapes:      make(map[string]string),
+			Chains:      make([][][3]float64, 0),
+		}
+		for _, shape_id := range b.ShapeIds() {
+			if label, exists := ss.Shapes[shape_id]; exists {
+				curve.Shapes[shape_id.ToString()] = label
+			}
+		}
+		for _, chain := range b.Chains() {
+			points := make([][3]float64, 0, len(chain))
+			for _, v := range chain {
+				points = append(points, [3]float64{v.X, v.Y, v.Z})
+			}
+			curve.Chains = append(curve.Chains, points)
+		}
+		curves = append(curves, curve)
+	}
+
+	encoded, err := json.MarshalIndent(curves, "", "  ")
+	if err != nil {
+		return
+	}
+	_, err = w.Write(encoded)
+	return
+}
+
+// Writes the borders of the shapeset as a .obj or .json file according to the
+// extension of the given path.
+func (ss *ShapeSet) WriteBordersFile(borders_file_path string) (err error) {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(borders_file_path)) {
+	case ".obj":
+		write = ss.WriteBordersOBJ
+	case ".json":
+		write = ss.WriteBordersJSON
+	default:
+		err = errors.New("Border export requires a .obj or .json file: " + borders_file_path)
+		return
+	}
+
+	output_file, err := os.Create(borders_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	err = write(output_file)
+	return
+}
@@ -0,0 +1,97 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteBordersJSON(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	var buf bytes.Buffer
+	if err := ss.WriteBordersJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var curves []borderCurves
+	if err := json.Unmarshal(buf.Bytes(), &curves); err != nil {
+		t.Fatal(err)
+	}
+	if len(curves) != len(boxRowBorders) {
+		t.Fatalf("Expected %d borders, got %d", len(boxRowBorders), len(curves))
+	}
+
+	expected_shapes := []map[string]string{
+		{"1": "left", "2": "middle"},
+		{"2": "middle", "3": "right"},
+	}
+	for i, border := range boxRowBorders {
+		curve := curves[i]
+		desc := BorderDescriptionFromMeshIds(border.MeshIds)
+		if curve.BorderId != border.Id || curve.Description != desc.ToString() {
+			t.Errorf("Expected border %d to be %s, got %d %s",
+				border.Id, desc.ToString(), curve.BorderId, curve.Description)
+		}
+		if len(curve.Shapes) != len(expected_shapes[i]) {
+			t.Errorf("Expected border %d between shapes %v, got %v", border.Id, expected_shapes[i], curve.Shapes)
+		}
+		for shape_id, label := range expected_shapes[i] {
+			if curve.Shapes[shape_id] != label {
+				t.Errorf("Expected border %d between shapes %v, got %v", border.Id, expected_shapes[i], curve.Shapes)
+			}
+		}
+
+		// the square where the boxes meet is a single closed loop
+		if len(curve.Chains) != 1 || len(curve.Chains[0]) != 5 {
+			t.Fatalf("Expected border %d to be a loop of 4 vertices, got %v", border.Id, curve.Chains)
+		}
+		chain := curve.Chains[0]
+		if chain[0] != chain[4] {
+			t.Errorf("Expected the loop of border %d to end where it starts, got %v", border.Id, chain)
+		}
+		for j, point := range chain[:4] {
+			if point[0] != border.X {
+				t.Errorf("Expected border %d to lie at x of %g, got %v", border.Id, border.X, point)
+			}
+			// consecutive corners of the square differ in one coordinate
+			next := chain[j+1]
+			if (point[1] != next[1]) == (point[2] != next[2]) {
+				t.Errorf("Expected border %d to run along the sides of the square, got %v", border.Id, chain)
+			}
+		}
+	}
+}
+
+func TestWriteBordersOBJ(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	var buf bytes.Buffer
+	if err := ss.WriteBordersOBJ(&buf); err != nil {
+		t.Fatal(err)
+	}
+	objects, vertices := 0, 0
+	lines := make([][]string, 0)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "o":
+			objects++
+		case "v":
+			vertices++
+		case "l":
+			lines = append(lines, fields[1:])
+		}
+	}
+	if objects != 2 || vertices != 8 || len(lines) != 2 {
+		t.Fatalf("Expected 2 objects with 4 vertices and a line each, got %d, %d and %d",
+			objects, vertices, len(lines))
+	}
+	// loops refer back to their first vertex rather than repeating it
+	for i, indices := range lines {
+		if len(indices) != 5 || indices[0] != indices[4] || indices[0] != []string{"1", "5"}[i] {
+			t.Errorf("Expected line %d to loop through 4 vertices, got %v", i, indices)
+		}
+	}
+}
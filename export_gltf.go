@@ -0,0 +1,274 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+/* Export of composed regions as a binary glTF 2.0 (.glb) scene.
+ *
+ * Each region becomes a node named from the labels of its shapes, with a mesh
+ * made up of one primitive per interface mesh contributing to its surface.
+ * Each primitive uses the material of the shape on the inside of the region.
+ */
+
+const (
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfTriangles    = 4
+)
+
+type gltfDocument struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Name  string `json:"name"`
+	Nodes []int  `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name string `json:"name"`
+	Mesh int    `json:"mesh"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   int            `json:"material"`
+	Mode       int            `json:"mode"`
+}
+
+type gltfMaterial struct {
+	Name                 string          `json:"name"`
+	PbrMetallicRoughness gltfPbrMaterial `json:"pbrMetallicRoughness"`
+}
+
+type gltfPbrMaterial struct {
+	BaseColorFactor [4]float64 `json:"baseColorFactor"`
+	MetallicFactor  float64    `json:"metallicFactor"`
+	RoughnessFactor float64    `json:"roughnessFactor"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min,omitempty"`
+	Max           []float64 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfBuffer struct {
+	ByteLength int `json:"byteLength"`
+}
+
+// Accumulates the binary buffer and the json document describing it
+type gltfBuilder struct {
+	doc           gltfDocument
+	bin           bytes.Buffer
+	shapeMaterial map[ShapeId]int
+}
+
+// Appends data to the binary buffer as a new buffer view, keeping views
+// aligned to 4 bytes as required by the spec.
+func (gb *gltfBuilder) addBufferView(data interface{}, target int) int {
+	offset := gb.bin.Len()
+	binary.Write(&gb.bin, binary.LittleEndian, data)
+	length := gb.bin.Len() - offset
+	for gb.bin.Len()%4 != 0 {
+		gb.bin.WriteByte(0)
+	}
+	gb.doc.BufferViews = append(gb.doc.BufferViews, gltfBufferView{
+		ByteOffset: offset,
+		ByteLength: length,
+		Target:     target,
+	})
+	return len(gb.doc.BufferViews) - 1
+}
+
+func (gb *gltfBuilder) addAccessor(accessor gltfAccessor) int {
+	gb.doc.Accessors = append(gb.doc.Accessors, accessor)
+	return len(gb.doc.Accessors) - 1
+}
+
+func (gb *gltfBuilder) materialFor(ss *ShapeSet, shape_id ShapeId) int {
+	if material, exists := gb.shapeMaterial[shape_id]; exists {
+		return material
+	}
+	gb.doc.Materials = append(gb.doc.Materials, gltfMaterial{
+		Name: ss.Shapes[shape_id],
+		PbrMetallicRoughness: gltfPbrMaterial{
+			BaseColorFactor: ss.ShapeColor(shape_id),
+			MetallicFactor:  0,
+			RoughnessFactor: 1,
+		},
+	})
+	gb.shapeMaterial[shape_id] = len(gb.doc.Materials) - 1
+	return gb.shapeMaterial[shape_id]
+}
+
+func (gb *gltfBuilder) addPatch(ss *ShapeSet, patch *regionPatch) gltfPrimitive {
+	positions := make([]float32, 0, len(patch.Vertices)*3)
+	pos_min := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	pos_max := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, v := range patch.Vertices {
+		for i, x := range [3]float64{v.X, v.Y, v.Z} {
+			// accessor bounds must match the stored float32 values exactly
+			x32 := float32(x)
+			positions = append(positions, x32)
+			pos_min[i] = math.Min(pos_min[i], float64(x32))
+			pos_max[i] = math.Max(pos_max[i], float64(x32))
+		}
+	}
+	normals := make([]float32, 0, len(patch.Vertices)*3)
+	for _, n := range patch.Normals() {
+		normals = append(normals, float32(n.X), float32(n.Y), float32(n.Z))
+	}
+	indices := make([]uint32, 0, len(patch.Faces)*3)
+	for _, face := range patch.Faces {
+		indices = append(indices, uint32(face[0]), uint32(face[1]), uint32(face[2]))
+	}
+
+	position_accessor := gb.addAccessor(gltfAccessor{
+		BufferView:    gb.addBufferView(positions, gltfArrayBuffer),
+		ComponentType: gltfFloat,
+		Count:         len(patch.Vertices),
+		Type:          "VEC3",
+		Min:           pos_min,
+		Max:           pos_max,
+	})
+	normal_accessor := gb.addAccessor(gltfAccessor{
+		BufferView:    gb.addBufferView(normals, gltfArrayBuffer),
+		ComponentType: gltfFloat,
+		Count:         len(patch.Vertices),
+		Type:          "VEC3",
+	})
+	index_accessor := gb.addAccessor(gltfAccessor{
+		BufferView:    gb.addBufferView(indices, gltfElementArray),
+		ComponentType: gltfUnsignedInt,
+		Count:         len(indices),
+		Type:          "SCALAR",
+	})
+
+	return gltfPrimitive{
+		Attributes: map[string]int{
+			"POSITION": position_accessor,
+			"NORMAL":   normal_accessor,
+		},
+		Indices:  index_accessor,
+		Material: gb.materialFor(ss, patch.Inner),
+		Mode:     gltfTriangles,
+	}
+}
+
+/* Writes the regions defined by each given list of shape ids into a single
+ * binary glTF scene, one node per region.
+ */
+func (ss *ShapeSet) WriteGLB(w io.Writer, regions ...[]int) (err error) {
+	if len(regions) == 0 {
+		err = errors.New("At least one region is required for glTF export")
+		return
+	}
+
+	gb := &gltfBuilder{shapeMaterial: make(map[ShapeId]int)}
+	gb.doc.Asset = gltfAsset{Version: "2.0", Generator: "shapeset"}
+	gb.doc.Scenes = []gltfScene{{Name: ss.Name}}
+
+	for _, shape_ids := range regions {
+		region_name := ss.regionName(shape_ids...)
+		region_mesh := gltfMesh{Name: region_name}
+		for _, patch := range ss.regionPatches(shape_ids...) {
+			if len(patch.Faces) == 0 {
+				continue
+			}
+			region_mesh.Primitives = append(region_mesh.Primitives, gb.addPatch(ss, patch))
+		}
+		if len(region_mesh.Primitives) == 0 {
+			err = errors.New("Region has no surface: " + region_name)
+			return
+		}
+		gb.doc.Meshes = append(gb.doc.Meshes, region_mesh)
+		gb.doc.Nodes = append(gb.doc.Nodes, gltfNode{
+			Name: region_name,
+			Mesh: len(gb.doc.Meshes) - 1,
+		})
+		gb.doc.Scenes[0].Nodes = append(gb.doc.Scenes[0].Nodes, len(gb.doc.Nodes)-1)
+	}
+	gb.doc.Buffers = []gltfBuffer{{ByteLength: gb.bin.Len()}}
+
+	json_chunk, err := json.Marshal(gb.doc)
+	if err != nil {
+		return
+	}
+	for len(json_chunk)%4 != 0 {
+		json_chunk = append(json_chunk, ' ')
+	}
+
+	// write the glb header followed by the json and binary chunks
+	total_length := 12 + 8 + len(json_chunk) + 8 + gb.bin.Len()
+	header := []uint32{
+		0x46546C67, // "glTF"
+		2,
+		uint32(total_length),
+		uint32(len(json_chunk)),
+		0x4E4F534A, // "JSON"
+	}
+	if err = binary.Write(w, binary.LittleEndian, header); err != nil {
+		return
+	}
+	if _, err = w.Write(json_chunk); err != nil {
+		return
+	}
+	bin_header := []uint32{
+		uint32(gb.bin.Len()),
+		0x004E4942, // "BIN\0"
+	}
+	if err = binary.Write(w, binary.LittleEndian, bin_header); err != nil {
+		return
+	}
+	_, err = w.Write(gb.bin.Bytes())
+	return
+}
+
+func (ss *ShapeSet) WriteGLBFile(glb_file_path string, regions ...[]int) (err error) {
+	output_file, err := os.Create(glb_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	err = ss.WriteGLB(output_file, regions...)
+	return
+}
@@ -0,0 +1,109 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// Splits a glb file into its json document and binary buffer
+func readGLB(t *testing.T, data []byte) (doc gltfDocument, bin []byte) {
+	var header [5]uint32
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x46546C67 || header[1] != 2 || int(header[2]) != len(data) {
+		t.Fatalf("Malformed glb header %x", header)
+	}
+	json_end := 20 + int(header[3])
+	if err := json.Unmarshal(data[20:json_end], &doc); err != nil {
+		t.Fatal(err)
+	}
+	bin = data[json_end+8:]
+	if int(binary.LittleEndian.Uint32(data[json_end:])) != len(bin) ||
+		len(doc.Buffers) != 1 || doc.Buffers[0].ByteLength != len(bin) {
+		t.Fatalf("Binary chunk doesn't match the buffer")
+	}
+	return
+}
+
+// The volume enclosed by the primitives of a glb mesh, which is positive if its
+// faces point outwards.
+func glbMeshVolume(doc gltfDocument, bin []byte, mesh_index int) (volume float64) {
+	view := func(accessor int) []byte {
+		bv := doc.BufferViews[doc.Accessors[accessor].BufferView]
+		return bin[bv.ByteOffset : bv.ByteOffset+bv.ByteLength]
+	}
+	for _, primitive := range doc.Meshes[mesh_index].Primitives {
+		positions := make([]float32, doc.Accessors[primitive.Attributes["POSITION"]].Count*3)
+		binary.Read(bytes.NewReader(view(primitive.Attributes["POSITION"])), binary.LittleEndian, positions)
+		indices := make([]uint32, doc.Accessors[primitive.Indices].Count)
+		binary.Read(bytes.NewReader(view(primitive.Indices)), binary.LittleEndian, indices)
+		corner := func(i uint32) [3]float64 {
+			return [3]float64{float64(positions[i*3]), float64(positions[i*3+1]), float64(positions[i*3+2])}
+		}
+		for i := 0; i < len(indices); i += 3 {
+			a, b, c := corner(indices[i]), corner(indices[i+1]), corner(indices[i+2])
+			volume += (a[0]*(b[1]*c[2]-b[2]*c[1]) -
+				a[1]*(b[0]*c[2]-b[2]*c[0]) +
+				a[2]*(b[0]*c[1]-b[1]*c[0])) / 6
+		}
+	}
+	return
+}
+
+func TestWriteGLB(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	var buf bytes.Buffer
+	if err := ss.WriteGLB(&buf, []int{1}, []int{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	doc, bin := readGLB(t, buf.Bytes())
+
+	expected := []struct {
+		name       string
+		primitives int
+		volume     float64
+	}{{"left", 2, 1}, {"middle_right", 3, 2}}
+	if len(doc.Nodes) != len(expected) || len(doc.Scenes[0].Nodes) != len(expected) {
+		t.Fatalf("Expected a node per region, got %+v", doc.Nodes)
+	}
+	for i, region := range expected {
+		node := doc.Nodes[i]
+		if node.Name != region.name || len(doc.Meshes[node.Mesh].Primitives) != region.primitives {
+			t.Errorf("Expected region %s with %d primitives, got %s with %d", region.name,
+				region.primitives, node.Name, len(doc.Meshes[node.Mesh].Primitives))
+		}
+		if volume := glbMeshVolume(doc, bin, node.Mesh); math.Abs(volume-region.volume) > 1e-6 {
+			t.Errorf("Expected region %s to face outwards enclosing %g, got %g",
+				region.name, region.volume, volume)
+		}
+	}
+
+	// a material per shape inside a region, named by its label
+	names := make(map[string]bool)
+	for _, material := range doc.Materials {
+		names[material.Name] = true
+	}
+	if len(doc.Materials) != 3 || !names["left"] || !names["middle"] || !names["right"] {
+		t.Errorf("Expected a material for each shape, got %+v", doc.Materials)
+	}
+	position := doc.Accessors[doc.Meshes[0].Primitives[0].Attributes["POSITION"]]
+	if position.Min[0] != 0 || position.Max[0] != 1 {
+		t.Errorf("Expected positions of the left box to span 0 to 1, got %v to %v",
+			position.Min, position.Max)
+	}
+}
+
+func TestWriteGLBRejectsEmptyRegions(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	var buf bytes.Buffer
+	if err := ss.WriteGLB(&buf); err == nil {
+		t.Errorf("Expected an export without regions to fail")
+	}
+	if err := ss.WriteGLB(&buf, []int{7}); err == nil {
+		t.Errorf("Expected a region without a surface to fail")
+	}
+}
@@ -0,0 +1,140 @@
+package shapeset
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/nat-n/geom"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Export of a composed region as a single OBJ file, organised for editing in
+ * standard modelling tools.
+ *
+ * The region is written as one object, with a group per interface mesh
+ * contributing to its surface, named from the labels of the shapes inside and
+ * outside of the region, e.g. g hippocampus__cortex. Each group uses the
+ * material of the neighbouring shape on the outside of the region, defined in
+ * an accompanying .mtl file, and has a smoothing group of its own so that
+ * shading breaks along the border curves where interface meshes meet.
+ * Vertices are shared between groups so the surface remains connected.
+ */
+
+// Derives a name usable in OBJ and MTL statements for a shape from its label,
+// falling back on the ShapeId for shapes without one.
+func (ss *ShapeSet) objShapeName(shape_id ShapeId) string {
+	if label := ss.Shapes[shape_id]; label != "" {
+		return unsafeFileNameChars.ReplaceAllString(label, "_")
+	}
+	return "shape_" + shape_id.ToString()
+}
+
+// Writes the region defined by the given shapes as an OBJ document referring
+// to materials in the named material library, as written by WriteRegionMTL.
+func (ss *ShapeSet) WriteRegionOBJ(w io.Writer, mtl_name string, shape_ids ...int) (err error) {
+	region_name := ss.regionName(shape_ids...)
+	patches := ss.regionPatches(shape_ids...)
+	if len(patches) == 0 {
+		err = errors.New("Region has no surface: " + region_name)
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", ss.Name)
+	if mtl_name != "" {
+		fmt.Fprintf(bw, "mtllib %s\n", mtl_name)
+	}
+	fmt.Fprintf(bw, "o %s\n", unsafeFileNameChars.ReplaceAllString(region_name, "_"))
+
+	// vertices where patches meet are written once and shared between groups
+	vertex_indices := make(map[geom.Vec3]int)
+	smoothing_group := 0
+	for _, patch := range patches {
+		if len(patch.Faces) == 0 {
+			continue
+		}
+		smoothing_group++
+		patch_indices := make([]int, len(patch.Vertices))
+		for i, v := range patch.Vertices {
+			index, written := vertex_indices[v.Vec3]
+			if !written {
+				fmt.Fprintf(bw, "v %s %s %s\n",
+					strconv.FormatFloat(v.X, 'g', -1, 64),
+					strconv.FormatFloat(v.Y, 'g', -1, 64),
+					strconv.FormatFloat(v.Z, 'g', -1, 64))
+				index = len(vertex_indices) + 1
+				vertex_indices[v.Vec3] = index
+			}
+			patch_indices[i] = index
+		}
+
+		mesh_id := patch.Mesh.Id()
+		fmt.Fprintf(bw, "g %s__%s\n# mesh %s\n",
+			ss.objShapeName(patch.Inner),
+			ss.objShapeName(patch.Outer),
+			mesh_id.ToString())
+		fmt.Fprintf(bw, "usemtl %s\n", ss.objShapeName(patch.Outer))
+		fmt.Fprintf(bw, "s %d\n", smoothing_group)
+		for _, face := range patch.Faces {
+			fmt.Fprintf(bw, "f %d %d %d\n",
+				patch_indices[face[0]],
+				patch_indices[face[1]],
+				patch_indices[face[2]])
+		}
+	}
+	return bw.Flush()
+}
+
+// Writes a material library with a material per shape neighbouring the region
+// defined by the given shapes, colored as by ShapeColor.
+func (ss *ShapeSet) WriteRegionMTL(w io.Writer, shape_ids ...int) (err error) {
+	bw := bufio.NewWriter(w)
+	written := make(map[ShapeId]bool)
+	for _, patch := range ss.regionPatches(shape_ids...) {
+		if written[patch.Outer] {
+			continue
+		}
+		written[patch.Outer] = true
+		color := ss.ShapeColor(patch.Outer)
+		fmt.Fprintf(bw, "newmtl %s\n", ss.objShapeName(patch.Outer))
+		fmt.Fprintf(bw, "Kd %s %s %s\n",
+			strconv.FormatFloat(color[0], 'g', 6, 64),
+			strconv.FormatFloat(color[1], 'g', 6, 64),
+			strconv.FormatFloat(color[2], 'g', 6, 64))
+		fmt.Fprintf(bw, "d %s\nillum 1\n\n", strconv.FormatFloat(color[3], 'g', 6, 64))
+	}
+	return bw.Flush()
+}
+
+// Writes the region defined by the given shapes to an OBJ file, along with a
+// material library of the same name with the extension .mtl. Nothing is written
+// if the region has no surface.
+func (ss *ShapeSet) WriteRegionOBJFile(obj_file_path string, shape_ids ...int) (err error) {
+	mtl_file_path := strings.TrimSuffix(obj_file_path, filepath.Ext(obj_file_path)) + ".mtl"
+	if len(ss.regionPatches(shape_ids...)) == 0 {
+		err = errors.New("Region has no surface: " + ss.regionName(shape_ids...))
+		return
+	}
+
+	obj_file, err := os.Create(obj_file_path)
+	if err != nil {
+		return
+	}
+	defer obj_file.Close()
+	err = ss.WriteRegionOBJ(obj_file, filepath.Base(mtl_file_path), shape_ids...)
+	if err != nil {
+		return
+	}
+
+	mtl_file, err := os.Create(mtl_file_path)
+	if err != nil {
+		return
+	}
+	defer mtl_file.Close()
+	err = ss.WriteRegionMTL(mtl_file, shape_ids...)
+	return
+}
@@ -0,0 +1,87 @@
+package shapeset
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteRegionOBJFile(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	ss.Metadata[1] = &ShapeMetadata{Color: &Color{1, 0, 0.5, 1}}
+	obj_path := filepath.Join(t.TempDir(), "middle.obj")
+	if err := ss.WriteRegionOBJFile(obj_path, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	obj_data, err := os.ReadFile(obj_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vertices, faces := 0, 0
+	groups := make([]string, 0)
+	materials := make([]string, 0)
+	smoothing_groups := make([]string, 0)
+	for _, line := range strings.Split(string(obj_data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "mtllib":
+			if fields[1] != "middle.mtl" {
+				t.Errorf("Expected the material library middle.mtl, got %s", fields[1])
+			}
+		case "v":
+			vertices++
+		case "f":
+			faces++
+		case "g":
+			groups = append(groups, fields[1])
+		case "usemtl":
+			materials = append(materials, fields[1])
+		case "s":
+			smoothing_groups = append(smoothing_groups, fields[1])
+		}
+	}
+	// the corners of the box are shared between the groups
+	if vertices != 8 || faces != 12 {
+		t.Errorf("Expected the 8 vertices and 12 faces of a box, got %d and %d", vertices, faces)
+	}
+	expected_groups := []string{"middle__shape_0", "middle__left", "middle__right"}
+	expected_materials := []string{"shape_0", "left", "right"}
+	if len(groups) != 3 || len(materials) != 3 || len(smoothing_groups) != 3 {
+		t.Fatalf("Expected a group, material and smoothing group per mesh, got %v, %v and %v",
+			groups, materials, smoothing_groups)
+	}
+	for i := range expected_groups {
+		if groups[i] != expected_groups[i] || materials[i] != expected_materials[i] {
+			t.Errorf("Expected group %s to use %s, got %s using %s",
+				expected_groups[i], expected_materials[i], groups[i], materials[i])
+		}
+		if smoothing_groups[i] != []string{"1", "2", "3"}[i] {
+			t.Errorf("Expected each group to have its own smoothing group, got %v", smoothing_groups)
+		}
+	}
+
+	mtl_data, err := os.ReadFile(filepath.Join(filepath.Dir(obj_path), "middle.mtl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtl := string(mtl_data)
+	if strings.Count(mtl, "newmtl ") != 3 || !strings.Contains(mtl, "newmtl left\nKd 1 0 0.5\nd 1\n") {
+		t.Errorf("Expected a material per neighbouring shape, colored from metadata, got:\n%s", mtl)
+	}
+}
+
+func TestWriteRegionOBJFileWithoutSurface(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	dir := t.TempDir()
+	if err := ss.WriteRegionOBJFile(filepath.Join(dir, "none.obj"), 9); err == nil {
+		t.Fatalf("Expected a region without a surface to be rejected")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected nothing to be written, got %d files", len(files))
+	}
+}
@@ -0,0 +1,265 @@
+package shapeset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Export of the whole shapeset as VTK PolyData, either as an XML .vtp file or
+ * as a legacy .vtk file. Every mesh contributes its faces to a single set of
+ * polygons, with shared border vertices written once.
+ *
+ * Cell data:  FrontShapeId, BackShapeId - the shapes either side of the face
+ *             as given by the MeshId of the mesh it comes from.
+ * Point data: IsBorder - 1 for vertices shared between meshes, 0 otherwise
+ *             BorderId - the BorderId of shared vertices, 0 otherwise
+ * Field data: ShapeIds, ShapeLabels - the label of each shape
+ */
+
+type vtkPolyData struct {
+	Points      []geom.Vec3
+	IsBorder    []uint8
+	BorderIds   []int32
+	Polys       [][3]int32
+	FrontShapes []int32
+	BackShapes  []int32
+	ShapeIds    []int32
+	ShapeLabels []string
+}
+
+func (ss *ShapeSet) vtkPolyData() (pd *vtkPolyData) {
+	pd = &vtkPolyData{}
+	point_indices := make(map[*Vertex]int32)
+
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(ss.Meshes))
+	for mesh_id, _ := range ss.Meshes {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+
+	for _, mesh_id := range mesh_ids {
+		ss.Meshes[mesh_id].Faces.Each(func(f gomesh.FaceI) {
+			var poly [3]int32
+			i := 0
+			f.EachVertex(func(vi gomesh.VertexI) {
+				v := vi.(*Vertex)
+				index, seen := point_indices[v]
+				if !seen {
+					index = int32(len(pd.Points))
+					point_indices[v] = index
+					pd.Points = append(pd.Points, v.Vec3)
+					if v.IsShared() {
+						pd.IsBorder = append(pd.IsBorder, 1)
+						pd.BorderIds = append(pd.BorderIds, int32(v.Border.Id))
+					} else {
+						pd.IsBorder = append(pd.IsBorder, 0)
+						pd.BorderIds = append(pd.BorderIds, 0)
+					}
+				}
+				poly[i] = index
+				i++
+			})
+			pd.Polys = append(pd.Polys, poly)
+			pd.FrontShapes = append(pd.FrontShapes, int32(mesh_id[0]))
+			pd.BackShapes = append(pd.BackShapes, int32(mesh_id[1]))
+		})
+	}
+
+	shape_ids := make([]int, 0, len(ss.Shapes))
+	for shape_id, _ := range ss.Shapes {
+		shape_ids = append(shape_ids, int(shape_id))
+	}
+	sort.Ints(shape_ids)
+	for _, shape_id := range shape_ids {
+		pd.ShapeIds = append(pd.ShapeIds, int32(shape_id))
+		pd.ShapeLabels = append(pd.ShapeLabels, ss.Shapes[ShapeId(shape_id)])
+	}
+	return
+}
+
+// Encodes data as a base64 binary DataArray body, prefixed by its byte length
+// as VTK expects for inline binary data.
+func vtkBase64(data interface{}) string {
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.LittleEndian, data)
+	var framed bytes.Buffer
+	binary.Write(&framed, binary.LittleEndian, uint32(raw.Len()))
+	framed.Write(raw.Bytes())
+	return base64.StdEncoding.EncodeToString(framed.Bytes())
+}
+
+// Lists bytes as space separated decimal values, as VTK expects for the
+// characters of ascii String arrays.
+func vtkASCIIBytes(data []byte) string {
+	values := make([]string, len(data))
+	for i, b := range data {
+		values[i] = strconv.Itoa(int(b))
+	}
+	return strings.Join(values, " ")
+}
+
+// Writes the shapeset as an XML PolyData (.vtp) document with inline base64
+// encoded binary arrays, apart from shape labels which VTK only reads from
+// ascii String arrays.
+func (ss *ShapeSet) WriteVTP(w io.Writer) (err error) {
+	pd := ss.vtkPolyData()
+
+	points := make([]float64, 0, len(pd.Points)*3)
+	for _, p := range pd.Points {
+		points = append(points, p.X, p.Y, p.Z)
+	}
+	connectivity := make([]int32, 0, len(pd.Polys)*3)
+	offsets := make([]int32, 0, len(pd.Polys))
+	for i, poly := range pd.Polys {
+		connectivity = append(connectivity, poly[0], poly[1], poly[2])
+		offsets = append(offsets, int32(i+1)*3)
+	}
+	// each label is null terminated
+	var labels bytes.Buffer
+	for _, label := range pd.ShapeLabels {
+		labels.WriteString(label)
+		labels.WriteByte(0)
+	}
+
+	bw := bufio.NewWriter(w)
+	array := func(vtk_type, name string, components int, data interface{}) {
+		fmt.Fprintf(bw, "        <DataArray type=\"%s\" Name=\"%s\" "+
+			"NumberOfComponents=\"%d\" format=\"binary\">\n          %s\n"+
+			"        </DataArray>\n", vtk_type, name, components, vtkBase64(data))
+	}
+
+	fmt.Fprintf(bw, "<?xml version=\"1.0\"?>\n")
+	fmt.Fprintf(bw, "<VTKFile type=\"PolyData\" version=\"1.0\" "+
+		"byte_order=\"LittleEndian\" header_type=\"UInt32\">\n")
+	fmt.Fprintf(bw, "  <PolyData>\n")
+	fmt.Fprintf(bw, "    <FieldData>\n")
+	array("Int32", "ShapeIds", 1, pd.ShapeIds)
+	fmt.Fprintf(bw, "        <DataArray type=\"String\" Name=\"ShapeLabels\" "+
+		"NumberOfTuples=\"%d\" format=\"ascii\">\n          %s\n"+
+		"        </DataArray>\n", len(pd.ShapeLabels), vtkASCIIBytes(labels.Bytes()))
+	fmt.Fprintf(bw, "    </FieldData>\n")
+	fmt.Fprintf(bw, "    <Piece NumberOfPoints=\"%d\" NumberOfVerts=\"0\" "+
+		"NumberOfLines=\"0\" NumberOfStrips=\"0\" NumberOfPolys=\"%d\">\n",
+		len(pd.Points), len(pd.Polys))
+	fmt.Fprintf(bw, "      <PointData Scalars=\"BorderId\">\n")
+	array("UInt8", "IsBorder", 1, pd.IsBorder)
+	array("Int32", "BorderId", 1, pd.BorderIds)
+	fmt.Fprintf(bw, "      </PointData>\n")
+	fmt.Fprintf(bw, "      <CellData Scalars=\"FrontShapeId\">\n")
+	array("Int32", "FrontShapeId", 1, pd.FrontShapes)
+	array("Int32", "BackShapeId", 1, pd.BackShapes)
+	fmt.Fprintf(bw, "      </CellData>\n")
+	fmt.Fprintf(bw, "      <Points>\n")
+	array("Float64", "Points", 3, points)
+	fmt.Fprintf(bw, "      </Points>\n")
+	fmt.Fprintf(bw, "      <Polys>\n")
+	array("Int32", "connectivity", 1, connectivity)
+	array("Int32", "offsets", 1, offsets)
+	fmt.Fprintf(bw, "      </Polys>\n")
+	fmt.Fprintf(bw, "    </Piece>\n")
+	fmt.Fprintf(bw, "  </PolyData>\n")
+	fmt.Fprintf(bw, "</VTKFile>\n")
+	return bw.Flush()
+}
+
+// Writes the shapeset as an ascii legacy VTK PolyData (.vtk) file.
+func (ss *ShapeSet) WriteLegacyVTK(w io.Writer) (err error) {
+	pd := ss.vtkPolyData()
+	bw := bufio.NewWriter(w)
+
+	// the title line must not contain newlines and is limited to 256 characters
+	title := strings.Replace(ss.Name, "\n", " ", -1)
+	if len(title) > 255 {
+		title = title[:255]
+	}
+	fmt.Fprintf(bw, "# vtk DataFile Version 3.0\n%s\nASCII\nDATASET POLYDATA\n", title)
+
+	// field data describing the shapes precedes the geometry, labels are url
+	// style escaped as legacy strings can't hold spaces, and as an empty line
+	// would be skipped by readers shapes without a label are named by id
+	fmt.Fprintf(bw, "FIELD FieldData 2\n")
+	writeLegacyVTKInts(bw, "ShapeIds", pd.ShapeIds)
+	fmt.Fprintf(bw, "ShapeLabels 1 %d string\n", len(pd.ShapeLabels))
+	for i, label := range pd.ShapeLabels {
+		if label == "" {
+			label = fmt.Sprintf("shape_%d", pd.ShapeIds[i])
+		}
+		fmt.Fprintf(bw, "%s\n", strings.NewReplacer(
+			"%", "%25", " ", "%20", "\n", "%0A", "\t", "%09").Replace(label))
+	}
+
+	fmt.Fprintf(bw, "POINTS %d double\n", len(pd.Points))
+	for _, p := range pd.Points {
+		fmt.Fprintf(bw, "%s %s %s\n",
+			strconv.FormatFloat(p.X, 'g', -1, 64),
+			strconv.FormatFloat(p.Y, 'g', -1, 64),
+			strconv.FormatFloat(p.Z, 'g', -1, 64))
+	}
+
+	fmt.Fprintf(bw, "POLYGONS %d %d\n", len(pd.Polys), len(pd.Polys)*4)
+	for _, poly := range pd.Polys {
+		fmt.Fprintf(bw, "3 %d %d %d\n", poly[0], poly[1], poly[2])
+	}
+
+	fmt.Fprintf(bw, "CELL_DATA %d\nFIELD CellFields 2\n", len(pd.Polys))
+	writeLegacyVTKInts(bw, "FrontShapeId", pd.FrontShapes)
+	writeLegacyVTKInts(bw, "BackShapeId", pd.BackShapes)
+
+	is_border := make([]int32, len(pd.IsBorder))
+	for i, b := range pd.IsBorder {
+		is_border[i] = int32(b)
+	}
+	fmt.Fprintf(bw, "POINT_DATA %d\nFIELD PointFields 2\n", len(pd.Points))
+	writeLegacyVTKInts(bw, "IsBorder", is_border)
+	writeLegacyVTKInts(bw, "BorderId", pd.BorderIds)
+
+	return bw.Flush()
+}
+
+func writeLegacyVTKInts(w io.Writer, name string, values []int32) {
+	fmt.Fprintf(w, "%s 1 %d int\n", name, len(values))
+	for i, value := range values {
+		if i > 0 && i%9 == 0 {
+			fmt.Fprintf(w, "\n")
+		} else if i > 0 {
+			fmt.Fprintf(w, " ")
+		}
+		fmt.Fprintf(w, "%d", value)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// Writes the shapeset as a .vtp or legacy .vtk file according to the extension
+// of the given path.
+func (ss *ShapeSet) WriteVTKFile(vtk_file_path string) (err error) {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(vtk_file_path)) {
+	case ".vtp":
+		write = ss.WriteVTP
+	case ".vtk":
+		write = ss.WriteLegacyVTK
+	default:
+		err = errors.New("VTK export requires a .vtp or .vtk file: " + vtk_file_path)
+		return
+	}
+
+	output_file, err := os.Create(vtk_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	err = write(output_file)
+	return
+}
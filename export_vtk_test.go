@@ -0,0 +1,165 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The faces of boxRow by the shapes either side of them, in the order the
+// meshes are written.
+var boxRowFaceShapes = []struct {
+	Front, Back int32
+	Faces       int
+}{{0, 1, 10}, {0, 2, 8}, {0, 3, 10}, {1, 2, 2}, {2, 3, 2}}
+
+// Returns the count whitespace separated values which follow the given header
+// line of a legacy vtk document.
+func legacyVTKValues(t *testing.T, doc, header string, count int) []string {
+	start := strings.Index(doc, "\n"+header+"\n")
+	if start < 0 {
+		t.Fatalf("Missing %q in:\n%s", header, doc)
+	}
+	values := strings.Fields(doc[start+len(header)+2:])
+	if len(values) < count {
+		t.Fatalf("Expected %d values after %q", count, header)
+	}
+	return values[:count]
+}
+
+func TestWriteLegacyVTK(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	ss.Shapes[2] = "middle box"
+	ss.Shapes[4] = ""
+	var buf bytes.Buffer
+	if err := ss.WriteLegacyVTK(&buf); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.String()
+	if !strings.HasPrefix(doc, "# vtk DataFile Version 3.0\nboxes\nASCII\nDATASET POLYDATA\n") {
+		t.Errorf("Unexpected header:\n%s", doc)
+	}
+
+	ids := legacyVTKValues(t, doc, "ShapeIds 1 4 int", 4)
+	labels := legacyVTKValues(t, doc, "ShapeLabels 1 4 string", 4)
+	expected_labels := []string{"left", "middle%20box", "right", "shape_4"}
+	for i, label := range expected_labels {
+		if ids[i] != strconv.Itoa(i+1) || labels[i] != label {
+			t.Errorf("Expected shape %d to be labelled %s, got %s %s", i+1, label, ids[i], labels[i])
+		}
+	}
+
+	// the 16 corners of the boxes are written once, whichever meshes use them
+	legacyVTKValues(t, doc, "POINTS 16 double", 16*3)
+	legacyVTKValues(t, doc, "POLYGONS 32 128", 32*4)
+	front := legacyVTKValues(t, doc, "FrontShapeId 1 32 int", 32)
+	back := legacyVTKValues(t, doc, "BackShapeId 1 32 int", 32)
+	i := 0
+	for _, mesh := range boxRowFaceShapes {
+		for f := 0; f < mesh.Faces; f, i = f+1, i+1 {
+			if front[i] != strconv.Itoa(int(mesh.Front)) || back[i] != strconv.Itoa(int(mesh.Back)) {
+				t.Fatalf("Expected face %d between shapes %d and %d, got %s and %s",
+					i, mesh.Front, mesh.Back, front[i], back[i])
+			}
+		}
+	}
+
+	border_points := 0
+	for _, is_border := range legacyVTKValues(t, doc, "IsBorder 1 16 int", 16) {
+		if is_border == "1" {
+			border_points++
+		}
+	}
+	if border_points != 8 {
+		t.Errorf("Expected the 8 corners where boxes meet to be border points, got %d", border_points)
+	}
+}
+
+type vtpDataArray struct {
+	Name string `xml:"Name,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Collects the data arrays of a vtp document by name, wherever they appear
+func readVTP(t *testing.T, data []byte) (arrays map[string]vtpDataArray) {
+	arrays = make(map[string]vtpDataArray)
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "DataArray" {
+			var array vtpDataArray
+			if err = dec.DecodeElement(&array, &start); err != nil {
+				t.Fatal(err)
+			}
+			arrays[array.Name] = array
+		}
+	}
+}
+
+// Decodes a base64 binary Int32 data array, checking its length prefix
+func vtpInt32s(t *testing.T, array vtpDataArray) []int32 {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(array.Body))
+	if err != nil || len(raw) < 4 || int(binary.LittleEndian.Uint32(raw)) != len(raw)-4 {
+		t.Fatalf("Malformed data array %s: %v", array.Name, err)
+	}
+	values := make([]int32, (len(raw)-4)/4)
+	binary.Read(bytes.NewReader(raw[4:]), binary.LittleEndian, values)
+	return values
+}
+
+func TestWriteVTP(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	var buf bytes.Buffer
+	if err := ss.WriteVTP(&buf); err != nil {
+		t.Fatal(err)
+	}
+	arrays := readVTP(t, buf.Bytes())
+
+	label_bytes := strings.Fields(arrays["ShapeLabels"].Body)
+	var labels []byte
+	for _, b := range label_bytes {
+		value, _ := strconv.Atoi(b)
+		labels = append(labels, byte(value))
+	}
+	if string(labels) != "left\x00middle\x00right\x00" {
+		t.Errorf("Expected null terminated labels, got %q", labels)
+	}
+	if ids := vtpInt32s(t, arrays["ShapeIds"]); len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Errorf("Expected shape ids 1 to 3, got %v", ids)
+	}
+
+	front := vtpInt32s(t, arrays["FrontShapeId"])
+	back := vtpInt32s(t, arrays["BackShapeId"])
+	offsets := vtpInt32s(t, arrays["offsets"])
+	if len(front) != 32 || len(back) != 32 || len(offsets) != 32 || offsets[31] != 96 {
+		t.Fatalf("Expected 32 triangles, got %d, %d and %d", len(front), len(back), len(offsets))
+	}
+	i := 0
+	for _, mesh := range boxRowFaceShapes {
+		for f := 0; f < mesh.Faces; f, i = f+1, i+1 {
+			if front[i] != mesh.Front || back[i] != mesh.Back {
+				t.Fatalf("Expected face %d between shapes %d and %d, got %d and %d",
+					i, mesh.Front, mesh.Back, front[i], back[i])
+			}
+		}
+	}
+	connectivity := vtpInt32s(t, arrays["connectivity"])
+	for _, index := range connectivity {
+		if index < 0 || index >= 16 {
+			t.Fatalf("Expected polygons to index the 16 corners of the boxes, got %d", index)
+		}
+	}
+	if len(vtpInt32s(t, arrays["BorderId"])) != 16 {
+		t.Errorf("Expected a BorderId for each point")
+	}
+}
@@ -0,0 +1,137 @@
+package shapeset
+
+import (
+	"fmt"
+	"github.com/nat-n/geom"
+	"sort"
+	"testing"
+)
+
+// The sides of a unit box, each a quad of corners wound anticlockwise when seen
+// from outside the box, in the order -x, +x, -y, +y, -z, +z.
+var boxSides = [6][4][3]float64{
+	{{0, 0, 0}, {0, 0, 1}, {0, 1, 1}, {0, 1, 0}},
+	{{1, 0, 0}, {1, 1, 0}, {1, 1, 1}, {1, 0, 1}},
+	{{0, 0, 0}, {1, 0, 0}, {1, 0, 1}, {0, 0, 1}},
+	{{0, 1, 0}, {0, 1, 1}, {1, 1, 1}, {1, 1, 0}},
+	{{0, 0, 0}, {0, 1, 0}, {1, 1, 0}, {1, 0, 0}},
+	{{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1}},
+}
+
+/* Three unit boxes in a row along x, the shapes 1, 2 and 3 from left to right,
+ * with their meshes as faces indexing shared positions. Each mesh is made up of
+ * the sides of the box of its higher shape which face its lower shape, so that
+ * faces point towards the lower shape. Borders run around the two squares
+ * where the boxes meet.
+ */
+func boxRow() (positions []geom.Vec3, mesh_faces map[MeshId][][3]int) {
+	mesh_sides := map[MeshId][]int{
+		{0, 1}: {0, 2, 3, 4, 5},
+		{0, 2}: {2, 3, 4, 5},
+		{0, 3}: {1, 2, 3, 4, 5},
+		{1, 2}: {0},
+		{2, 3}: {0},
+	}
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(mesh_sides))
+	for mesh_id, _ := range mesh_sides {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+
+	position_indices := make(map[geom.Vec3]int)
+	mesh_faces = make(map[MeshId][][3]int)
+	for _, mesh_id := range mesh_ids {
+		offset := float64(mesh_id[1] - 1)
+		for _, side := range mesh_sides[mesh_id] {
+			var quad [4]int
+			for i, corner := range boxSides[side] {
+				p := geom.Vec3{corner[0] + offset, corner[1], corner[2]}
+				index, exists := position_indices[p]
+				if !exists {
+					index = len(positions)
+					positions = append(positions, p)
+					position_indices[p] = index
+				}
+				quad[i] = index
+			}
+			mesh_faces[mesh_id] = append(mesh_faces[mesh_id],
+				[3]int{quad[0], quad[1], quad[2]},
+				[3]int{quad[0], quad[2], quad[3]})
+		}
+	}
+	return
+}
+
+// Copies the faces of the meshes, inverting the given faces of the given mesh
+func flipFaces(mesh_faces map[MeshId][][3]int, mesh_id MeshId, faces ...int) map[MeshId][][3]int {
+	flipped := make(map[MeshId][][3]int)
+	for id, mesh := range mesh_faces {
+		flipped[id] = append([][3]int{}, mesh...)
+	}
+	for _, fi := range faces {
+		face := flipped[mesh_id][fi]
+		flipped[mesh_id][fi] = [3]int{face[1], face[0], face[2]}
+	}
+	return flipped
+}
+
+// Gives a mesh its own vertices from the shared positions indexed by its faces,
+// in order of first use, returning the flat buffers of vertex positions and
+// face vertex indices, and the local index of each shared position.
+func localBuffers(positions []geom.Vec3, faces [][3]int) (verts []float64, indices []int, local_indices map[int]int) {
+	local_indices = make(map[int]int)
+	indices = make([]int, 0, len(faces)*3)
+	for _, face := range faces {
+		for _, pi := range face {
+			index, exists := local_indices[pi]
+			if !exists {
+				index = len(local_indices)
+				local_indices[pi] = index
+				verts = append(verts, positions[pi].X, positions[pi].Y, positions[pi].Z)
+			}
+			indices = append(indices, index)
+		}
+	}
+	return
+}
+
+// Builds a shapeset with a mesh for each list of faces indexing the shared
+// positions.
+func shapeSetFromBuffers(t *testing.T, positions []geom.Vec3, mesh_faces map[MeshId][][3]int) *ShapeSet {
+	meshes := make([]*Mesh, 0, len(mesh_faces))
+	for mesh_id, faces := range mesh_faces {
+		verts, indices, _ := localBuffers(positions, faces)
+		m, _, err := buildMesh(mesh_id.ToString(), verts, nil, indices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meshes = append(meshes, m)
+	}
+	return New("boxes", boxRowLabels, meshes)
+}
+
+var boxRowLabels = map[string]string{"1": "left", "2": "middle", "3": "right"}
+
+// The shapeset of boxRow with its borders indexed
+func boxRowShapeSet(t *testing.T) *ShapeSet {
+	positions, mesh_faces := boxRow()
+	ss := shapeSetFromBuffers(t, positions, mesh_faces)
+	if err := ss.IndexBorders(); err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+// Describes each border of the shapeset by its meshes and vertex positions
+func borderSummaries(ss *ShapeSet) (summaries map[BorderId]string) {
+	summaries = make(map[BorderId]string)
+	ss.BordersIndex.Each(func(b *Border) {
+		desc := b.Description()
+		summary := desc.ToString()
+		for _, v := range b.Vertices {
+			summary += fmt.Sprintf(" (%g,%g,%g)", v.X, v.Y, v.Z)
+		}
+		summaries[b.Id] = summary
+	})
+	return
+}
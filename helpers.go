@@ -2,38 +2,60 @@ package shapeset
 
 import (
 	"errors"
+	"github.com/nat-n/geom"
+	"math"
 	"strconv"
 	"strings"
 )
 
-// Parses a string of comma seperated floats to produce a slice of floats
+// Parses a string of comma seperated floats to produce a slice of floats,
+// scanning the string in place rather than splitting it
 func parseCSFloats(csfloats string) (floats []float64, err error) {
-	string_segments := strings.Split(csfloats, ",")
-	floats = make([]float64, 0, len(string_segments))
+	floats = make([]float64, 0, strings.Count(csfloats, ",")+1)
 	var num float64
-	for _, seg := range string_segments {
+	err = eachCSSegment(csfloats, func(seg string) (err error) {
 		num, err = strconv.ParseFloat(seg, 64)
 		if err != nil {
 			err = errors.New("Could not parse float64 from: " + seg)
 			return
 		}
 		floats = append(floats, num)
-	}
+		return
+	})
 	return
 }
 
-// Parses a string of comma seperated ints to produce a slice of ints
+// Parses a string of comma seperated ints to produce a slice of ints,
+// scanning the string in place rather than splitting it
 func parseCSInts(csints string) (ints []int, err error) {
-	string_segments := strings.Split(csints, ",")
-	ints = make([]int, 0, len(string_segments))
+	ints = make([]int, 0, strings.Count(csints, ",")+1)
 	var num int
-	for _, seg := range string_segments {
+	err = eachCSSegment(csints, func(seg string) (err error) {
 		num, err = strconv.Atoi(seg)
 		if err != nil {
 			err = errors.New("Could not parse int from: " + seg)
 			return
 		}
 		ints = append(ints, num)
+		return
+	})
+	return
+}
+
+// Calls cb with each comma seperated segment of s, an empty string has no
+// segments
+func eachCSSegment(s string, cb func(string) error) (err error) {
+	if len(s) == 0 {
+		return
+	}
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == ',' {
+			if err = cb(s[start:i]); err != nil {
+				return
+			}
+			start = i + 1
+		}
 	}
 	return
 }
@@ -57,3 +79,24 @@ func intInSlice(s int, ints []int) bool {
 	}
 	return false
 }
+
+// Calculates the unnormalized normal of the triangle abc, the length of which
+// is twice its area
+func triangleNormal(a, b, c geom.Vec3) geom.Vec3 {
+	u := geom.Vec3{b.X - a.X, b.Y - a.Y, b.Z - a.Z}
+	v := geom.Vec3{c.X - a.X, c.Y - a.Y, c.Z - a.Z}
+	return geom.Vec3{
+		u.Y*v.Z - u.Z*v.Y,
+		u.Z*v.X - u.X*v.Z,
+		u.X*v.Y - u.Y*v.X,
+	}
+}
+
+// Scales v to unit length, leaving zero length vectors unchanged
+func normalize(v geom.Vec3) geom.Vec3 {
+	length := math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+	if length == 0 {
+		return v
+	}
+	return geom.Vec3{v.X / length, v.Y / length, v.Z / length}
+}
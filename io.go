@@ -7,156 +7,238 @@ import (
 	"github.com/nat-n/geom"
 	gomesh "github.com/nat-n/gomesh/mesh"
 	"io"
-	"io/ioutil"
 	"os"
-	"regexp"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-type meshParseSchema struct {
-	Name    string            `json:"name"`
-	Verts   string            `json:"verts"`
-	Norms   string            `json:"norms"`
-	Faces   string            `json:"faces"`
-	Borders map[string]string `json:"borders"`
-}
-
-type shapeSetParseSchema struct {
-	Name   string             `json:"name"`
-	Shapes map[string]string  `json:"shapes"`
-	Meshes []*meshParseSchema `json:"meshes"`
-}
-
 func Load(ss_reader *io.Reader) (ss *ShapeSet, err error) {
-	// Parse json from reader and load with temporary types
-	parsed_data := new(shapeSetParseSchema)
-	err = json.NewDecoder(*ss_reader).Decode(parsed_data)
-	if err != nil {
-		err = errors.New("Could not parse json from ss_reader")
-		return
-	}
+	sr := newJSONStreamReader(*ss_reader)
 
 	// for building up partial border info from meshes
-	border_tracker := make(map[BorderId]map[MeshId][]*Vertex)
+	border_tracker := make(borderTracker)
 
-	meshesBuffer := make([]*Mesh, 0)
-	for _, mesh_data := range parsed_data.Meshes {
-		var verts, norms []float64
-		var faces []int
-		mesh_name := mesh_data.Name
-		m := NewMesh(mesh_name)
-
-		// load vertices from parsed data
-		verts, err = parseCSFloats(mesh_data.Verts)
-		vertexBuffer := make([]gomesh.VertexI, 0, len(verts)/3)
-		if err != nil {
-			err = errors.New("Could not parse vertices for: " + mesh_name)
-			return
-		}
-		for i := 0; i < len(verts); i += 3 {
-			vertexBuffer = append(vertexBuffer, &Vertex{Vertex: gomesh.Vertex{
-				Vec3:   geom.Vec3{verts[i], verts[i+1], verts[i+2]},
-				Meshes: make(map[gomesh.Mesh]int),
-			}})
-		}
+	// errors with a more specific explanation than that the json is malformed
+	var detail_err error
 
-		// Vertex normals are optional
-		if len(mesh_data.Norms) > 0 {
-			norms, err = parseCSFloats(mesh_data.Norms)
-			if err != nil {
-				err = errors.New("Could not parse normals for: " + mesh_name)
-				return
+	// the version must be known before any meshes are read, so that they can be
+	// migrated as they are streamed
+	version := 0
+	meshes_read := false
+	header := make(map[string]json.RawMessage)
+	meshesBuffer := make([]*Mesh, 0)
+	err = sr.eachKey(func(key string) (err error) {
+		switch key {
+		case "version":
+			if meshes_read {
+				detail_err = errors.New("Shapeset json version must precede its meshes")
+				return detail_err
 			}
-			if len(norms) != 0 && len(norms) != len(verts) {
-				err = errors.New("Malformed Mesh (vertices/normals mismatch): " + mesh_name)
+			if err = sr.dec.Decode(&version); err != nil {
 				return
 			}
-			for i := 0; i < len(norms); i += 3 {
-				vertexBuffer[i/3].SetNormal(&geom.Vec3{norms[i], norms[i+1], norms[i+2]})
-			}
+			detail_err = checkJSONSchemaVersion(version)
+			err = detail_err
+		case "meshes":
+			meshes_read = true
+			err = sr.eachElement(func() error {
+				var m *Mesh
+				m, detail_err = sr.readMigratedMesh(border_tracker, version)
+				if detail_err == nil {
+					meshesBuffer = append(meshesBuffer, m)
+				}
+				return detail_err
+			})
+		default:
+			var raw json.RawMessage
+			err = sr.dec.Decode(&raw)
+			header[key] = raw
 		}
+		return
+	})
+	if err != nil {
+		if detail_err == nil {
+			err = errors.New("Could not parse json from ss_reader: " + err.Error())
+		}
+		return
+	}
+
+	// upgrade and interpret the remaining top level values
+	err = migrateJSONHeader(header, version)
+	if err != nil {
+		return
+	}
+	var name string
+	labels := make(map[string]string)
+	if raw_name, exists := header["name"]; exists {
+		err = json.Unmarshal(raw_name, &name)
+	}
+	if raw_shapes, exists := header["shapes"]; exists && err == nil {
+		err = json.Unmarshal(raw_shapes, &labels)
+	}
+	metadata := make(map[ShapeId]*ShapeMetadata)
+	if raw_metadata, exists := header["metadata"]; exists && err == nil {
+		err = json.Unmarshal(raw_metadata, &metadata)
+	}
+	if err != nil {
+		err = errors.New("Could not parse json from ss_reader: " + err.Error())
+		return
+	}
+	// metadata may be given as null, as may the entries of individual shapes
+	if metadata == nil {
+		metadata = make(map[ShapeId]*ShapeMetadata)
+	}
+	for shape_id, shape_metadata := range metadata {
+		if shape_metadata == nil {
+			delete(metadata, shape_id)
+		}
+	}
+
+	// Create ShapeSet
+	ss = New(name, labels, meshesBuffer)
+	ss.Metadata = metadata
 
-		edges := make(map[gomesh.VertexPair]*Edge)
+	// merge border vertices
+	err = border_tracker.mergeInto(ss)
 
-		// load faces from parsed data
-		faces, err = parseCSInts(mesh_data.Faces)
-		faceBuffer := make([]gomesh.FaceI, 0, len(faces)/3)
-		if err != nil {
-			err = errors.New("Could not parse faces for: " + mesh_name)
+	return
+}
+
+// Builds a new Mesh from flat buffers of vertex positions, optional vertex
+// normals and face vertex indices, linking up faces, vertices and edges.
+func buildMesh(
+	mesh_name string,
+	verts, norms []float64,
+	faces []int,
+) (m *Mesh, vertexBuffer []gomesh.VertexI, err error) {
+	m = NewMesh(mesh_name)
+
+	if len(verts)%3 != 0 {
+		err = errors.New("Malformed Mesh (vertex count): " + mesh_name)
+		return
+	}
+	vertexBuffer = make([]gomesh.VertexI, 0, len(verts)/3)
+	for i := 0; i < len(verts); i += 3 {
+		vertexBuffer = append(vertexBuffer, &Vertex{Vertex: gomesh.Vertex{
+			Vec3:   geom.Vec3{verts[i], verts[i+1], verts[i+2]},
+			Meshes: make(map[gomesh.Mesh]int),
+		}})
+	}
+
+	if len(norms) != 0 && len(norms) != len(verts) {
+		err = errors.New("Malformed Mesh (vertices/normals mismatch): " + mesh_name)
+		return
+	}
+	for i := 0; i < len(norms); i += 3 {
+		vertexBuffer[i/3].SetNormal(&geom.Vec3{norms[i], norms[i+1], norms[i+2]})
+	}
+
+	if len(faces)%3 != 0 {
+		err = errors.New("Malformed Mesh (face index count): " + mesh_name)
+		return
+	}
+	for _, vi := range faces {
+		if vi < 0 || vi >= len(vertexBuffer) {
+			err = errors.New("Malformed Mesh (face index out of range): " + mesh_name)
 			return
 		}
-		for i := 0; i < len(faces); i += 3 {
-			// create new face from vertex triple
-			vA := vertexBuffer[faces[i]]
-			vB := vertexBuffer[faces[i+1]]
-			vC := vertexBuffer[faces[i+2]]
-			new_face := &Face{Face: gomesh.Face{Vertices: [3]gomesh.VertexI{vA, vB, vC}}}
-
-			// reference face from vertices
-			vA.AddFace(new_face)
-			vB.AddFace(new_face)
-			vC.AddFace(new_face)
-			faceBuffer = append(faceBuffer, new_face)
-
-			// find/create edges of new_face
-			e0 := gomesh.MakeVertexPair(vA, vB)
-			e1 := gomesh.MakeVertexPair(vB, vC)
-			e2 := gomesh.MakeVertexPair(vC, vA)
-			newPairs := [3]gomesh.VertexPair{e0, e1, e2}
-			for i, vp := range newPairs {
-				var f_edge *Edge
-				if e, exists := edges[vp]; exists {
-					f_edge = e
-				} else {
-					f_edge = &Edge{VertexPair: vp}
-					f_edge.Vertex1().AddEdge(f_edge)
-					f_edge.Vertex2().AddEdge(f_edge)
-					edges[vp] = f_edge
-				}
-				f_edge.AddFace(new_face)
-				new_face.Edges[i] = f_edge
+	}
+
+	edges := make(map[gomesh.VertexPair]*Edge)
+	faceBuffer := make([]gomesh.FaceI, 0, len(faces)/3)
+	for i := 0; i < len(faces); i += 3 {
+		// create new face from vertex triple
+		vA := vertexBuffer[faces[i]]
+		vB := vertexBuffer[faces[i+1]]
+		vC := vertexBuffer[faces[i+2]]
+		new_face := &Face{Face: gomesh.Face{Vertices: [3]gomesh.VertexI{vA, vB, vC}}}
+
+		// reference face from vertices
+		vA.AddFace(new_face)
+		vB.AddFace(new_face)
+		vC.AddFace(new_face)
+		faceBuffer = append(faceBuffer, new_face)
+
+		// find/create edges of new_face
+		e0 := gomesh.MakeVertexPair(vA, vB)
+		e1 := gomesh.MakeVertexPair(vB, vC)
+		e2 := gomesh.MakeVertexPair(vC, vA)
+		newPairs := [3]gomesh.VertexPair{e0, e1, e2}
+		for i, vp := range newPairs {
+			var f_edge *Edge
+			if e, exists := edges[vp]; exists {
+				f_edge = e
+			} else {
+				f_edge = &Edge{VertexPair: vp}
+				f_edge.Vertex1().AddEdge(f_edge)
+				f_edge.Vertex2().AddEdge(f_edge)
+				edges[vp] = f_edge
 			}
+			f_edge.AddFace(new_face)
+			new_face.Edges[i] = f_edge
 		}
+	}
 
-		for border_id, border_indices := range mesh_data.Borders {
-			var vert_indices []int
-			vert_indices, err = parseCSInts(border_indices)
-			if err != nil {
-				err = errors.New(
-					"Could not parse border for mesh " + m.GetName() +
-						" indices for border " + border_id + ", in mesh " + mesh_name)
-				return
-			}
+	m.Vertices.Append(vertexBuffer...)
+	m.Faces.Append(faceBuffer...)
+	m.ReindexVerticesAndFaces()
+	m.BoundingBox = m.Mesh.BoundingBox()
+	return
+}
 
-			bverts := make([]*Vertex, 0)
-			for _, vi := range vert_indices {
-				bverts = append(bverts, vertexBuffer[vi].(*Vertex))
-			}
-			bid, err := BorderIdFromString(border_id)
-			if err != nil {
-				panic(err.Error())
-			}
-			mid := MeshIdFromString(mesh_name)
-			if _, exists := border_tracker[bid]; !exists {
-				border_tracker[bid] = make(map[MeshId][]*Vertex)
-			}
-			border_tracker[bid][mid] = bverts
+// Collects the vertices of each border as listed by each participating mesh
+// while loading, so they can be merged once all meshes are loaded.
+type borderTracker map[BorderId]map[MeshId][]*Vertex
+
+func (bt borderTracker) track(
+	border_id BorderId,
+	mesh_name string,
+	vert_indices []int,
+	vertexBuffer []gomesh.VertexI,
+) (err error) {
+	bverts := make([]*Vertex, 0, len(vert_indices))
+	for _, vi := range vert_indices {
+		if vi < 0 || vi >= len(vertexBuffer) {
+			err = errors.New("Border " + border_id.ToString() +
+				" references missing vertex in mesh " + mesh_name)
+			return
 		}
-
-		m.Vertices.Append(vertexBuffer...)
-		m.Faces.Append(faceBuffer...)
-		m.ReindexVerticesAndFaces()
-		m.BoundingBox = m.Mesh.BoundingBox()
-		meshesBuffer = append(meshesBuffer, m)
+		bverts = append(bverts, vertexBuffer[vi].(*Vertex))
+	}
+	mid := MeshIdFromString(mesh_name)
+	if _, exists := bt[border_id]; !exists {
+		bt[border_id] = make(map[MeshId][]*Vertex)
 	}
+	bt[border_id][mid] = bverts
+	return
+}
 
-	// Create ShapeSet
-	ss = New(parsed_data.Name, parsed_data.Shapes, meshesBuffer)
+// Merges the tracked border vertices of every mesh into those of the first
+// mesh of each border, then registers the borders with the ShapeSet.
+func (bt borderTracker) mergeInto(ss *ShapeSet) (err error) {
+	return bt.mergeIntoWithMeshIds(ss, nil)
+}
 
-	// merge border vertices
-	for border_id, mesh_borders := range border_tracker {
+/* As mergeInto, but with the meshes of each border given by border_mesh_ids
+ * where known, as when only some of the meshes of a file have been read and
+ * each border should still be described by all of the meshes it joins.
+ */
+func (bt borderTracker) mergeIntoWithMeshIds(
+	ss *ShapeSet,
+	border_mesh_ids map[BorderId][]MeshId,
+) (err error) {
+	// load borders in id order so that the result doesn't depend on map order
+	border_ids := make([]int, 0, len(bt))
+	for border_id, _ := range bt {
+		border_ids = append(border_ids, int(border_id))
+	}
+	sort.Ints(border_ids)
+
+	for _, bid := range border_ids {
+		border_id := BorderId(bid)
+		mesh_borders := bt[border_id]
 		mesh_ids := ByMeshIdPrecedence{}
 		for mesh_id, _ := range mesh_borders {
 			mesh_ids = append(mesh_ids, mesh_id)
@@ -166,6 +248,11 @@ func Load(ss_reader *io.Reader) (ss *ShapeSet, err error) {
 		first_mesh_vertices := mesh_borders[mesh_ids[0]]
 		for _, mesh_id := range mesh_ids[1:] {
 			secondary_mesh_vertices := mesh_borders[mesh_id]
+			if len(secondary_mesh_vertices) != len(first_mesh_vertices) {
+				err = errors.New("Border " + border_id.ToString() +
+					" has inconsistent length in mesh " + mesh_id.ToString())
+				return
+			}
 			for i := 0; i < len(first_mesh_vertices); i++ {
 				gomesh.MergeSharedVertices(
 					first_mesh_vertices[i],
@@ -173,6 +260,13 @@ func Load(ss_reader *io.Reader) (ss *ShapeSet, err error) {
 			}
 		}
 
+		// include every mesh of the border, whether or not it was read
+		for _, mesh_id := range border_mesh_ids[border_id] {
+			if _, tracked := mesh_borders[mesh_id]; !tracked {
+				mesh_ids = append(mesh_ids, mesh_id)
+			}
+		}
+
 		_, err = ss.BordersIndex.LoadBorder(
 			border_id,
 			[]MeshId(mesh_ids),
@@ -184,44 +278,55 @@ func Load(ss_reader *io.Reader) (ss *ShapeSet, err error) {
 	}
 
 	ss.BordersIndex.indexBorderEdges()
-
 	return
 }
 
 func (ss *ShapeSet) Save(ss_writer *io.Writer) (err error) {
-	// structure data for serialization
-	parsed_data := shapeSetParseSchema{
-		Name:   ss.Name,
-		Shapes: make(map[string]string),
-		Meshes: make([]*meshParseSchema, 0, len(ss.Meshes)),
-	}
-	for shape_id, shape_name := range ss.Shapes {
-		parsed_data.Shapes[shape_id.ToString()] = shape_name
-	}
-
-	for mesh_name, m := range ss.Meshes {
-		m.ReindexVerticesAndFaces()
-		m.Vertices.EnsureNormals()
-		temp_mesh_data := meshParseSchema{
-			Name:    mesh_name.ToString(),
-			Verts:   m.Vertices.PositionsAsCSV(),
-			Norms:   m.Vertices.NormalsAsCSV(),
-			Faces:   m.Faces.IndicesAsCSV(),
-			Borders: make(map[string]string),
+	// stream out the shapeset one mesh at a time
+	sw := newJSONStreamWriter(*ss_writer)
+	sw.raw(`{"version":`)
+	sw.int(JSONSchemaVersion)
+	sw.raw(`,"name":`)
+	sw.string(ss.Name)
+
+	sw.raw(`,"shapes":{`)
+	shape_ids := make([]int, 0, len(ss.Shapes))
+	for shape_id, _ := range ss.Shapes {
+		shape_ids = append(shape_ids, int(shape_id))
+	}
+	sort.Ints(shape_ids)
+	for i, sid := range shape_ids {
+		shape_id := ShapeId(sid)
+		if i > 0 {
+			sw.raw(",")
 		}
-		for border_id, border := range m.Borders {
-			// stringify border vertex indices
-			stringInts := make([]string, border.Len(), border.Len())
-			for i := 0; i < border.Len(); i++ {
-				bvi := border.Vertices[i].GetLocationInMesh(m)
-				stringInts[i] = strconv.FormatInt(int64(bvi), 10)
-			}
-			temp_mesh_data.Borders[border_id.ToString()] = strings.Join(stringInts, ",")
+		sw.string(shape_id.ToString())
+		sw.raw(":")
+		sw.string(ss.Shapes[shape_id])
+	}
+
+	sw.raw(`},"metadata":`)
+	if sw.err == nil {
+		var encoded_metadata []byte
+		encoded_metadata, sw.err = json.Marshal(ss.Metadata)
+		sw.raw(string(encoded_metadata))
+	}
+
+	sw.raw(`,"meshes":[`)
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(ss.Meshes))
+	for mesh_id, _ := range ss.Meshes {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+	for i, mesh_id := range mesh_ids {
+		if i > 0 {
+			sw.raw(",")
 		}
-		parsed_data.Meshes = append(parsed_data.Meshes, &temp_mesh_data)
+		sw.writeMesh(ss.Meshes[mesh_id])
 	}
+	sw.raw("]}\n")
 
-	err = json.NewEncoder(*ss_writer).Encode(&parsed_data)
+	err = sw.flush()
 	if err != nil {
 		err = errors.New("Could not encode json for: " + ss.Name)
 		return
@@ -238,144 +343,125 @@ func ReadFile(ss_file_path string) (ss *ShapeSet, err error) {
 	}
 	defer input_file.Close()
 
-	// read from file
-	ss_reader := io.Reader(input_file)
-	ss, err = Load(&ss_reader)
+	// read from file, detecting the binary container format by its magic bytes
+	buffered_input := bufio.NewReader(input_file)
+	ss_reader := io.Reader(buffered_input)
+	if isBinaryShapeSet(buffered_input) {
+		// the size of the file bounds the counts read from it
+		var stat os.FileInfo
+		stat, err = input_file.Stat()
+		if err != nil {
+			return
+		}
+		ss, err = loadBinary(newSizedBinaryReader(buffered_input, stat.Size()))
+	} else {
+		ss, err = Load(&ss_reader)
+	}
 
 	return
 }
 
 func (ss *ShapeSet) WriteFile(ss_file_path string) (err error) {
-	// Serialized JSON and stream to a file
+	// Serialize to a file, as the binary container format if the file extension
+	// calls for it or otherwise as JSON
 	output_file, err := os.Create(ss_file_path)
 	if err != nil {
 		return
 	}
 	defer output_file.Close()
-	ss_writer := io.Writer(output_file)
-	ss.Save(&ss_writer)
+	buffered_output := bufio.NewWriter(output_file)
+	ss_writer := io.Writer(buffered_output)
+	if strings.HasSuffix(strings.ToLower(ss_file_path), BinaryFileExtension) {
+		err = ss.SaveBinary(&ss_writer, false)
+	} else {
+		err = ss.Save(&ss_writer)
+	}
+	if err != nil {
+		return
+	}
+	err = buffered_output.Flush()
 
 	return
 }
 
-/* Create a new shapeset from a json labels file and a directory of meshes.
- */
-func CreateNew(meshes_dir, labels_path string) (ss *ShapeSet, err error) {
-	// Ensure meshes_dir ends with a slash
-	if meshes_dir[len(meshes_dir)-1] != 47 {
-		meshes_dir += "/"
-	}
-
-	// Attempt to read given paths
-	var label_data []byte
-	label_data, err = ioutil.ReadFile(labels_path)
+// Serializes the shapeset to a file in the binary container format, with
+// vertex positions quantised to the given number of bits per axis.
+func (ss *ShapeSet) WriteQuantisedFile(ss_file_path string, bits uint8) (err error) {
+	output_file, err := os.Create(ss_file_path)
 	if err != nil {
 		return
 	}
-	var files []os.FileInfo
-	files, err = ioutil.ReadDir(meshes_dir)
+	defer output_file.Close()
+	buffered_output := bufio.NewWriter(output_file)
+	ss_writer := io.Writer(buffered_output)
+	err = ss.SaveBinaryQuantised(&ss_writer, bits)
 	if err != nil {
 		return
 	}
+	err = buffered_output.Flush()
 
-	// Load labels from json file
-	labels := make(map[string]string, 0)
-	var labels_temp interface{}
-	json.Unmarshal(label_data, &labels_temp)
-	for key, val := range labels_temp.(map[string]interface{}) {
-		labels[key] = val.(string)
-	}
-
-	// Load all meshes with the expected filename pattern (.obj) from the
-	// directory at meshes path.
-	meshes := make([]*Mesh, 0)
-	for _, f := range files {
-		r, _ := regexp.Compile(`^(\d+-\d+).obj$`)
-		if r.MatchString(f.Name()) == true {
-			mesh_file_path := meshes_dir + f.Name()
-			var m *gomesh.Mesh
-			m, err = gomesh.ReadOBJFile(mesh_file_path)
-			if err != nil {
-				return
-			}
-			m.Name = string(r.FindSubmatch([]byte(f.Name()))[1])
-			meshes = append(meshes, WrapMesh(m))
-		}
-	}
+	return
+}
 
-	// Compose shapeset
-	ss = New("New ShapeSet", labels, meshes)
+/* Create a new shapeset from a label table file and a directory of meshes
+ * named by MeshId, e.g. 3-17.obj
+ */
+func CreateNew(meshes_dir, labels_path string) (ss *ShapeSet, err error) {
+	// Meshes are named by MeshId, and other files are ignored
+	ss, _, err = CreateNewWithNaming(meshes_dir, labels_path, DefaultMeshNaming)
 	return
 }
 
 /* Loads a directory of meshes and replaces each location of a vertex currently
  * in the shapeset with the location of the corresponding vertex (by index) in
  * the identically named mesh file. The set of meshes and their topology is
- * assumed to be the same as is present in the shapeset.
+ * assumed to be the same as is present in the shapeset, and the shapeset is
+ * left unchanged if any mesh file is missing, has a different number of
+ * vertices, or is in a format which doesn't preserve vertex order.
  */
-func (ss *ShapeSet) ReloadVertices(meshes_dir string) {
+func (ss *ShapeSet) ReloadVertices(meshes_dir string) (err error) {
 	// ensure meshes_dir ends with a slash
-	if meshes_dir[len(meshes_dir)-1] != 47 {
+	if !strings.HasSuffix(meshes_dir, "/") {
 		meshes_dir += "/"
 	}
 
 	// ensure meshes_dir is a directory
 	path_stat, err := os.Stat(meshes_dir)
-	if os.IsNotExist(err) || !path_stat.Mode().IsDir() {
-		panic(errors.New("Provided path for reloading meshes is not a directory"))
+	if err != nil || !path_stat.Mode().IsDir() {
+		err = errors.New("Provided path for reloading meshes is not a directory")
+		return
 	}
 
-	// collect border vertex positions to be averaged at the end
-	borders_vertices := make(map[*Vertex][]geom.Vec3I)
-
-	// reload vertices for all meshes in the shapeset
+	// read the positions for all meshes before changing any of them
+	mesh_positions := make(map[*Mesh][]geom.Vec3)
 	for _, m := range ss.Meshes {
-		mesh_file_path := meshes_dir + m.Name + ".obj"
-		if _, err = os.Stat(mesh_file_path); os.IsNotExist(err) {
+		mesh_file_path := findMeshFile(meshes_dir, m.Name)
+		if mesh_file_path == "" {
+			err = errors.New("Could not find a mesh file to reload mesh: " + m.Name)
 			return
 		}
 
-		// scan mesh file line by line to find vertex definitions
-		var file *os.File
-		file, err = os.Open(mesh_file_path)
+		var positions []geom.Vec3
+		positions, err = readMeshPositions(mesh_file_path)
 		if err != nil {
 			return
 		}
+		if len(positions) != m.Vertices.Len() {
+			err = errors.New("Mesh file " + mesh_file_path + " has " +
+				strconv.Itoa(len(positions)) + " vertices, but mesh " + m.Name +
+				" has " + strconv.Itoa(m.Vertices.Len()))
+			return
+		}
+		mesh_positions[m] = positions
+	}
 
-		// setup for parsing
-		index := 0
-		line_no := -1
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line_no++
-			// trim leading and trailing whitespace
-			line := strings.TrimSpace(scanner.Text())
-			// discard anything on this line after a #
-			if comment_start := strings.Index(line, "#"); comment_start >= 0 {
-				line = line[:comment_start]
-			}
-			// ignore empty lines
-			if len(line) == 0 {
-				continue
-			}
-
-			var new_vec geom.Vec3
-			words := strings.Fields(line)
-			if words[0] == "v" {
-				new_x, err_x := strconv.ParseFloat(words[1], 64)
-				new_y, err_y := strconv.ParseFloat(words[2], 64)
-				new_z, err_z := strconv.ParseFloat(words[3], 64)
-				if err_x != nil || err_y != nil || err_z != nil {
-					panic(errors.New(
-						"Error parsing OBJ file on line: " +
-							strconv.Itoa(line_no)))
-				}
-				new_vec = geom.Vec3{new_x, new_y, new_z}
-			} else {
-				// ignore lines that don't define a vertex
-				continue
-			}
+	// collect border vertex positions to be averaged at the end
+	borders_vertices := make(map[*Vertex][]geom.Vec3I)
 
+	// reload vertices for all meshes in the shapeset
+	for m, positions := range mesh_positions {
+		for index, new_vec := range positions {
+			new_vec := new_vec
 			v := m.Vertices.Get(index)[0]
 			vert := v.(*Vertex)
 			if vert.IsShared() {
@@ -387,15 +473,7 @@ func (ss *ShapeSet) ReloadVertices(meshes_dir string) {
 				vert.SetY(new_vec.Y)
 				vert.SetZ(new_vec.Z)
 			}
-			// only incremented after parsing lines with a vertex
-			index++
 		}
-
-		if err = scanner.Err(); err != nil {
-			return
-		}
-
-		file.Close()
 	}
 
 	// Calculate border vertex positions as mean across meshes
@@ -409,3 +487,85 @@ func (ss *ShapeSet) ReloadVertices(meshes_dir string) {
 	})
 	return
 }
+
+// Finds the file for the named mesh in meshes_dir with the extension of any
+// readable mesh format, returns an empty string if there is none.
+func findMeshFile(meshes_dir, mesh_name string) string {
+	for _, ext := range meshFileExtensions() {
+		mesh_file_path := meshes_dir + mesh_name + ext
+		if _, err := os.Stat(mesh_file_path); err == nil {
+			return mesh_file_path
+		}
+	}
+	return ""
+}
+
+// Reads just the vertex positions, in order, from a mesh file
+func readMeshPositions(mesh_file_path string) (positions []geom.Vec3, err error) {
+	format, err := MeshFormatFor(mesh_file_path)
+	if err != nil {
+		return
+	}
+	if format.ReordersVertices {
+		err = errors.New("Vertex positions can't be reloaded from " + format.Name +
+			" files, which don't preserve the order of vertices: " + mesh_file_path)
+		return
+	}
+	if strings.ToLower(filepath.Ext(mesh_file_path)) == ".obj" {
+		return readOBJPositions(mesh_file_path)
+	}
+	var m *gomesh.Mesh
+	m, err = ReadMeshFile(mesh_file_path)
+	if err != nil {
+		return
+	}
+	positions, _ = meshToBuffers(m)
+	return
+}
+
+// Scans an OBJ file line by line to find vertex definitions
+func readOBJPositions(mesh_file_path string) (positions []geom.Vec3, err error) {
+	file, err := os.Open(mesh_file_path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// setup for parsing
+	line_no := -1
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line_no++
+		// trim leading and trailing whitespace
+		line := strings.TrimSpace(scanner.Text())
+		// discard anything on this line after a #
+		if comment_start := strings.Index(line, "#"); comment_start >= 0 {
+			line = line[:comment_start]
+		}
+		// ignore empty lines
+		if len(line) == 0 {
+			continue
+		}
+
+		words := strings.Fields(line)
+		if words[0] != "v" {
+			// ignore lines that don't define a vertex
+			continue
+		}
+		if len(words) < 4 {
+			err = errors.New("Error parsing OBJ file on line: " + strconv.Itoa(line_no))
+			return
+		}
+		new_x, err_x := strconv.ParseFloat(words[1], 64)
+		new_y, err_y := strconv.ParseFloat(words[2], 64)
+		new_z, err_z := strconv.ParseFloat(words[3], 64)
+		if err_x != nil || err_y != nil || err_z != nil {
+			err = errors.New("Error parsing OBJ file on line: " + strconv.Itoa(line_no))
+			return
+		}
+		positions = append(positions, geom.Vec3{new_x, new_y, new_z})
+	}
+
+	err = scanner.Err()
+	return
+}
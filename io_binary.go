@@ -0,0 +1,706 @@
+package shapeset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+/* Binary shapeset container format
+ *
+ * All values are little-endian. Strings are a uint32 byte length followed by
+ * utf-8 bytes.
+ *
+ *   magic          [4]byte  "SSB\x00"
+ *   version        uint16
+ *   flags          uint16   bit 0 set if positions and normals are float32
+ *                           bit 1 set if meshes are quantised, see below
+ *                           bit 2 set if shape metadata follows the shapes
+ *   name           string
+ *   shape count    uint32
+ *     shape id     int32
+ *     shape label  string
+ *   mesh count     uint32
+ *     mesh name    string
+ *     vertex count uint32
+ *     positions    [vertex count * 3]float64 (or float32)
+ *     has normals  uint8
+ *     normals      [vertex count * 3]float64 (or float32) if has normals
+ *     face count   uint32
+ *     indices      [face count * 3]uint32
+ *     border count uint32
+ *       border id  int32
+ *       length     uint32
+ *       indices    [length]uint32
+ *
+ * From version 2 the meshes are followed by a table of contents, so that
+ * individual meshes can be read without reading the whole file, see
+ * LazyShapeSet. It's located by a fixed size footer at the very end of the
+ * file.
+ *
+ *   toc
+ *     mesh count      uint32
+ *       mesh id       [2]int32
+ *       offset        uint64   from the start of the file to the mesh name
+ *       length        uint64   of the mesh in bytes
+ *       bounding box  [6]float64 min x, y, z, then max x, y, z
+ *       border count  uint32
+ *         border id   int32
+ *   footer
+ *     toc offset      uint64   from the start of the file
+ *     toc magic       [4]byte  "SSBT"
+ *
+ * Shape metadata (version 4) follows the shapes when flagged:
+ *
+ *   metadata count uint32
+ *     shape id     int32
+ *     has color    uint8
+ *     color        [4]float64 RGBA if has color
+ *     extra count  uint32
+ *       key        string
+ *       value      string
+ *
+ * Quantised meshes (version 3) have the quantisation grid described after any
+ * shape metadata, and store vertex positions as grid coordinates, normals
+ * octahedrally encoded, and indices as deltas from the preceding index in the
+ * same list:
+ *
+ *   grid bits      uint8
+ *   grid origin    [3]float64
+ *   grid step      [3]float64
+ *   ...
+ *     positions    [vertex count * 3]uvarint
+ *     normals      [vertex count * 2]int16 if has normals
+ *     indices      [face count * 3]varint
+ *       indices    [length]varint
+ */
+
+const BinaryFileExtension = ".ssb"
+const binaryFormatVersion = 4
+const binaryFlagFloat32 = 1
+const binaryFlagQuantised = 2
+const binaryFlagMetadata = 4
+
+var binaryMagic = []byte{'S', 'S', 'B', 0}
+var binaryTOCMagic = []byte{'S', 'S', 'B', 'T'}
+
+const binaryFooterSize = 12
+
+// Reports whether the buffered reader is positioned at the start of a binary
+// shapeset container, without consuming any input.
+func isBinaryShapeSet(r *bufio.Reader) bool {
+	head, err := r.Peek(len(binaryMagic))
+	return err == nil && bytes.Equal(head, binaryMagic)
+}
+
+// How the values of meshes are encoded
+type binaryEncoding struct {
+	useFloat32 bool
+	grid       *quantisationGrid // nil unless quantised
+}
+
+// Wraps an io.Writer so that the first error encountered sticks and further
+// writes are skipped, counting the bytes written.
+type binaryWriter struct {
+	w       io.Writer
+	n       int64
+	scratch [binary.MaxVarintLen64]byte
+	err     error
+}
+
+func (bw *binaryWriter) write(data interface{}) {
+	if bw.err == nil {
+		bw.err = binary.Write(bw.w, binary.LittleEndian, data)
+		bw.n += int64(binary.Size(data))
+	}
+}
+
+func (bw *binaryWriter) writeBytes(data []byte) {
+	if bw.err == nil {
+		var n int
+		n, bw.err = bw.w.Write(data)
+		bw.n += int64(n)
+	}
+}
+
+func (bw *binaryWriter) writeString(s string) {
+	bw.write(uint32(len(s)))
+	bw.writeBytes([]byte(s))
+}
+
+func (bw *binaryWriter) writeUvarint(u uint64) {
+	bw.writeBytes(bw.scratch[:binary.PutUvarint(bw.scratch[:], u)])
+}
+
+func (bw *binaryWriter) writeVarint(i int64) {
+	bw.writeBytes(bw.scratch[:binary.PutVarint(bw.scratch[:], i)])
+}
+
+func (bw *binaryWriter) writeFloats(floats []float64, use_float32 bool) {
+	if !use_float32 {
+		bw.write(floats)
+		return
+	}
+	floats32 := make([]float32, len(floats))
+	for i, f := range floats {
+		floats32[i] = float32(f)
+	}
+	bw.write(floats32)
+}
+
+// Writes a list of indices as uint32s, or as delta encoded varints
+func (bw *binaryWriter) writeIndices(indices []int, delta bool) {
+	if !delta {
+		indices32 := make([]uint32, len(indices))
+		for i, index := range indices {
+			indices32[i] = uint32(index)
+		}
+		bw.write(indices32)
+		return
+	}
+	previous := 0
+	for _, index := range indices {
+		bw.writeVarint(int64(index - previous))
+		previous = index
+	}
+}
+
+// Wraps an io.Reader so that the first error encountered sticks and further
+// reads are skipped. If sized, counts read from the source are checked against
+// the number of bytes remaining in it.
+type binaryReader struct {
+	r         io.Reader
+	err       error
+	sized     bool
+	remaining int64
+}
+
+// The number of elements read at a time when the size of the source isn't
+// known, so that a corrupt count fails at the end of the input rather than
+// allocating memory for every element it claims.
+const binaryReadChunk = 1 << 16
+
+func newSizedBinaryReader(r io.Reader, size int64) *binaryReader {
+	return &binaryReader{r: r, sized: true, remaining: size}
+}
+
+func (br *binaryReader) read(data interface{}) {
+	if br.err == nil {
+		br.err = binary.Read(br.r, binary.LittleEndian, data)
+		br.remaining -= int64(binary.Size(data))
+	}
+}
+
+// Implements io.ByteReader for decoding varints
+func (br *binaryReader) ReadByte() (b byte, err error) {
+	if byte_reader, ok := br.r.(io.ByteReader); ok {
+		b, err = byte_reader.ReadByte()
+	} else {
+		var one [1]byte
+		_, err = io.ReadFull(br.r, one[:])
+		b = one[0]
+	}
+	br.remaining--
+	return
+}
+
+// Fails if count elements of element_size bytes each can't fit in what remains
+// of a sized source.
+func (br *binaryReader) checkCount(count int, element_size int64) bool {
+	if br.err == nil && br.sized && (br.remaining < 0 || int64(count) > br.remaining/element_size) {
+		br.err = errors.New("Count of " + strconv.Itoa(count) + " exceeds the " +
+			strconv.FormatInt(br.remaining, 10) + " bytes remaining")
+	}
+	return br.err == nil
+}
+
+// The capacity to allocate up front for count elements which have been
+// checked, which is limited to a chunk unless the source is sized.
+func (br *binaryReader) capacity(count int) int {
+	if !br.sized && count > binaryReadChunk {
+		return binaryReadChunk
+	}
+	return count
+}
+
+// The size of the next chunk of a list of count elements of which read have
+// been read.
+func nextChunk(count, read int) int {
+	if count-read > binaryReadChunk {
+		return binaryReadChunk
+	}
+	return count - read
+}
+
+func (br *binaryReader) readUint32() (n uint32) {
+	br.read(&n)
+	return
+}
+
+func (br *binaryReader) readUvarint() (u uint64) {
+	if br.err == nil {
+		u, br.err = binary.ReadUvarint(br)
+	}
+	return
+}
+
+func (br *binaryReader) readVarint() (i int64) {
+	if br.err == nil {
+		i, br.err = binary.ReadVarint(br)
+	}
+	return
+}
+
+func (br *binaryReader) readString() string {
+	length := int(br.readUint32())
+	if !br.checkCount(length, 1) {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.Grow(br.capacity(length))
+	_, br.err = io.CopyN(&buf, br.r, int64(length))
+	br.remaining -= int64(length)
+	return buf.String()
+}
+
+func (br *binaryReader) readFloats(count int, use_float32 bool) (floats []float64) {
+	element_size := int64(8)
+	if use_float32 {
+		element_size = 4
+	}
+	if !br.checkCount(count, element_size) {
+		return
+	}
+	floats = make([]float64, 0, br.capacity(count))
+	for len(floats) < count && br.err == nil {
+		chunk := nextChunk(count, len(floats))
+		if !use_float32 {
+			floats64 := make([]float64, chunk)
+			br.read(floats64)
+			floats = append(floats, floats64...)
+			continue
+		}
+		floats32 := make([]float32, chunk)
+		br.read(floats32)
+		for _, f := range floats32 {
+			floats = append(floats, float64(f))
+		}
+	}
+	return
+}
+
+// Reads a list of indices written by writeIndices
+func (br *binaryReader) readIndices(count int, delta bool) (indices []int) {
+	// delta encoded indices take at least a byte each
+	element_size := int64(4)
+	if delta {
+		element_size = 1
+	}
+	if !br.checkCount(count, element_size) {
+		return
+	}
+	indices = make([]int, 0, br.capacity(count))
+	if !delta {
+		for len(indices) < count && br.err == nil {
+			indices32 := make([]uint32, nextChunk(count, len(indices)))
+			br.read(indices32)
+			for _, vi := range indices32 {
+				indices = append(indices, int(vi))
+			}
+		}
+		return
+	}
+	previous := 0
+	for len(indices) < count && br.err == nil {
+		previous += int(br.readVarint())
+		indices = append(indices, previous)
+	}
+	return
+}
+
+func LoadBinary(ss_reader *io.Reader) (ss *ShapeSet, err error) {
+	return loadBinary(&binaryReader{r: *ss_reader})
+}
+
+func loadBinary(br *binaryReader) (ss *ShapeSet, err error) {
+	header, err := readBinaryHeader(br)
+	if err != nil {
+		return
+	}
+
+	// for building up partial border info from meshes
+	border_tracker := make(borderTracker)
+
+	meshesBuffer := make([]*Mesh, 0)
+	mesh_count := br.readUint32()
+	for i := uint32(0); i < mesh_count && br.err == nil; i++ {
+		var m *Mesh
+		m, err = readBinaryMesh(br, header.encoding, border_tracker)
+		if err != nil {
+			return
+		}
+		meshesBuffer = append(meshesBuffer, m)
+	}
+	if br.err != nil {
+		err = errors.New("Could not read binary shapeset: " + br.err.Error())
+		return
+	}
+
+	// Create ShapeSet
+	ss = New(header.name, header.labels, meshesBuffer)
+	ss.Metadata = header.metadata
+
+	// merge border vertices
+	err = border_tracker.mergeInto(ss)
+
+	return
+}
+
+type binaryHeader struct {
+	version  uint16
+	encoding binaryEncoding
+	name     string
+	labels   map[string]string
+	metadata map[ShapeId]*ShapeMetadata
+}
+
+// Reads everything preceding the meshes
+func readBinaryHeader(br *binaryReader) (header binaryHeader, err error) {
+	magic := make([]byte, len(binaryMagic))
+	br.read(magic)
+	if br.err != nil || !bytes.Equal(magic, binaryMagic) {
+		err = errors.New("Not a binary shapeset")
+		return
+	}
+	var flags uint16
+	br.read(&header.version)
+	br.read(&flags)
+	if br.err == nil && header.version > binaryFormatVersion {
+		err = errors.New("Unsupported binary shapeset version: " +
+			strconv.Itoa(int(header.version)))
+		return
+	}
+	header.encoding.useFloat32 = flags&binaryFlagFloat32 != 0
+
+	header.name = br.readString()
+	header.labels = make(map[string]string)
+	shape_count := br.readUint32()
+	for i := uint32(0); i < shape_count && br.err == nil; i++ {
+		var shape_id int32
+		br.read(&shape_id)
+		header.labels[strconv.Itoa(int(shape_id))] = br.readString()
+	}
+
+	header.metadata = make(map[ShapeId]*ShapeMetadata)
+	if flags&binaryFlagMetadata != 0 {
+		metadata_count := br.readUint32()
+		for i := uint32(0); i < metadata_count && br.err == nil; i++ {
+			var shape_id int32
+			var has_color uint8
+			metadata := &ShapeMetadata{}
+			br.read(&shape_id)
+			br.read(&has_color)
+			if has_color != 0 {
+				metadata.Color = &Color{}
+				br.read(metadata.Color)
+			}
+			extra_count := br.readUint32()
+			if extra_count > 0 {
+				metadata.Extra = make(map[string]string)
+			}
+			for j := uint32(0); j < extra_count && br.err == nil; j++ {
+				key := br.readString()
+				metadata.Extra[key] = br.readString()
+			}
+			header.metadata[ShapeId(shape_id)] = metadata
+		}
+	}
+
+	if flags&binaryFlagQuantised != 0 {
+		grid := &quantisationGrid{}
+		br.read(&grid.Bits)
+		br.read(&grid.Origin)
+		br.read(&grid.Step)
+		header.encoding.grid = grid
+	}
+
+	if br.err != nil {
+		err = errors.New("Could not read binary shapeset: " + br.err.Error())
+	}
+	return
+}
+
+// Reads and builds a single mesh, registering its border vertices with the
+// border_tracker.
+func readBinaryMesh(
+	br *binaryReader,
+	encoding binaryEncoding,
+	border_tracker borderTracker,
+) (m *Mesh, err error) {
+	quantised := encoding.grid != nil
+
+	var verts, norms []float64
+	mesh_name := br.readString()
+	vert_count := int(br.readUint32())
+	if quantised {
+		// quantised positions take at least a byte each
+		if br.checkCount(vert_count*3, 1) {
+			verts = make([]float64, 0, br.capacity(vert_count*3))
+		}
+		for i := 0; i < vert_count*3 && br.err == nil; i++ {
+			verts = append(verts, encoding.grid.dequantise(i%3, br.readUvarint()))
+		}
+	} else {
+		verts = br.readFloats(vert_count*3, encoding.useFloat32)
+	}
+	var has_normals uint8
+	br.read(&has_normals)
+	if has_normals != 0 && quantised {
+		if br.checkCount(vert_count, 4) {
+			norms = make([]float64, 0, br.capacity(vert_count*3))
+		}
+		for len(norms) < vert_count*3 && br.err == nil {
+			encoded := make([][2]int16, nextChunk(vert_count, len(norms)/3))
+			br.read(encoded)
+			for _, e := range encoded {
+				n := octahedralDecode(e)
+				norms = append(norms, n.X, n.Y, n.Z)
+			}
+		}
+	} else if has_normals != 0 {
+		norms = br.readFloats(vert_count*3, encoding.useFloat32)
+	}
+	faces := br.readIndices(int(br.readUint32())*3, quantised)
+	if br.err != nil {
+		err = errors.New("Could not read binary shapeset: " + br.err.Error())
+		return
+	}
+
+	m, vertex_buffer, err := buildMesh(mesh_name, verts, norms, faces)
+	if err != nil {
+		return
+	}
+
+	border_count := br.readUint32()
+	for j := uint32(0); j < border_count && br.err == nil; j++ {
+		var border_id int32
+		br.read(&border_id)
+		vert_indices := br.readIndices(int(br.readUint32()), quantised)
+		if br.err != nil {
+			break
+		}
+		err = border_tracker.track(BorderId(border_id), mesh_name, vert_indices, vertex_buffer)
+		if err != nil {
+			return
+		}
+	}
+	if br.err != nil {
+		err = errors.New("Could not read binary shapeset: " + br.err.Error())
+	}
+	return
+}
+
+/* Serializes the shapeset in the binary container format. Vertex positions and
+ * normals are written as float64 unless use_float32 is set.
+ */
+func (ss *ShapeSet) SaveBinary(ss_writer *io.Writer, use_float32 bool) (err error) {
+	return ss.saveBinary(ss_writer, binaryEncoding{useFloat32: use_float32})
+}
+
+/* Serializes the shapeset in the binary container format with vertex positions
+ * quantised to a grid spanning the bounding box of the shapeset, with the
+ * given number of bits per axis (1 to 32). Normals are octahedrally encoded
+ * and face and border indices are delta encoded.
+ *
+ * Since every vertex is quantised to the same grid, vertices shared between
+ * meshes remain coincident, and IndexBorders still matches them once loaded.
+ */
+func (ss *ShapeSet) SaveBinaryQuantised(ss_writer *io.Writer, bits uint8) (err error) {
+	if bits < 1 || bits > 32 {
+		err = errors.New("Quantisation requires between 1 and 32 bits, not " +
+			strconv.Itoa(int(bits)))
+		return
+	}
+	return ss.saveBinary(ss_writer, binaryEncoding{grid: ss.quantisationGrid(bits)})
+}
+
+func (ss *ShapeSet) saveBinary(ss_writer *io.Writer, encoding binaryEncoding) (err error) {
+	bw := &binaryWriter{w: *ss_writer}
+
+	var flags uint16
+	if encoding.useFloat32 {
+		flags |= binaryFlagFloat32
+	}
+	if encoding.grid != nil {
+		flags |= binaryFlagQuantised
+	}
+	if len(ss.Metadata) > 0 {
+		flags |= binaryFlagMetadata
+	}
+	bw.write(binaryMagic)
+	bw.write(uint16(binaryFormatVersion))
+	bw.write(flags)
+	bw.writeString(ss.Name)
+
+	shape_ids := make([]int, 0, len(ss.Shapes))
+	for shape_id, _ := range ss.Shapes {
+		shape_ids = append(shape_ids, int(shape_id))
+	}
+	sort.Ints(shape_ids)
+	bw.write(uint32(len(shape_ids)))
+	for _, shape_id := range shape_ids {
+		bw.write(int32(shape_id))
+		bw.writeString(ss.Shapes[ShapeId(shape_id)])
+	}
+
+	if len(ss.Metadata) > 0 {
+		metadata_ids := make([]int, 0, len(ss.Metadata))
+		for shape_id, _ := range ss.Metadata {
+			metadata_ids = append(metadata_ids, int(shape_id))
+		}
+		sort.Ints(metadata_ids)
+		bw.write(uint32(len(metadata_ids)))
+		for _, shape_id := range metadata_ids {
+			metadata := ss.Metadata[ShapeId(shape_id)]
+			bw.write(int32(shape_id))
+			if metadata.Color != nil {
+				bw.write(uint8(1))
+				bw.write(metadata.Color)
+			} else {
+				bw.write(uint8(0))
+			}
+			keys := make([]string, 0, len(metadata.Extra))
+			for key, _ := range metadata.Extra {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			bw.write(uint32(len(keys)))
+			for _, key := range keys {
+				bw.writeString(key)
+				bw.writeString(metadata.Extra[key])
+			}
+		}
+	}
+
+	if encoding.grid != nil {
+		bw.write(encoding.grid.Bits)
+		bw.write(encoding.grid.Origin)
+		bw.write(encoding.grid.Step)
+	}
+
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(ss.Meshes))
+	for mesh_id, _ := range ss.Meshes {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+	bw.write(uint32(len(mesh_ids)))
+	contents := make([]*MeshContents, 0, len(mesh_ids))
+	for _, mesh_id := range mesh_ids {
+		contents = append(contents, writeBinaryMesh(bw, ss.Meshes[mesh_id], encoding))
+	}
+
+	// table of contents and footer
+	toc_offset := bw.n
+	bw.write(uint32(len(contents)))
+	for _, entry := range contents {
+		bw.write([2]int32{int32(entry.Id[0]), int32(entry.Id[1])})
+		bw.write(uint64(entry.Offset))
+		bw.write(uint64(entry.Length))
+		bw.write([6]float64{
+			entry.Min.X, entry.Min.Y, entry.Min.Z,
+			entry.Max.X, entry.Max.Y, entry.Max.Z,
+		})
+		bw.write(uint32(len(entry.BorderIds)))
+		for _, border_id := range entry.BorderIds {
+			bw.write(int32(border_id))
+		}
+	}
+	bw.write(uint64(toc_offset))
+	bw.write(binaryTOCMagic)
+
+	if bw.err != nil {
+		err = errors.New("Could not write binary shapeset for: " + ss.Name)
+	}
+	return
+}
+
+// Writes a single mesh, returning its entry for the table of contents
+func writeBinaryMesh(bw *binaryWriter, m *Mesh, encoding binaryEncoding) (entry *MeshContents) {
+	quantised := encoding.grid != nil
+	entry = &MeshContents{Id: m.Id(), Offset: bw.n}
+	m.ReindexVerticesAndFaces()
+	m.Vertices.EnsureNormals()
+
+	vert_count := m.Vertices.Len()
+	verts := make([]float64, 0, vert_count*3)
+	norms := make([]float64, 0, vert_count*3)
+	for i := 0; i < vert_count; i++ {
+		v := m.Vertices.Get(i)[0]
+		verts = append(verts, v.GetX(), v.GetY(), v.GetZ())
+		entry.extendBounds(i == 0, v.GetX(), v.GetY(), v.GetZ())
+		if n := v.GetNormal(); n != nil {
+			norms = append(norms, n.X, n.Y, n.Z)
+		}
+	}
+	has_normals := len(norms) == len(verts)
+
+	bw.writeString(entry.Id.ToString())
+	bw.write(uint32(vert_count))
+	if quantised {
+		for i, f := range verts {
+			bw.writeUvarint(encoding.grid.quantise(i%3, f))
+		}
+	} else {
+		bw.writeFloats(verts, encoding.useFloat32)
+	}
+	if has_normals {
+		bw.write(uint8(1))
+		if quantised {
+			encoded := make([][2]int16, vert_count)
+			for i := range encoded {
+				encoded[i] = octahedralEncode(norms[i*3], norms[i*3+1], norms[i*3+2])
+			}
+			bw.write(encoded)
+		} else {
+			bw.writeFloats(norms, encoding.useFloat32)
+		}
+	} else {
+		bw.write(uint8(0))
+	}
+
+	indices := make([]int, 0, m.Faces.Len()*3)
+	for i := 0; i < m.Faces.Len(); i++ {
+		f := m.Faces.Get(i)[0]
+		indices = append(indices,
+			f.GetA().GetLocationInMesh(m),
+			f.GetB().GetLocationInMesh(m),
+			f.GetC().GetLocationInMesh(m))
+	}
+	bw.write(uint32(m.Faces.Len()))
+	bw.writeIndices(indices, quantised)
+
+	border_ids := make([]int, 0, len(m.Borders))
+	for border_id, _ := range m.Borders {
+		border_ids = append(border_ids, int(border_id))
+	}
+	sort.Ints(border_ids)
+	bw.write(uint32(len(border_ids)))
+	for _, bid := range border_ids {
+		border := m.Borders[BorderId(bid)]
+		entry.BorderIds = append(entry.BorderIds, BorderId(bid))
+		bw.write(int32(bid))
+		bw.write(uint32(border.Len()))
+		border_indices := make([]int, border.Len())
+		for i := 0; i < border.Len(); i++ {
+			border_indices[i] = border.Vertices[i].GetLocationInMesh(m)
+		}
+		bw.writeIndices(border_indices, quantised)
+	}
+
+	entry.Length = bw.n - entry.Offset
+	return
+}
@@ -0,0 +1,287 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/nat-n/geom"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The points where the boxes of boxRow meet, listed in the same order by each
+// mesh of the border between them.
+var boxRowBorders = []struct {
+	Id      BorderId
+	MeshIds []MeshId
+	X       float64
+}{
+	{1, []MeshId{{0, 1}, {0, 2}, {1, 2}}, 1},
+	{2, []MeshId{{0, 2}, {0, 3}, {2, 3}}, 2},
+}
+
+/* Writes boxRow byte by byte in the layout of the given version of the binary
+ * format, rather than with SaveBinary, so that files written before the
+ * format gained later sections are still read as they were written.
+ *
+ * Version 1 has the header, shapes and meshes, with positions as float64
+ * unless flagged as float32. Version 2 adds the table of contents and footer.
+ * Version 3 adds quantisation, on a grid of 1024 steps per unit from the
+ * origin. Version 4 adds shape metadata, given for the middle box.
+ */
+func binaryFixture(version uint16, flags uint16) []byte {
+	var buf bytes.Buffer
+	write := func(data interface{}) {
+		binary.Write(&buf, binary.LittleEndian, data)
+	}
+	write_string := func(s string) {
+		write(uint32(len(s)))
+		buf.WriteString(s)
+	}
+	varint := make([]byte, binary.MaxVarintLen64)
+	write_positions := func(floats []float64) {
+		for _, f := range floats {
+			switch {
+			case flags&binaryFlagQuantised != 0:
+				buf.Write(varint[:binary.PutUvarint(varint, uint64(f*1024))])
+			case flags&binaryFlagFloat32 != 0:
+				write(float32(f))
+			default:
+				write(f)
+			}
+		}
+	}
+	write_indices := func(indices []int) {
+		previous := 0
+		for _, index := range indices {
+			if flags&binaryFlagQuantised != 0 {
+				buf.Write(varint[:binary.PutVarint(varint, int64(index-previous))])
+				previous = index
+			} else {
+				write(uint32(index))
+			}
+		}
+	}
+
+	buf.Write([]byte("SSB\x00"))
+	write(version)
+	write(flags)
+	write_string("boxes")
+	write(uint32(3))
+	for _, shape_id := range []string{"1", "2", "3"} {
+		write(int32(ShapeIdFromString(shape_id)))
+		write_string(boxRowLabels[shape_id])
+	}
+	if flags&binaryFlagMetadata != 0 {
+		write(uint32(1))
+		write(int32(2))
+		write(uint8(1))
+		write(Color{1, 0.5, 0, 1})
+		write(uint32(1))
+		write_string("abbr")
+		write_string("M")
+	}
+	if flags&binaryFlagQuantised != 0 {
+		write(uint8(16))
+		write([3]float64{0, 0, 0})
+		write([3]float64{1.0 / 1024, 1.0 / 1024, 1.0 / 1024})
+	}
+
+	positions, mesh_faces := boxRow()
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(mesh_faces))
+	for mesh_id, _ := range mesh_faces {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+	write(uint32(len(mesh_ids)))
+	contents := make([]MeshContents, 0, len(mesh_ids))
+	for _, mesh_id := range mesh_ids {
+		entry := MeshContents{Id: mesh_id, Offset: int64(buf.Len())}
+		verts, indices, local_indices := localBuffers(positions, mesh_faces[mesh_id])
+		for i := 0; i < len(verts); i += 3 {
+			entry.extendBounds(i == 0, verts[i], verts[i+1], verts[i+2])
+		}
+		write_string(mesh_id.ToString())
+		write(uint32(len(verts) / 3))
+		write_positions(verts)
+		write(uint8(0))
+		write(uint32(len(indices) / 3))
+		write_indices(indices)
+
+		border_indices := make(map[BorderId][]int)
+		for _, border := range boxRowBorders {
+			if !meshIdInSlice(mesh_id, border.MeshIds) {
+				continue
+			}
+			for _, corner := range [4][2]float64{{0, 0}, {0, 1}, {1, 1}, {1, 0}} {
+				p := geom.Vec3{border.X, corner[0], corner[1]}
+				for pi, local_index := range local_indices {
+					if positions[pi] == p {
+						border_indices[border.Id] = append(border_indices[border.Id], local_index)
+					}
+				}
+			}
+		}
+		write(uint32(len(border_indices)))
+		for _, border := range boxRowBorders {
+			if indices, exists := border_indices[border.Id]; exists {
+				entry.BorderIds = append(entry.BorderIds, border.Id)
+				write(int32(border.Id))
+				write(uint32(len(indices)))
+				write_indices(indices)
+			}
+		}
+		entry.Length = int64(buf.Len()) - entry.Offset
+		contents = append(contents, entry)
+	}
+
+	if version >= 2 {
+		toc_offset := uint64(buf.Len())
+		write(uint32(len(contents)))
+		for _, entry := range contents {
+			write([2]int32{int32(entry.Id[0]), int32(entry.Id[1])})
+			write(uint64(entry.Offset))
+			write(uint64(entry.Length))
+			write([6]float64{entry.Min.X, entry.Min.Y, entry.Min.Z, entry.Max.X, entry.Max.Y, entry.Max.Z})
+			write(uint32(len(entry.BorderIds)))
+			for _, border_id := range entry.BorderIds {
+				write(int32(border_id))
+			}
+		}
+		write(toc_offset)
+		buf.Write(binaryTOCMagic)
+	}
+	return buf.Bytes()
+}
+
+func meshIdInSlice(mesh_id MeshId, mesh_ids []MeshId) bool {
+	for _, other := range mesh_ids {
+		if other == mesh_id {
+			return true
+		}
+	}
+	return false
+}
+
+func loadBinaryBytes(t *testing.T, data []byte) *ShapeSet {
+	r := io.Reader(bytes.NewReader(data))
+	ss, err := LoadBinary(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+// Checks that the loaded shapeset matches the original, with vertex positions
+// within tolerance.
+func checkShapeSetsMatch(t *testing.T, original, loaded *ShapeSet, tolerance float64) {
+	if loaded.Name != original.Name || len(loaded.Shapes) != len(original.Shapes) {
+		t.Fatalf("Expected shapes %v of %s, got %v of %s",
+			original.Shapes, original.Name, loaded.Shapes, loaded.Name)
+	}
+	for shape_id, label := range original.Shapes {
+		if loaded.Shapes[shape_id] != label {
+			t.Errorf("Expected shape %d to be %s, got %s", shape_id, label, loaded.Shapes[shape_id])
+		}
+	}
+	if len(loaded.Meshes) != len(original.Meshes) {
+		t.Fatalf("Expected %d meshes, got %d", len(original.Meshes), len(loaded.Meshes))
+	}
+	for mesh_id, m1 := range original.Meshes {
+		m2, exists := loaded.Meshes[mesh_id]
+		if !exists || m2.Vertices.Len() != m1.Vertices.Len() || m2.Faces.Len() != m1.Faces.Len() {
+			t.Fatalf("Mesh %s wasn't loaded intact", mesh_id.ToString())
+		}
+		for i := 0; i < m1.Vertices.Len(); i++ {
+			v1, v2 := m1.Vertices.Get(i)[0], m2.Vertices.Get(i)[0]
+			if math.Abs(v1.GetX()-v2.GetX()) > tolerance ||
+				math.Abs(v1.GetY()-v2.GetY()) > tolerance ||
+				math.Abs(v1.GetZ()-v2.GetZ()) > tolerance {
+				t.Errorf("Vertex %d of mesh %s moved from (%g,%g,%g) to (%g,%g,%g)",
+					i, mesh_id.ToString(), v1.GetX(), v1.GetY(), v1.GetZ(),
+					v2.GetX(), v2.GetY(), v2.GetZ())
+			}
+		}
+		for i := 0; i < m1.Faces.Len(); i++ {
+			f1, f2 := m1.Faces.Get(i)[0], m2.Faces.Get(i)[0]
+			if f1.GetA().GetLocationInMesh(m1) != f2.GetA().GetLocationInMesh(m2) ||
+				f1.GetB().GetLocationInMesh(m1) != f2.GetB().GetLocationInMesh(m2) ||
+				f1.GetC().GetLocationInMesh(m1) != f2.GetC().GetLocationInMesh(m2) {
+				t.Errorf("Face %d of mesh %s changed", i, mesh_id.ToString())
+			}
+		}
+	}
+	original.BordersIndex.Each(func(b1 *Border) {
+		b2 := loaded.BordersIndex.BorderFor(b1.Id)
+		if b2 == nil || b2.Description() != b1.Description() || b2.Len() != b1.Len() {
+			t.Errorf("Border %d wasn't loaded intact", b1.Id)
+		}
+	})
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, use_float32 := range []bool{false, true} {
+		ss := boxRowShapeSet(t)
+		var buf bytes.Buffer
+		w := io.Writer(&buf)
+		if err := ss.SaveBinary(&w, use_float32); err != nil {
+			t.Fatal(err)
+		}
+		checkShapeSetsMatch(t, ss, loadBinaryBytes(t, buf.Bytes()), 0)
+	}
+}
+
+func TestLoadBinaryOldVersions(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	for version, version_flags := range map[uint16][]uint16{
+		1: {0, binaryFlagFloat32},
+		2: {0, binaryFlagFloat32},
+		3: {0, binaryFlagQuantised},
+		4: {binaryFlagMetadata, binaryFlagMetadata | binaryFlagQuantised},
+	} {
+		for _, flags := range version_flags {
+			loaded := loadBinaryBytes(t, binaryFixture(version, flags))
+			checkShapeSetsMatch(t, ss, loaded, 0)
+			summaries := borderSummaries(loaded)
+			for _, border := range boxRowBorders {
+				expected := BorderDescriptionFromMeshIds(border.MeshIds)
+				if !strings.HasPrefix(summaries[border.Id], expected.ToString()+" ") {
+					t.Errorf("Expected border %d of version %d to be %s, got %s",
+						border.Id, version, expected.ToString(), summaries[border.Id])
+				}
+			}
+
+			metadata := loaded.Metadata[2]
+			if flags&binaryFlagMetadata == 0 {
+				if len(loaded.Metadata) != 0 {
+					t.Errorf("Expected no metadata in version %d, got %v", version, loaded.Metadata)
+				}
+			} else if len(loaded.Metadata) != 1 || metadata == nil ||
+				*metadata.Color != (Color{1, 0.5, 0, 1}) || metadata.Extra["abbr"] != "M" {
+				t.Errorf("Expected metadata of the middle box, got %v", loaded.Metadata)
+			}
+		}
+	}
+}
+
+func TestLoadBinaryRejectsCorruptCounts(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(binaryMagic)
+	binary.Write(&buf, binary.LittleEndian, uint16(binaryFormatVersion))
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+	binary.Write(&buf, binary.LittleEndian, uint32(math.MaxUint32))
+	buf.WriteString("boxes")
+
+	data := buf.Bytes()
+	_, err := loadBinary(newSizedBinaryReader(bytes.NewReader(data), int64(len(data))))
+	if err == nil || !strings.Contains(err.Error(), "exceeds the 5 bytes remaining") {
+		t.Errorf("Expected the name length to exceed the file, got %v", err)
+	}
+
+	r := io.Reader(bytes.NewReader(data))
+	if _, err := LoadBinary(&r); err == nil {
+		t.Errorf("Expected a truncated name to fail")
+	}
+}
@@ -0,0 +1,229 @@
+package shapeset
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/nat-n/geom"
+	"github.com/nat-n/gomesh/mesh"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// An entry in the table of contents of a binary shapeset file, describing
+// where to find a mesh, the extent of its vertices, and the borders it has.
+type MeshContents struct {
+	Id        MeshId
+	Offset    int64
+	Length    int64
+	Min, Max  geom.Vec3
+	BorderIds []BorderId
+}
+
+func (entry *MeshContents) extendBounds(first bool, x, y, z float64) {
+	if first {
+		entry.Min = geom.Vec3{x, y, z}
+		entry.Max = geom.Vec3{x, y, z}
+		return
+	}
+	entry.Min = geom.Vec3{math.Min(entry.Min.X, x), math.Min(entry.Min.Y, y), math.Min(entry.Min.Z, z)}
+	entry.Max = geom.Vec3{math.Max(entry.Max.X, x), math.Max(entry.Max.Y, y), math.Max(entry.Max.Z, z)}
+}
+
+/* A shapeset backed by a binary shapeset file (version 2 or later), of which
+ * only the header and table of contents are read up front. Meshes are read
+ * from the file as they're needed, so that a region can be composed without
+ * reading the rest of the shapeset.
+ *
+ * The underlying io.ReaderAt is only ever read from at explicit offsets, so a
+ * LazyShapeSet can be used from multiple goroutines at once.
+ */
+type LazyShapeSet struct {
+	Name     string
+	Shapes   map[ShapeId]string
+	Metadata map[ShapeId]*ShapeMetadata
+	Contents map[MeshId]*MeshContents
+	encoding binaryEncoding
+	labels   map[string]string
+	source   io.ReaderAt
+	closer   io.Closer
+}
+
+// Opens a binary shapeset file for lazy reading, the LazyShapeSet should be
+// closed when no longer needed.
+func OpenLazy(ss_file_path string) (lss *LazyShapeSet, err error) {
+	input_file, err := os.Open(ss_file_path)
+	if err != nil {
+		return
+	}
+	stat, err := input_file.Stat()
+	if err == nil {
+		lss, err = NewLazyShapeSet(input_file, stat.Size())
+	}
+	if err != nil {
+		input_file.Close()
+		return
+	}
+	lss.closer = input_file
+	return
+}
+
+// Reads the header and table of contents of the binary shapeset in source,
+// which has the given size in bytes.
+func NewLazyShapeSet(source io.ReaderAt, size int64) (lss *LazyShapeSet, err error) {
+	br := newSizedBinaryReader(bufio.NewReader(io.NewSectionReader(source, 0, size)), size)
+	header, err := readBinaryHeader(br)
+	if err != nil {
+		return
+	}
+	if header.version < 2 {
+		err = errors.New("Binary shapeset has no table of contents, it must be " +
+			"saved again to be read lazily")
+		return
+	}
+
+	lss = &LazyShapeSet{
+		Name:     header.name,
+		Shapes:   make(map[ShapeId]string),
+		Metadata: header.metadata,
+		Contents: make(map[MeshId]*MeshContents),
+		encoding: header.encoding,
+		labels:   header.labels,
+		source:   source,
+	}
+	for shape_id_str, shape_label := range header.labels {
+		lss.Shapes[ShapeIdFromString(shape_id_str)] = shape_label
+	}
+
+	// locate the table of contents from the footer
+	var toc_offset uint64
+	footer := &binaryReader{r: io.NewSectionReader(source, size-binaryFooterSize, binaryFooterSize)}
+	footer.read(&toc_offset)
+	magic := make([]byte, len(binaryTOCMagic))
+	footer.read(magic)
+	if footer.err != nil || !bytes.Equal(magic, binaryTOCMagic) ||
+		int64(toc_offset) > size-binaryFooterSize {
+		err = errors.New("Could not find binary shapeset table of contents")
+		return
+	}
+
+	toc_size := size - binaryFooterSize - int64(toc_offset)
+	toc := newSizedBinaryReader(bufio.NewReader(
+		io.NewSectionReader(source, int64(toc_offset), toc_size)), toc_size)
+	mesh_count := toc.readUint32()
+	for i := uint32(0); i < mesh_count && toc.err == nil; i++ {
+		var mesh_id [2]int32
+		var offset, length uint64
+		var bounds [6]float64
+		toc.read(&mesh_id)
+		toc.read(&offset)
+		toc.read(&length)
+		toc.read(&bounds)
+		if toc.err == nil && (offset > toc_offset || length > toc_offset-offset) {
+			err = errors.New("Binary shapeset table of contents places a mesh " +
+				"beyond the meshes section")
+			return
+		}
+		entry := &MeshContents{
+			Id:     MeshId{ShapeId(mesh_id[0]), ShapeId(mesh_id[1])},
+			Offset: int64(offset),
+			Length: int64(length),
+			Min:    geom.Vec3{bounds[0], bounds[1], bounds[2]},
+			Max:    geom.Vec3{bounds[3], bounds[4], bounds[5]},
+		}
+		border_count := toc.readUint32()
+		for j := uint32(0); j < border_count && toc.err == nil; j++ {
+			var border_id int32
+			toc.read(&border_id)
+			entry.BorderIds = append(entry.BorderIds, BorderId(border_id))
+		}
+		lss.Contents[entry.Id] = entry
+	}
+	if toc.err != nil {
+		err = errors.New("Could not read binary shapeset table of contents: " +
+			toc.err.Error())
+	}
+	return
+}
+
+func (lss *LazyShapeSet) Close() (err error) {
+	if lss.closer != nil {
+		err = lss.closer.Close()
+	}
+	return
+}
+
+// Lists the meshes which make up the surface of the region defined by the
+// given shapes, i.e. those with exactly one of their shapes in the region.
+func (lss *LazyShapeSet) RegionMeshIds(shape_ids ...int) (mesh_ids []MeshId) {
+	for mesh_id, _ := range lss.Contents {
+		if intInSlice(int(mesh_id[0]), shape_ids) != intInSlice(int(mesh_id[1]), shape_ids) {
+			mesh_ids = append(mesh_ids, mesh_id)
+		}
+	}
+	sort.Sort(ByMeshIdPrecedence(mesh_ids))
+	return
+}
+
+// Lists the meshes of each border recorded in the table of contents
+func (lss *LazyShapeSet) borderMeshIds() (border_mesh_ids map[BorderId][]MeshId) {
+	border_mesh_ids = make(map[BorderId][]MeshId)
+	for mesh_id, entry := range lss.Contents {
+		for _, border_id := range entry.BorderIds {
+			border_mesh_ids[border_id] = append(border_mesh_ids[border_id], mesh_id)
+		}
+	}
+	return
+}
+
+/* Reads just the given meshes into a new ShapeSet, with borders between them
+ * merged as they would be when reading the whole file. Borders keep the ids
+ * and meshes recorded for them in the table of contents, including any meshes
+ * which weren't read, so that borders which only differ in the meshes that
+ * weren't read remain distinct. The meshes which weren't read are recorded as
+ * such, so that they aren't mistaken for missing meshes by VerifyBorders.
+ */
+func (lss *LazyShapeSet) Load(mesh_ids ...MeshId) (ss *ShapeSet, err error) {
+	border_tracker := make(borderTracker)
+	meshesBuffer := make([]*Mesh, 0, len(mesh_ids))
+	for _, mesh_id := range mesh_ids {
+		entry, exists := lss.Contents[mesh_id]
+		if !exists {
+			err = errors.New("Binary shapeset has no mesh: " + mesh_id.ToString())
+			return
+		}
+		br := newSizedBinaryReader(bufio.NewReader(
+			io.NewSectionReader(lss.source, entry.Offset, entry.Length)), entry.Length)
+		var m *Mesh
+		m, err = readBinaryMesh(br, lss.encoding, border_tracker)
+		if err != nil {
+			return
+		}
+		meshesBuffer = append(meshesBuffer, m)
+	}
+
+	ss = New(lss.Name, lss.labels, meshesBuffer)
+	for shape_id, metadata := range lss.Metadata {
+		ss.Metadata[shape_id] = metadata
+	}
+	ss.unloadedMeshes = make(map[MeshId]bool)
+	for mesh_id, _ := range lss.Contents {
+		if _, loaded := ss.Meshes[mesh_id]; !loaded {
+			ss.unloadedMeshes[mesh_id] = true
+		}
+	}
+	err = border_tracker.mergeIntoWithMeshIds(ss, lss.borderMeshIds())
+	return
+}
+
+// Reads only the meshes of the region defined by the given shapes, and
+// composes them as ShapeSet.ComposeRegion would.
+func (lss *LazyShapeSet) ComposeRegion(shape_ids ...int) (result mesh.Mesh, err error) {
+	ss, err := lss.Load(lss.RegionMeshIds(shape_ids...)...)
+	if err != nil {
+		return
+	}
+	return ss.ComposeRegion(shape_ids...)
+}
@@ -0,0 +1,119 @@
+package shapeset
+
+import (
+	"bytes"
+	"github.com/nat-n/geom"
+	"io"
+	"testing"
+)
+
+func lazyShapeSet(t *testing.T, data []byte) *LazyShapeSet {
+	lss, err := NewLazyShapeSet(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lss
+}
+
+func TestLazyShapeSetContents(t *testing.T) {
+	lss := lazyShapeSet(t, binaryFixture(2, 0))
+	if lss.Name != "boxes" || len(lss.Shapes) != 3 || lss.Shapes[2] != "middle" {
+		t.Errorf("Expected the shapes of boxes, got %v of %s", lss.Shapes, lss.Name)
+	}
+	if len(lss.Contents) != 5 {
+		t.Fatalf("Expected 5 meshes in the table of contents, got %d", len(lss.Contents))
+	}
+	entry := lss.Contents[MeshId{0, 3}]
+	if entry.Min != (geom.Vec3{2, 0, 0}) || entry.Max != (geom.Vec3{3, 1, 1}) {
+		t.Errorf("Expected mesh 0-3 to span the right box, got %v to %v", entry.Min, entry.Max)
+	}
+	if entry = lss.Contents[MeshId{0, 2}]; len(entry.BorderIds) != 2 {
+		t.Errorf("Expected mesh 0-2 to have both borders, got %v", entry.BorderIds)
+	}
+
+	mesh_ids := lss.RegionMeshIds(1)
+	if len(mesh_ids) != 2 || mesh_ids[0] != (MeshId{0, 1}) || mesh_ids[1] != (MeshId{1, 2}) {
+		t.Errorf("Expected the left box to be bounded by meshes 0-1 and 1-2, got %v", mesh_ids)
+	}
+
+	version1 := binaryFixture(1, 0)
+	if _, err := NewLazyShapeSet(bytes.NewReader(version1), int64(len(version1))); err == nil {
+		t.Errorf("Expected a version 1 file without a table of contents to be rejected")
+	}
+}
+
+func TestLazyLoad(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	var buf bytes.Buffer
+	w := io.Writer(&buf)
+	if err := ss.SaveBinary(&w, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{buf.Bytes(), binaryFixture(2, 0)} {
+		lss := lazyShapeSet(t, data)
+		partial, err := lss.Load(MeshId{0, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(partial.Meshes) != 1 || partial.Meshes[MeshId{0, 1}].Faces.Len() != 10 {
+			t.Fatalf("Expected just mesh 0-1, got %d meshes", len(partial.Meshes))
+		}
+
+		// the border keeps all of its meshes, which aren't reported as missing
+		border := partial.BordersIndex.BorderFor(BorderId(1))
+		if border == nil || border.Len() != 4 {
+			t.Fatalf("Expected border 1 to be loaded with its 4 vertices")
+		}
+		if desc := border.Description(); desc.ToString() != "0-1_0-2_1-2" {
+			t.Errorf("Expected border 1 to keep all of its meshes, got %s", desc.ToString())
+		}
+		meshes := 0
+		border.EachMesh(func(m *Mesh) { meshes++ })
+		if meshes != 1 {
+			t.Errorf("Expected border 1 to have 1 loaded mesh, got %d", meshes)
+		}
+		if report := partial.VerifyBorders(); !report.Ok() {
+			t.Errorf("Expected the borders of a partial load to be valid, got %+v", report.Violations)
+		}
+
+		// composing lazily reads only what the region needs
+		composed, err := lss.ComposeRegion(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := ss.ComposeRegion(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		composed_triangles := orientedTriangles(&composed)
+		for triangle, _ := range orientedTriangles(&expected) {
+			if !composed_triangles[triangle] {
+				t.Errorf("Expected the lazily composed region to have the triangle %v", triangle)
+			}
+		}
+	}
+
+	// meshes which are missing without having been left out are still reported
+	delete(ss.Meshes, MeshId{0, 1})
+	if report := ss.VerifyBorders(); report.Ok() || report.Violations[0].Kind != BorderMeshMissing {
+		t.Errorf("Expected a missing mesh to be reported, got %+v", report.Violations)
+	}
+}
+
+func TestLazyLoadCopiesMetadata(t *testing.T) {
+	lss := lazyShapeSet(t, binaryFixture(2, 0))
+	lss.Metadata[1] = &ShapeMetadata{Color: &Color{1, 0, 0, 1}}
+	first, err := lss.Load(MeshId{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := lss.Load(MeshId{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Metadata[2] = &ShapeMetadata{Color: &Color{0, 1, 0, 1}}
+	if second.Metadata[1] == nil || second.Metadata[2] != nil || lss.Metadata[2] != nil {
+		t.Errorf("Expected each load to have its own metadata, got %v and %v",
+			second.Metadata, lss.Metadata)
+	}
+}
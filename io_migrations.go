@@ -0,0 +1,126 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// The version of the shapeset json schema written by Save. Documents without a
+// version field predate versioning and are treated as version 0.
+const JSONSchemaVersion = 2
+
+/* A migration upgrades a shapeset json document from one version of the schema
+ * to the next. Since documents are streamed, the top level values other than
+ * the meshes (the header), and each mesh object, are migrated separately. Both
+ * are presented as maps of their keys onto raw json values, which a migration
+ * may modify in place. Either function may be nil if that part of the
+ * document is unaffected.
+ */
+type jsonMigration struct {
+	Description string
+	Header      func(header map[string]json.RawMessage) error
+	Mesh        func(mesh map[string]json.RawMessage) error
+}
+
+// jsonMigrations[i] upgrades documents of version i to version i+1, so there
+// must always be exactly JSONSchemaVersion of them.
+var jsonMigrations = []jsonMigration{
+	{Description: "Adds the version field, the schema is otherwise unchanged"},
+	{Description: "Adds optional shape metadata"},
+}
+
+func init() {
+	if len(jsonMigrations) != JSONSchemaVersion {
+		panic("A json migration is required for every version of the schema")
+	}
+}
+
+// Checks that a document of the given version can be read
+func checkJSONSchemaVersion(version int) (err error) {
+	if version < 0 {
+		err = errors.New("Invalid shapeset json version: " + strconv.Itoa(version))
+	} else if version > JSONSchemaVersion {
+		err = errors.New("Shapeset json version " + strconv.Itoa(version) +
+			" is newer than the latest supported version " +
+			strconv.Itoa(JSONSchemaVersion) + ", a newer release is required to read it")
+	}
+	return
+}
+
+// Upgrades the header of a document of the given version to the current schema
+func migrateJSONHeader(header map[string]json.RawMessage, version int) (err error) {
+	for _, migration := range jsonMigrations[version:] {
+		if migration.Header == nil {
+			continue
+		}
+		if err = migration.Header(header); err != nil {
+			err = errors.New("Could not migrate shapeset json (" +
+				migration.Description + "): " + err.Error())
+			return
+		}
+	}
+	return
+}
+
+// Upgrades a serialized mesh object of the given version to the current schema
+func migrateJSONMesh(raw_mesh []byte, version int) (migrated []byte, err error) {
+	migrated = raw_mesh
+	mesh_fields := make(map[string]json.RawMessage)
+	decoded := false
+	for _, migration := range jsonMigrations[version:] {
+		if migration.Mesh == nil {
+			continue
+		}
+		if !decoded {
+			if err = json.Unmarshal(raw_mesh, &mesh_fields); err != nil {
+				err = errors.New("Could not parse json from ss_reader: " + err.Error())
+				return
+			}
+			decoded = true
+		}
+		if err = migration.Mesh(mesh_fields); err != nil {
+			err = errors.New("Could not migrate shapeset json (" +
+				migration.Description + "): " + err.Error())
+			return
+		}
+	}
+	if decoded {
+		migrated, err = json.Marshal(mesh_fields)
+	}
+	return
+}
+
+// Whether any migration from the given version of the schema changes meshes
+func meshesNeedMigration(version int) bool {
+	for _, migration := range jsonMigrations[version:] {
+		if migration.Mesh != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads one mesh object from the stream as readMesh does, first upgrading it
+// from the given version of the schema if necessary. Meshes which no migration
+// changes are streamed directly rather than being buffered to be migrated.
+func (sr *jsonStreamReader) readMigratedMesh(
+	border_tracker borderTracker,
+	version int,
+) (m *Mesh, err error) {
+	if !meshesNeedMigration(version) {
+		return sr.readMesh(border_tracker)
+	}
+
+	var raw_mesh json.RawMessage
+	if err = sr.dec.Decode(&raw_mesh); err != nil {
+		err = errors.New("Could not parse json from ss_reader: " + err.Error())
+		return
+	}
+	migrated, err := migrateJSONMesh(raw_mesh, version)
+	if err != nil {
+		return
+	}
+	return newJSONStreamReader(bytes.NewReader(migrated)).readMesh(border_tracker)
+}
@@ -0,0 +1,113 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// A document of a single triangle, with the given top level fields before its
+// meshes.
+func triangleDocument(fields string) string {
+	return `{` + fields + `"name":"tri","shapes":{"1":"a"},"meshes":[` +
+		`{"name":"0-1","verts":"0,0,0,1,0,0,0,1,0","faces":"0,1,2","borders":{}}]}`
+}
+
+func TestLoadOlderVersions(t *testing.T) {
+	for _, fields := range []string{``, `"version":0,`, `"version":1,`} {
+		ss, err := loadJSON(t, triangleDocument(fields))
+		if err != nil {
+			t.Fatalf("Expected %s to load, got %v", triangleDocument(fields), err)
+		}
+		if ss.Shapes[1] != "a" || ss.Meshes[MeshId{0, 1}].Faces.Len() != 1 || ss.Metadata == nil {
+			t.Errorf("Expected %s to load as the current version", triangleDocument(fields))
+		}
+	}
+
+	for fields, message := range map[string]string{
+		`"version":3,`:  "is newer than the latest supported version",
+		`"version":-1,`: "Invalid shapeset json version",
+	} {
+		if _, err := loadJSON(t, triangleDocument(fields)); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected %s to fail with %q, got %v", fields, message, err)
+		}
+	}
+}
+
+func TestLoadMetadata(t *testing.T) {
+	ss, err := loadJSON(t, triangleDocument(
+		`"version":2,"metadata":{"1":{"color":[1,0,0.5,1],"extra":{"abbr":"A"}}},`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := ss.Metadata[1]
+	if metadata == nil || *metadata.Color != (Color{1, 0, 0.5, 1}) || metadata.Extra["abbr"] != "A" {
+		t.Fatalf("Expected the metadata of shape 1 to be loaded, got %+v", metadata)
+	}
+
+	loaded, err := loadJSON(t, string(saveJSON(t, ss)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded.Metadata[1].Color != *metadata.Color || loaded.Metadata[1].Extra["abbr"] != "A" {
+		t.Errorf("Expected metadata to survive a round trip, got %+v", loaded.Metadata[1])
+	}
+}
+
+func TestLoadNullMetadata(t *testing.T) {
+	for _, metadata := range []string{`null`, `{"1":null}`, `{"1":{"color":[1,0,0,1]},"2":null}`} {
+		ss, err := loadJSON(t, triangleDocument(`"version":2,"metadata":`+metadata+`,`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ss.Metadata == nil || ss.Metadata[2] != nil || len(ss.Metadata) > 1 {
+			t.Errorf("Expected null metadata %s to be left out, got %v", metadata, ss.Metadata)
+		}
+		for shape_id, shape_metadata := range ss.Metadata {
+			if shape_metadata == nil {
+				t.Errorf("Expected no nil metadata, got it for shape %d", shape_id)
+			}
+		}
+
+		// the metadata can be added to and written out
+		table := newLabelTable()
+		table.add(3, "c", &ShapeMetadata{Color: &Color{0, 0, 1, 1}})
+		ss.ApplyLabelTable(table)
+		var buf bytes.Buffer
+		w := io.Writer(&buf)
+		if err = ss.SaveBinary(&w, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadMigratesMeshes(t *testing.T) {
+	// stand in a migration which renames a field of each mesh
+	migrations := jsonMigrations
+	defer func() { jsonMigrations = migrations }()
+	jsonMigrations = []jsonMigration{{
+		Description: "Renames vertices to verts",
+		Mesh: func(mesh map[string]json.RawMessage) error {
+			mesh["verts"] = mesh["vertices"]
+			delete(mesh, "vertices")
+			return nil
+		},
+	}, migrations[1]}
+
+	doc := `{"name":"tri","shapes":{"1":"a"},"meshes":[` +
+		`{"name":"0-1","vertices":"0,0,0,1,0,0,0,1,0","faces":"0,1,2","borders":{}}]}`
+	ss, err := loadJSON(t, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.Meshes[MeshId{0, 1}].Vertices.Len() != 3 {
+		t.Errorf("Expected the mesh to be migrated, got %d vertices", ss.Meshes[MeshId{0, 1}].Vertices.Len())
+	}
+
+	// documents of the current version are read as they are
+	if _, err = loadJSON(t, strings.Replace(doc, `{"name":"tri"`, `{"version":2,"name":"tri"`, 1)); err == nil {
+		t.Errorf("Expected a current document to be read without migration")
+	}
+}
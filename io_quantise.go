@@ -0,0 +1,93 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	"math"
+)
+
+// A regular grid to which vertex positions are snapped when quantised, with
+// 2^Bits points along each axis from Origin in increments of Step.
+type quantisationGrid struct {
+	Bits   uint8
+	Origin [3]float64
+	Step   [3]float64
+}
+
+/* Spans the vertices of the shapeset with a grid of the given resolution.
+ * Bounds are taken from the vertex positions, as for the table of contents,
+ * since the cached bounding boxes of meshes aren't updated when vertices are
+ * moved, e.g. by ScaleAndCenter or ReloadVertices.
+ */
+func (ss *ShapeSet) quantisationGrid(bits uint8) (grid *quantisationGrid) {
+	grid = &quantisationGrid{Bits: bits}
+	var bounds MeshContents
+	first := true
+	for _, m := range ss.Meshes {
+		for i := 0; i < m.Vertices.Len(); i++ {
+			v := m.Vertices.Get(i)[0]
+			bounds.extendBounds(first, v.GetX(), v.GetY(), v.GetZ())
+			first = false
+		}
+	}
+	if first {
+		return
+	}
+	levels := float64(uint64(1)<<bits - 1)
+	grid.Origin = [3]float64{bounds.Min.X, bounds.Min.Y, bounds.Min.Z}
+	grid.Step = [3]float64{
+		(bounds.Max.X - bounds.Min.X) / levels,
+		(bounds.Max.Y - bounds.Min.Y) / levels,
+		(bounds.Max.Z - bounds.Min.Z) / levels,
+	}
+	return
+}
+
+// Snaps a coordinate on the given axis to the nearest grid point, clamped to
+// the extent of the grid. Equal coordinates always snap to the same point.
+func (grid *quantisationGrid) quantise(axis int, f float64) uint64 {
+	if grid.Step[axis] == 0 {
+		return 0
+	}
+	q := math.Floor((f-grid.Origin[axis])/grid.Step[axis] + 0.5)
+	return uint64(math.Max(0, math.Min(q, float64(uint64(1)<<grid.Bits-1))))
+}
+
+func (grid *quantisationGrid) dequantise(axis int, q uint64) float64 {
+	return grid.Origin[axis] + float64(q)*grid.Step[axis]
+}
+
+/* Octahedral encoding of unit vectors: the vector is projected onto the
+ * octahedron |x|+|y|+|z| = 1, whose lower half is folded over the upper half
+ * so that the whole surface maps onto the square [-1, 1]^2, which is then
+ * stored as a pair of snorm16 values.
+ */
+func octahedralEncode(x, y, z float64) (encoded [2]int16) {
+	l1 := math.Abs(x) + math.Abs(y) + math.Abs(z)
+	if l1 == 0 {
+		return
+	}
+	u, v := x/l1, y/l1
+	if z < 0 {
+		u, v = (1-math.Abs(v))*signNotZero(u), (1-math.Abs(u))*signNotZero(v)
+	}
+	encoded[0] = int16(math.Floor(u*math.MaxInt16 + 0.5))
+	encoded[1] = int16(math.Floor(v*math.MaxInt16 + 0.5))
+	return
+}
+
+func octahedralDecode(encoded [2]int16) geom.Vec3 {
+	u := math.Max(-1, float64(encoded[0])/math.MaxInt16)
+	v := math.Max(-1, float64(encoded[1])/math.MaxInt16)
+	z := 1 - math.Abs(u) - math.Abs(v)
+	if z < 0 {
+		u, v = (1-math.Abs(v))*signNotZero(u), (1-math.Abs(u))*signNotZero(v)
+	}
+	return normalize(geom.Vec3{u, v, z})
+}
+
+func signNotZero(f float64) float64 {
+	if f < 0 {
+		return -1
+	}
+	return 1
+}
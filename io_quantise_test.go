@@ -0,0 +1,65 @@
+package shapeset
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+func saveQuantised(t *testing.T, ss *ShapeSet, bits uint8) []byte {
+	var buf bytes.Buffer
+	w := io.Writer(&buf)
+	if err := ss.SaveBinaryQuantised(&w, bits); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestQuantisedRoundTrip(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	for _, bits := range []uint8{8, 16, 32} {
+		// positions are within half a step of the grid spanning the boxes
+		tolerance := 3 / float64(uint64(1)<<bits-1) / 2
+		loaded := loadBinaryBytes(t, saveQuantised(t, ss, bits))
+		checkShapeSetsMatch(t, ss, loaded, tolerance*1.0001)
+	}
+
+	var buf bytes.Buffer
+	w := io.Writer(&buf)
+	if err := ss.SaveBinaryQuantised(&w, 33); err == nil {
+		t.Errorf("Expected quantisation to more than 32 bits to be rejected")
+	}
+}
+
+func TestQuantisedRoundTripAfterScaleAndCenter(t *testing.T) {
+	// the boxes are moved to span -3 to 3 along x, beyond their original
+	// bounding box
+	ss := boxRowShapeSet(t)
+	ss.ScaleAndCenter(6)
+	loaded := loadBinaryBytes(t, saveQuantised(t, ss, 16))
+	checkShapeSetsMatch(t, ss, loaded, 6/float64(uint64(1)<<16-1))
+
+	min_x := math.Inf(1)
+	for _, m := range loaded.Meshes {
+		for i := 0; i < m.Vertices.Len(); i++ {
+			min_x = math.Min(min_x, m.Vertices.Get(i)[0].GetX())
+		}
+	}
+	if min_x != -3 {
+		t.Errorf("Expected the boxes to start at x of -3, got %g", min_x)
+	}
+}
+
+func TestOctahedralEncoding(t *testing.T) {
+	for _, n := range [][3]float64{
+		{1, 0, 0}, {0, -1, 0}, {0, 0, 1}, {0, 0, -1},
+		{0.6, 0, -0.8}, {-0.48, 0.6, 0.64}, {0.36, -0.48, -0.8},
+	} {
+		decoded := octahedralDecode(octahedralEncode(n[0], n[1], n[2]))
+		if math.Abs(decoded.X-n[0]) > 1e-4 || math.Abs(decoded.Y-n[1]) > 1e-4 ||
+			math.Abs(decoded.Z-n[2]) > 1e-4 {
+			t.Errorf("Expected %v to survive octahedral encoding, got %v", n, decoded)
+		}
+	}
+}
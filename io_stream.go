@@ -0,0 +1,383 @@
+package shapeset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+/* Streaming reader and writer for the shapeset json schema:
+ *
+ *   {
+ *     "version": int,
+ *     "name": string,
+ *     "shapes": {"<ShapeId>": string, ...},
+ *     "metadata": {
+ *       "<ShapeId>": {"color": [r, g, b, a], "extra": {string: string, ...}},
+ *       ...
+ *     },
+ *     "meshes": [
+ *       {
+ *         "name": "<MeshId>",
+ *         "verts": "x,y,z,...",
+ *         "norms": "x,y,z,...",
+ *         "faces": "a,b,c,...",
+ *         "borders": {"<BorderId>": "i,j,k,...", ...}
+ *       },
+ *       ...
+ *     ]
+ *   }
+ *
+ * Documents are walked token by token so that only one mesh is held in its
+ * serialized form at any time, and the numbers of its comma seperated strings
+ * are parsed straight from the raw json. Documents of older versions are
+ * upgraded as they're read, see io_migrations.go.
+ */
+
+type jsonStreamReader struct {
+	dec *json.Decoder
+}
+
+func newJSONStreamReader(r io.Reader) *jsonStreamReader {
+	return &jsonStreamReader{dec: json.NewDecoder(r)}
+}
+
+func (sr *jsonStreamReader) expectDelim(delim json.Delim) (err error) {
+	tok, err := sr.dec.Token()
+	if err != nil {
+		return
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		err = errors.New("Expected " + delim.String() + " in json stream")
+	}
+	return
+}
+
+func (sr *jsonStreamReader) readString() (s string, err error) {
+	tok, err := sr.dec.Token()
+	if err != nil {
+		return
+	}
+	s, ok := tok.(string)
+	if !ok {
+		err = errors.New("Expected string in json stream")
+	}
+	return
+}
+
+// Discards the next value in the stream, whatever its type.
+func (sr *jsonStreamReader) skip() error {
+	var discard json.RawMessage
+	return sr.dec.Decode(&discard)
+}
+
+// Calls cb with each key of the object at the current position of the stream,
+// cb is responsible for consuming the corresponding value.
+func (sr *jsonStreamReader) eachKey(cb func(key string) error) (err error) {
+	if err = sr.expectDelim('{'); err != nil {
+		return
+	}
+	for sr.dec.More() {
+		var key string
+		if key, err = sr.readString(); err != nil {
+			return
+		}
+		if err = cb(key); err != nil {
+			return
+		}
+	}
+	return sr.expectDelim('}')
+}
+
+// Calls cb once for each element of the array at the current position of the
+// stream, cb is responsible for consuming the element.
+func (sr *jsonStreamReader) eachElement(cb func() error) (err error) {
+	if err = sr.expectDelim('['); err != nil {
+		return
+	}
+	for sr.dec.More() {
+		if err = cb(); err != nil {
+			return
+		}
+	}
+	return sr.expectDelim(']')
+}
+
+// Calls cb with the bytes of each comma seperated number in the string at the
+// current position of the stream, scanning the raw json in place rather than
+// unquoting and splitting it. An empty string has no numbers.
+func (sr *jsonStreamReader) eachCSNumber(cb func(num []byte) error) (err error) {
+	var raw json.RawMessage
+	if err = sr.dec.Decode(&raw); err != nil {
+		return
+	}
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		return errors.New("Expected string in json stream")
+	}
+	body := raw[1 : len(raw)-1]
+	if bytes.IndexByte(body, '\\') >= 0 {
+		// numbers never need escaping, but are still valid if they were
+		var unquoted string
+		if err = json.Unmarshal(raw, &unquoted); err != nil {
+			return
+		}
+		body = []byte(unquoted)
+	}
+	if len(body) == 0 {
+		return
+	}
+	start := 0
+	for i := 0; i <= len(body); i++ {
+		if i == len(body) || body[i] == ',' {
+			if err = cb(body[start:i]); err != nil {
+				return
+			}
+			start = i + 1
+		}
+	}
+	return
+}
+
+// Reads a string of comma seperated floats from the stream
+func (sr *jsonStreamReader) readCSFloats() (floats []float64, err error) {
+	floats = make([]float64, 0)
+	err = sr.eachCSNumber(func(num []byte) (err error) {
+		f, err := strconv.ParseFloat(string(num), 64)
+		if err != nil {
+			return errors.New("Could not parse float64 from: " + string(num))
+		}
+		floats = append(floats, f)
+		return
+	})
+	return
+}
+
+// Reads a string of comma seperated ints from the stream
+func (sr *jsonStreamReader) readCSInts() (ints []int, err error) {
+	ints = make([]int, 0)
+	err = sr.eachCSNumber(func(num []byte) (err error) {
+		i, ok := parseIntBytes(num)
+		if !ok {
+			return errors.New("Could not parse int from: " + string(num))
+		}
+		ints = append(ints, i)
+		return
+	})
+	return
+}
+
+// Parses an optionally negative decimal integer of up to 18 digits
+func parseIntBytes(num []byte) (i int, ok bool) {
+	negative := len(num) > 0 && num[0] == '-'
+	if negative {
+		num = num[1:]
+	}
+	if len(num) == 0 || len(num) > 18 {
+		return
+	}
+	for _, c := range num {
+		if c < '0' || c > '9' {
+			return
+		}
+		i = i*10 + int(c-'0')
+	}
+	if negative {
+		i = -i
+	}
+	return i, true
+}
+
+// Reads one mesh object from the stream and builds it, registering any border
+// vertices it lists with border_tracker.
+func (sr *jsonStreamReader) readMesh(border_tracker borderTracker) (m *Mesh, err error) {
+	var mesh_name string
+	var verts, norms []float64
+	var faces []int
+	borders := make(map[string][]int)
+	err = sr.eachKey(func(key string) (err error) {
+		switch key {
+		case "name":
+			mesh_name, err = sr.readString()
+		case "verts":
+			if verts, err = sr.readCSFloats(); err != nil {
+				err = errors.New("Could not parse vertices: " + err.Error())
+			}
+		case "norms":
+			// Vertex normals are optional
+			if norms, err = sr.readCSFloats(); err != nil {
+				err = errors.New("Could not parse normals: " + err.Error())
+			}
+		case "faces":
+			if faces, err = sr.readCSInts(); err != nil {
+				err = errors.New("Could not parse faces: " + err.Error())
+			}
+		case "borders":
+			err = sr.eachKey(func(border_id string) (err error) {
+				if borders[border_id], err = sr.readCSInts(); err != nil {
+					err = errors.New("Could not parse indices for border " +
+						border_id + ": " + err.Error())
+				}
+				return
+			})
+		default:
+			err = sr.skip()
+		}
+		return
+	})
+	if err != nil {
+		err = errors.New("Could not parse json from ss_reader: " + err.Error())
+		return
+	}
+
+	m, vertexBuffer, err := buildMesh(mesh_name, verts, norms, faces)
+	if err != nil {
+		return
+	}
+
+	for border_id, vert_indices := range borders {
+		var bid BorderId
+		bid, err = BorderIdFromString(border_id)
+		if err != nil {
+			return
+		}
+		err = border_tracker.track(bid, mesh_name, vert_indices, vertexBuffer)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Wraps a buffered writer so that the first error encountered sticks and
+// further writes are skipped.
+type jsonStreamWriter struct {
+	w       *bufio.Writer
+	scratch []byte
+	err     error
+}
+
+func newJSONStreamWriter(w io.Writer) *jsonStreamWriter {
+	return &jsonStreamWriter{w: bufio.NewWriter(w)}
+}
+
+func (sw *jsonStreamWriter) raw(s string) {
+	if sw.err == nil {
+		_, sw.err = sw.w.WriteString(s)
+	}
+}
+
+func (sw *jsonStreamWriter) string(s string) {
+	if sw.err != nil {
+		return
+	}
+	var quoted []byte
+	quoted, sw.err = json.Marshal(s)
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(quoted)
+	}
+}
+
+func (sw *jsonStreamWriter) float(f float64) {
+	if sw.err == nil {
+		sw.scratch = strconv.AppendFloat(sw.scratch[:0], f, 'g', -1, 64)
+		_, sw.err = sw.w.Write(sw.scratch)
+	}
+}
+
+func (sw *jsonStreamWriter) int(i int) {
+	if sw.err == nil {
+		sw.scratch = strconv.AppendInt(sw.scratch[:0], int64(i), 10)
+		_, sw.err = sw.w.Write(sw.scratch)
+	}
+}
+
+func (sw *jsonStreamWriter) flush() error {
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.err
+}
+
+// Writes one mesh object, serializing numbers directly from the mesh rather
+// than building up intermediate strings.
+func (sw *jsonStreamWriter) writeMesh(m *Mesh) {
+	m.ReindexVerticesAndFaces()
+	m.Vertices.EnsureNormals()
+	vert_count := m.Vertices.Len()
+
+	mesh_id := m.Id()
+	sw.raw(`{"name":`)
+	sw.string(mesh_id.ToString())
+
+	sw.raw(`,"verts":"`)
+	for i := 0; i < vert_count; i++ {
+		v := m.Vertices.Get(i)[0]
+		if i > 0 {
+			sw.raw(",")
+		}
+		sw.float(v.GetX())
+		sw.raw(",")
+		sw.float(v.GetY())
+		sw.raw(",")
+		sw.float(v.GetZ())
+	}
+
+	// normals are only written if every vertex has one
+	has_normals := true
+	for i := 0; i < vert_count && has_normals; i++ {
+		has_normals = m.Vertices.Get(i)[0].GetNormal() != nil
+	}
+	sw.raw(`","norms":"`)
+	for i := 0; i < vert_count && has_normals; i++ {
+		n := m.Vertices.Get(i)[0].GetNormal()
+		if i > 0 {
+			sw.raw(",")
+		}
+		sw.float(n.X)
+		sw.raw(",")
+		sw.float(n.Y)
+		sw.raw(",")
+		sw.float(n.Z)
+	}
+
+	sw.raw(`","faces":"`)
+	for i := 0; i < m.Faces.Len(); i++ {
+		f := m.Faces.Get(i)[0]
+		if i > 0 {
+			sw.raw(",")
+		}
+		sw.int(f.GetA().GetLocationInMesh(m))
+		sw.raw(",")
+		sw.int(f.GetB().GetLocationInMesh(m))
+		sw.raw(",")
+		sw.int(f.GetC().GetLocationInMesh(m))
+	}
+
+	sw.raw(`","borders":{`)
+	border_ids := make([]int, 0, len(m.Borders))
+	for border_id, _ := range m.Borders {
+		border_ids = append(border_ids, int(border_id))
+	}
+	sort.Ints(border_ids)
+	for bi, bid := range border_ids {
+		border_id := BorderId(bid)
+		border := m.Borders[border_id]
+		if bi > 0 {
+			sw.raw(",")
+		}
+		sw.string(border_id.ToString())
+		sw.raw(`:"`)
+		for i := 0; i < border.Len(); i++ {
+			if i > 0 {
+				sw.raw(",")
+			}
+			sw.int(border.Vertices[i].GetLocationInMesh(m))
+		}
+		sw.raw(`"`)
+	}
+	sw.raw("}}")
+}
@@ -0,0 +1,78 @@
+package shapeset
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func saveJSON(t *testing.T, ss *ShapeSet) []byte {
+	var buf bytes.Buffer
+	w := io.Writer(&buf)
+	if err := ss.Save(&w); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func loadJSON(t *testing.T, data string) (ss *ShapeSet, err error) {
+	r := io.Reader(strings.NewReader(data))
+	return Load(&r)
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	loaded, err := loadJSON(t, string(saveJSON(t, ss)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkShapeSetsMatch(t, ss, loaded, 0)
+}
+
+func TestSaveIsDeterministic(t *testing.T) {
+	first := saveJSON(t, boxRowShapeSet(t))
+	for run := 0; run < 5; run++ {
+		if again := saveJSON(t, boxRowShapeSet(t)); !bytes.Equal(again, first) {
+			t.Fatalf("Saving the same shapeset gave different json:\n%s\n%s", first, again)
+		}
+	}
+	doc := string(first)
+	if !strings.Contains(doc, `"shapes":{"1":"left","2":"middle","3":"right"}`) {
+		t.Errorf("Expected shapes in order of id, got %s", doc)
+	}
+	previous := -1
+	for _, mesh_name := range []string{"0-1", "0-2", "0-3", "1-2", "2-3"} {
+		index := strings.Index(doc, `{"name":"`+mesh_name+`"`)
+		if index < previous {
+			t.Errorf("Expected mesh %s to follow the meshes before it", mesh_name)
+		}
+		previous = index
+	}
+}
+
+func TestLoadParsesNumbersInPlace(t *testing.T) {
+	// escaped characters are still read, and a mesh may be empty
+	doc := `{"version":2,"name":"tri","shapes":{"1":"a"},"meshes":[` +
+		`{"name":"0-1","verts":"0,0,0,1,0,0,0,1,\u0030","faces":"0,1,2","borders":{}},` +
+		`{"name":"0-2","verts":"","faces":"","borders":{}}]}`
+	ss, err := loadJSON(t, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ss.Meshes[MeshId{0, 1}]
+	if m.Vertices.Len() != 3 || m.Faces.Len() != 1 || m.Vertices.Get(1)[0].GetX() != 1 {
+		t.Errorf("Expected one triangle, got %d vertices and %d faces", m.Vertices.Len(), m.Faces.Len())
+	}
+	if ss.Meshes[MeshId{0, 2}].Vertices.Len() != 0 {
+		t.Errorf("Expected an empty mesh")
+	}
+
+	for _, faces := range []string{"0,1,x", "0,,1", "0,1,2,", "1234567890123456789"} {
+		doc := `{"version":2,"name":"tri","shapes":{},"meshes":[` +
+			`{"name":"0-1","verts":"0,0,0,1,0,0,0,1,0","faces":"` + faces + `","borders":{}}]}`
+		if _, err := loadJSON(t, doc); err == nil || !strings.Contains(err.Error(), "Could not parse faces") {
+			t.Errorf("Expected faces %q to be rejected, got %v", faces, err)
+		}
+	}
+}
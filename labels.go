@@ -0,0 +1,438 @@
+package shapeset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Optional information about a shape besides its label, as read from a label
+// table. Extra holds any columns or fields which have no other meaning.
+type ShapeMetadata struct {
+	Color *Color            `json:"color,omitempty"`
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+/* The shapes described by a label table file. Labels maps shape id strings
+ * onto labels as accepted by New, and Metadata holds the colors and extra
+ * columns of any shapes which have them.
+ */
+type LabelTable struct {
+	Labels   map[string]string
+	Metadata map[ShapeId]*ShapeMetadata
+}
+
+func newLabelTable() *LabelTable {
+	return &LabelTable{
+		Labels:   make(map[string]string),
+		Metadata: make(map[ShapeId]*ShapeMetadata),
+	}
+}
+
+func (table *LabelTable) add(shape_id ShapeId, label string, metadata *ShapeMetadata) error {
+	if _, exists := table.Labels[shape_id.ToString()]; exists {
+		return errors.New("Duplicate label for shape " + shape_id.ToString())
+	}
+	table.Labels[shape_id.ToString()] = label
+	if metadata != nil && (metadata.Color != nil || len(metadata.Extra) > 0) {
+		table.Metadata[shape_id] = metadata
+	}
+	return nil
+}
+
+// Sets the labels and metadata of the shapeset from the label table, keeping
+// any existing labels of shapes the table doesn't mention.
+func (ss *ShapeSet) ApplyLabelTable(table *LabelTable) {
+	for shape_id_str, label := range table.Labels {
+		ss.Shapes[ShapeIdFromString(shape_id_str)] = label
+	}
+	for shape_id, metadata := range table.Metadata {
+		ss.Metadata[shape_id] = metadata
+	}
+}
+
+/* Reads a label table, in any of the following formats:
+ *
+ *  - json (.json): an object mapping shape id strings onto either a label, or
+ *    an object with a "label" (or "name") string and optionally a "color",
+ *    given as "#rrggbb", "#rrggbbaa" or an array of 3 or 4 components in
+ *    [0, 1]. Any other fields are kept as extra metadata.
+ *  - csv (.csv): a header row naming the columns, which must include "id" and
+ *    "label" (or "name"), and may include "r", "g", "b" and "a" components in
+ *    [0, 255]. Any other columns are kept as extra metadata.
+ *  - ITK-SNAP label descriptions: rows of IDX R G B A VIS MSH "LABEL" with A in
+ *    [0, 1], keeping VIS and MSH as the extra metadata "visible" and
+ *    "mesh_visible".
+ *  - FreeSurfer color LUTs: rows of id, label, R, G, B and A, where A is the
+ *    transparency in [0, 255].
+ *
+ * Other files are treated as ITK-SNAP or FreeSurfer tables according to
+ * whether their rows have quoted labels. Lines starting with # are comments.
+ */
+func ReadLabelTable(labels_path string) (table *LabelTable, err error) {
+	label_data, err := ioutil.ReadFile(labels_path)
+	if err != nil {
+		return
+	}
+
+	switch strings.ToLower(filepath.Ext(labels_path)) {
+	case ".json":
+		table, err = parseJSONLabelTable(label_data)
+	case ".csv":
+		table, err = parseCSVLabelTable(label_data)
+	default:
+		table, err = parseTextLabelTable(label_data)
+	}
+	if err != nil {
+		err = errors.New("Could not read label table " + labels_path + ": " + err.Error())
+	}
+	return
+}
+
+/* Loads labels from a label table file in any of the formats accepted by
+ * ReadLabelTable, as a map of shape id strings onto shape names.
+ */
+func ReadLabelsFile(labels_path string) (labels map[string]string, err error) {
+	table, err := ReadLabelTable(labels_path)
+	if err != nil {
+		return
+	}
+	labels = table.Labels
+	return
+}
+
+func parseJSONLabelTable(label_data []byte) (table *LabelTable, err error) {
+	var entries map[string]json.RawMessage
+	if err = json.Unmarshal(label_data, &entries); err != nil {
+		err = errors.New("Expected a json object: " + err.Error())
+		return
+	}
+
+	// process entries in a consistent order so that errors are reproducible
+	keys := make([]string, 0, len(entries))
+	for key, _ := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	table = newLabelTable()
+	for _, key := range keys {
+		shape_num, e := strconv.Atoi(key)
+		if e != nil {
+			err = errors.New("Invalid shape id: " + key)
+			return
+		}
+		shape_id := ShapeId(shape_num)
+
+		// entries are either a plain label or an object, null is neither though
+		// json.Unmarshal accepts it as both
+		if isJSONNull(entries[key]) {
+			err = errors.New("Entry for shape " + key + " must be a string or an object")
+			return
+		}
+		var label string
+		if json.Unmarshal(entries[key], &label) == nil {
+			if err = table.add(shape_id, label, nil); err != nil {
+				return
+			}
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(entries[key], &fields) != nil {
+			err = errors.New("Entry for shape " + key + " must be a string or an object")
+			return
+		}
+
+		metadata := &ShapeMetadata{Extra: make(map[string]string)}
+		has_label := false
+		for field, value := range fields {
+			switch field {
+			case "label", "name":
+				if isJSONNull(value) || json.Unmarshal(value, &label) != nil {
+					err = errors.New("Label of shape " + key + " must be a string")
+					return
+				}
+				has_label = true
+			case "color", "colour":
+				metadata.Color, err = parseJSONColor(value)
+				if err != nil {
+					err = errors.New("Invalid color for shape " + key + ": " + err.Error())
+					return
+				}
+			default:
+				var text string
+				if json.Unmarshal(value, &text) != nil {
+					text = string(value)
+				}
+				metadata.Extra[field] = text
+			}
+		}
+		if !has_label {
+			err = errors.New("Entry for shape " + key + " has no label")
+			return
+		}
+		if err = table.add(shape_id, label, metadata); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Whether a raw json value is null
+func isJSONNull(value json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(value), []byte("null"))
+}
+
+func parseJSONColor(value json.RawMessage) (color *Color, err error) {
+	var hex string
+	if json.Unmarshal(value, &hex) == nil {
+		return parseHexColor(hex)
+	}
+	var components []float64
+	if err = json.Unmarshal(value, &components); err != nil {
+		err = errors.New("expected a hex string or an array of numbers")
+		return
+	}
+	if len(components) != 3 && len(components) != 4 {
+		err = errors.New("expected 3 or 4 components")
+		return
+	}
+	color = &Color{0, 0, 0, 1}
+	for i, c := range components {
+		if c < 0 || c > 1 {
+			err = errors.New("components must be between 0 and 1")
+			return
+		}
+		color[i] = c
+	}
+	return
+}
+
+// Parses a color in the form #rrggbb or #rrggbbaa
+func parseHexColor(hex string) (color *Color, err error) {
+	digits := strings.TrimPrefix(hex, "#")
+	if len(digits) != 6 && len(digits) != 8 {
+		err = errors.New("expected #rrggbb or #rrggbbaa, not " + hex)
+		return
+	}
+	color = &Color{0, 0, 0, 1}
+	for i := 0; i < len(digits)/2; i++ {
+		channel, e := strconv.ParseUint(digits[i*2:i*2+2], 16, 8)
+		if e != nil {
+			err = errors.New("expected #rrggbb or #rrggbbaa, not " + hex)
+			return
+		}
+		color[i] = float64(channel) / 255
+	}
+	return
+}
+
+// Parses a color component in [0, max]
+func parseColorComponent(s string, max float64) (c float64, err error) {
+	c, err = strconv.ParseFloat(s, 64)
+	if err != nil || c < 0 || c > max {
+		err = errors.New("invalid color component: " + s)
+		return
+	}
+	c /= max
+	return
+}
+
+func parseCSVLabelTable(label_data []byte) (table *LabelTable, err error) {
+	r := csv.NewReader(bytes.NewReader(label_data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		err = errors.New("Missing header row")
+		return
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	id_column, has_id := columns["id"]
+	label_column, has_label := columns["label"]
+	if !has_label {
+		label_column, has_label = columns["name"]
+	}
+	if !has_id || !has_label {
+		err = errors.New("Header row must name id and label columns")
+		return
+	}
+	_, has_color := columns["r"]
+
+	table = newLabelTable()
+	for {
+		var record []string
+		record, err = r.Read()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		line, _ := r.FieldPos(0)
+		row_err := func(reason string) error {
+			return errors.New("Malformed row on line " + strconv.Itoa(line) + ": " + reason)
+		}
+		if err != nil {
+			err = row_err(err.Error())
+			return
+		}
+
+		shape_num, e := strconv.Atoi(strings.TrimSpace(record[id_column]))
+		if e != nil {
+			err = row_err("invalid shape id " + record[id_column])
+			return
+		}
+
+		metadata := &ShapeMetadata{Extra: make(map[string]string)}
+		if has_color {
+			metadata.Color = &Color{0, 0, 0, 1}
+		}
+		for name, i := range columns {
+			value := strings.TrimSpace(record[i])
+			switch name {
+			case "id", "label", "name":
+				if i == id_column || i == label_column {
+					continue
+				}
+				metadata.Extra[name] = value
+			case "r", "g", "b", "a":
+				if !has_color {
+					metadata.Extra[name] = value
+					continue
+				}
+				channel := strings.Index("rgba", name)
+				if metadata.Color[channel], e = parseColorComponent(value, 255); e != nil {
+					err = row_err(e.Error())
+					return
+				}
+			default:
+				metadata.Extra[name] = value
+			}
+		}
+
+		if err = table.add(ShapeId(shape_num), strings.TrimSpace(record[label_column]), metadata); err != nil {
+			err = row_err(err.Error())
+			return
+		}
+	}
+}
+
+// Parses FreeSurfer LUT and ITK-SNAP label description files, which are both
+// whitespace delimited tables with comments.
+func parseTextLabelTable(label_data []byte) (table *LabelTable, err error) {
+	table = newLabelTable()
+	scanner := bufio.NewScanner(bytes.NewReader(label_data))
+	line_number := 0
+	for scanner.Scan() {
+		line_number++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		row_err := func(reason string) error {
+			return errors.New("Malformed row on line " + strconv.Itoa(line_number) + ": " + reason)
+		}
+
+		var shape_id ShapeId
+		var label string
+		var metadata *ShapeMetadata
+		var e error
+		if quote := strings.Index(line, `"`); quote >= 0 {
+			shape_id, label, metadata, e = parseITKSNAPRow(line, quote)
+		} else {
+			shape_id, label, metadata, e = parseFreeSurferRow(line)
+		}
+		if e != nil {
+			err = row_err(e.Error())
+			return
+		}
+		if err = table.add(shape_id, label, metadata); err != nil {
+			err = row_err(err.Error())
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// Parses a row of the form: IDX R G B A VIS MSH "LABEL"
+func parseITKSNAPRow(line string, quote int) (
+	shape_id ShapeId,
+	label string,
+	metadata *ShapeMetadata,
+	err error,
+) {
+	fields := strings.Fields(line[:quote])
+	if len(fields) != 7 || !strings.HasSuffix(line, `"`) || len(line) == quote+1 {
+		err = errors.New(`expected IDX R G B A VIS MSH "LABEL"`)
+		return
+	}
+	label = line[quote+1 : len(line)-1]
+
+	shape_num, err := strconv.Atoi(fields[0])
+	if err != nil {
+		err = errors.New("invalid shape id " + fields[0])
+		return
+	}
+	shape_id = ShapeId(shape_num)
+
+	metadata = &ShapeMetadata{
+		Color: &Color{},
+		Extra: map[string]string{"visible": fields[5], "mesh_visible": fields[6]},
+	}
+	for i := 0; i < 4; i++ {
+		max := 255.0
+		if i == 3 {
+			max = 1
+		}
+		if metadata.Color[i], err = parseColorComponent(fields[i+1], max); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Parses a row of the form: id label R G B A, where A is the transparency
+func parseFreeSurferRow(line string) (
+	shape_id ShapeId,
+	label string,
+	metadata *ShapeMetadata,
+	err error,
+) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 6 {
+		err = errors.New("expected id label R G B A")
+		return
+	}
+
+	shape_num, err := strconv.Atoi(fields[0])
+	if err != nil {
+		err = errors.New("invalid shape id " + fields[0])
+		return
+	}
+	shape_id = ShapeId(shape_num)
+	label = fields[1]
+	if len(fields) == 2 {
+		return
+	}
+
+	metadata = &ShapeMetadata{Color: &Color{}}
+	for i := 0; i < 4; i++ {
+		if metadata.Color[i], err = parseColorComponent(fields[i+2], 255); err != nil {
+			return
+		}
+	}
+	metadata.Color[3] = 1 - metadata.Color[3]
+	return
+}
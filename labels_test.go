@@ -0,0 +1,177 @@
+package shapeset
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLabelTable(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkColor(t *testing.T, shape_id ShapeId, expected Color, actual *Color) {
+	if actual == nil {
+		t.Errorf("Expected shape %d to have the color %v, got none", shape_id, expected)
+		return
+	}
+	for i := range expected {
+		if math.Abs(expected[i]-actual[i]) > 1e-9 {
+			t.Errorf("Expected shape %d to have the color %v, got %v", shape_id, expected, *actual)
+			return
+		}
+	}
+}
+
+func TestReadJSONLabelTable(t *testing.T) {
+	table, err := ReadLabelTable(writeLabelTable(t, "labels.json", `{
+		"1": "left",
+		"2": {"label": "middle", "color": "#ff800080", "abbr": "M", "order": 2},
+		"3": {"name": "right", "colour": [0, 0, 1]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for shape_id, label := range boxRowLabels {
+		if table.Labels[shape_id] != label {
+			t.Errorf("Expected shape %s to be labelled %s, got %s", shape_id, label, table.Labels[shape_id])
+		}
+	}
+	if len(table.Metadata) != 2 || table.Metadata[1] != nil {
+		t.Errorf("Expected metadata for shapes 2 and 3 only, got %v", table.Metadata)
+	}
+	checkColor(t, 2, Color{1, 128.0 / 255, 0, 128.0 / 255}, table.Metadata[2].Color)
+	checkColor(t, 3, Color{0, 0, 1, 1}, table.Metadata[3].Color)
+	if extra := table.Metadata[2].Extra; len(extra) != 2 || extra["abbr"] != "M" || extra["order"] != "2" {
+		t.Errorf("Expected the extra fields of shape 2, got %v", extra)
+	}
+}
+
+func TestReadJSONLabelTableRejectsInvalidEntries(t *testing.T) {
+	for contents, message := range map[string]string{
+		`["left"]`:                                  "Expected a json object",
+		`{"one": "left"}`:                           "Invalid shape id: one",
+		`{"5": null}`:                               "Entry for shape 5 must be a string or an object",
+		`{"5": 7}`:                                  "Entry for shape 5 must be a string or an object",
+		`{"5": {"label": null}}`:                    "Label of shape 5 must be a string",
+		`{"5": {"color": "#ffffff"}}`:               "Entry for shape 5 has no label",
+		`{"5": {"label": "a", "color": 1}}`:         "Invalid color for shape 5",
+		`{"5": {"label": "a", "color": [2, 0, 0]}}`: "components must be between 0 and 1",
+		`{"5": {"label": "a", "color": "#fff"}}`:    "expected #rrggbb or #rrggbbaa",
+	} {
+		_, err := ReadLabelTable(writeLabelTable(t, "labels.json", contents))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected %s to fail with %q, got %v", contents, message, err)
+		}
+	}
+}
+
+func TestReadCSVLabelTable(t *testing.T) {
+	table, err := ReadLabelTable(writeLabelTable(t, "labels.CSV",
+		"# boxes\nID, Name, r, g, b, a, abbr\n1, left, 255, 0, 0, 255, L\n2, \"mid, box\", 0, 51, 0, 0, M\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Labels) != 2 || table.Labels["1"] != "left" || table.Labels["2"] != "mid, box" {
+		t.Errorf("Expected the labels of 2 shapes, got %v", table.Labels)
+	}
+	checkColor(t, 1, Color{1, 0, 0, 1}, table.Metadata[1].Color)
+	checkColor(t, 2, Color{0, 0.2, 0, 0}, table.Metadata[2].Color)
+	if extra := table.Metadata[2].Extra; len(extra) != 1 || extra["abbr"] != "M" {
+		t.Errorf("Expected the extra columns of shape 2, got %v", extra)
+	}
+
+	for contents, message := range map[string]string{
+		"":                              "Missing header row",
+		"id,colour\n1,red\n":            "Header row must name id and label columns",
+		"id,label\none,left\n":          "Malformed row on line 2: invalid shape id one",
+		"id,label,r,g,b\n1,a,0,0,256\n": "Malformed row on line 2: invalid color component: 256",
+		"id,label\n1,a\n1,b\n":          "Malformed row on line 3: Duplicate label for shape 1",
+	} {
+		_, err := ReadLabelTable(writeLabelTable(t, "labels.csv", contents))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected %q to fail with %q, got %v", contents, message, err)
+		}
+	}
+}
+
+func TestReadITKSNAPLabelTable(t *testing.T) {
+	table, err := ReadLabelTable(writeLabelTable(t, "labels.txt", `
+# ITK-SNAP Label Description File
+    0     0    0    0        0  0  0    "Clear Label"
+    2   255  102    0      0.5  1  0    "middle box"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Labels["0"] != "Clear Label" || table.Labels["2"] != "middle box" {
+		t.Errorf("Expected quoted labels with spaces, got %v", table.Labels)
+	}
+	checkColor(t, 2, Color{1, 0.4, 0, 0.5}, table.Metadata[2].Color)
+	if extra := table.Metadata[2].Extra; extra["visible"] != "1" || extra["mesh_visible"] != "0" {
+		t.Errorf("Expected the visibility of shape 2 to be kept, got %v", extra)
+	}
+
+	_, err = ReadLabelTable(writeLabelTable(t, "labels.txt", `2 255 102 0 0.5 1 "middle"`))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected a row missing a column to be rejected, got %v", err)
+	}
+}
+
+func TestReadFreeSurferLabelTable(t *testing.T) {
+	table, err := ReadLabelTable(writeLabelTable(t, "FreeSurferColorLUT.txt", `
+#No. Label Name:       R   G   B   A
+1    Left-Box          255 0   0   0
+2    Middle-Box        0   255 0   51
+3    Right-Box
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Labels) != 3 || table.Labels["1"] != "Left-Box" || table.Labels["3"] != "Right-Box" {
+		t.Errorf("Expected the labels of 3 shapes, got %v", table.Labels)
+	}
+	// A is the transparency rather than the opacity
+	checkColor(t, 1, Color{1, 0, 0, 1}, table.Metadata[1].Color)
+	checkColor(t, 2, Color{0, 1, 0, 0.8}, table.Metadata[2].Color)
+	if table.Metadata[3] != nil {
+		t.Errorf("Expected no metadata for a row without a color, got %+v", table.Metadata[3])
+	}
+
+	_, err = ReadLabelTable(writeLabelTable(t, "labels.lut", "1 Left-Box 255 0 0\n"))
+	if err == nil || !strings.Contains(err.Error(), "expected id label R G B A") {
+		t.Errorf("Expected a row missing a column to be rejected, got %v", err)
+	}
+}
+
+func TestApplyLabelTable(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	table, err := ReadLabelTable(writeLabelTable(t, "labels.json",
+		`{"2": {"label": "centre", "color": "#00ff00", "abbr": "C"}, "4": "top"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.ApplyLabelTable(table)
+	if ss.Shapes[1] != "left" || ss.Shapes[2] != "centre" || ss.Shapes[4] != "top" {
+		t.Errorf("Expected labels to be replaced or added, got %v", ss.Shapes)
+	}
+
+	// metadata survives being written to either format
+	loaded, err := loadJSON(t, string(saveJSON(t, ss)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, loaded := range []*ShapeSet{loaded, loadBinaryBytes(t, saveQuantised(t, ss, 16))} {
+		metadata := loaded.Metadata[2]
+		if metadata == nil || metadata.Extra["abbr"] != "C" {
+			t.Fatalf("Expected the metadata of shape 2 to be kept, got %+v", metadata)
+		}
+		checkColor(t, 2, Color{0, 1, 0, 1}, metadata.Color)
+	}
+}
@@ -0,0 +1,189 @@
+package shapeset
+
+import (
+	"bufio"
+	"errors"
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* Mesh file formats are selected by file extension. Each format provides a
+ * reader and a writer, formats which can't be read leave Read nil. Formats
+ * whose reader merges or reorders vertices set ReordersVertices, as vertices
+ * can't then be matched to those of a mesh by index.
+ */
+type MeshFormat struct {
+	Name             string
+	Extension        string
+	Read             func(mesh_file_path string) (*gomesh.Mesh, error)
+	Write            func(m *gomesh.Mesh, w io.Writer) error
+	ReordersVertices bool
+}
+
+var meshFormats = []*MeshFormat{
+	{
+		Name:      "obj",
+		Extension: ".obj",
+		Read:      gomesh.ReadOBJFile,
+		Write: func(m *gomesh.Mesh, w io.Writer) error {
+			m.WriteOBJ(w)
+			return nil
+		},
+	},
+	{
+		Name:      "ply",
+		Extension: ".ply",
+		Read:      ReadPLYFile,
+		Write: func(m *gomesh.Mesh, w io.Writer) error {
+			return WritePLY(m, w, false)
+		},
+	},
+	{
+		Name:      "ply-ascii",
+		Extension: ".ply",
+		Read:      ReadPLYFile,
+		Write: func(m *gomesh.Mesh, w io.Writer) error {
+			return WritePLY(m, w, true)
+		},
+	},
+	{
+		Name:             "stl",
+		Extension:        ".stl",
+		Read:             ReadSTLFile,
+		Write:            WriteSTL,
+		ReordersVertices: true,
+	},
+}
+
+// Registers an additional mesh format, taking precedence over any existing
+// format with the same name or extension.
+func RegisterMeshFormat(format *MeshFormat) {
+	meshFormats = append([]*MeshFormat{format}, meshFormats...)
+}
+
+// Looks up a mesh format by name, e.g. "obj" or "ply-ascii"
+func MeshFormatNamed(name string) (format *MeshFormat, err error) {
+	for _, f := range meshFormats {
+		if f.Name == name {
+			format = f
+			return
+		}
+	}
+	err = errors.New("Unknown mesh format: " + name)
+	return
+}
+
+// Looks up the mesh format to use for a path by its file extension
+func MeshFormatFor(mesh_file_path string) (format *MeshFormat, err error) {
+	ext := strings.ToLower(filepath.Ext(mesh_file_path))
+	for _, f := range meshFormats {
+		if f.Extension == ext {
+			format = f
+			return
+		}
+	}
+	err = errors.New("Unsupported mesh file type: " + mesh_file_path)
+	return
+}
+
+// Lists the distinct file extensions of readable mesh formats in order of
+// precedence
+func meshFileExtensions() (extensions []string) {
+	for _, f := range meshFormats {
+		if f.Read != nil && !stringInSlice(f.Extension, extensions) {
+			extensions = append(extensions, f.Extension)
+		}
+	}
+	return
+}
+
+// Reads a mesh from a file in the format implied by its extension
+func ReadMeshFile(mesh_file_path string) (m *gomesh.Mesh, err error) {
+	format, err := MeshFormatFor(mesh_file_path)
+	if err != nil {
+		return
+	}
+	if format.Read == nil {
+		err = errors.New("Reading is not supported for mesh format: " + format.Name)
+		return
+	}
+	return format.Read(mesh_file_path)
+}
+
+// Writes a mesh to a file in the format implied by its extension
+func WriteMeshFile(m *gomesh.Mesh, mesh_file_path string) (err error) {
+	format, err := MeshFormatFor(mesh_file_path)
+	if err != nil {
+		return
+	}
+	return format.WriteFile(m, mesh_file_path)
+}
+
+func (format *MeshFormat) WriteFile(m *gomesh.Mesh, mesh_file_path string) (err error) {
+	output_file, err := os.Create(mesh_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	buffered_output := bufio.NewWriter(output_file)
+	if err = format.Write(m, buffered_output); err != nil {
+		return
+	}
+	return buffered_output.Flush()
+}
+
+// Builds a plain gomesh Mesh from flat buffers of positions and triangle
+// vertex indices, as produced by the format readers.
+func meshFromBuffers(name string, positions []geom.Vec3, triangles [][3]int) (m *gomesh.Mesh, err error) {
+	m = gomesh.New(name)
+	vertexBuffer := make([]gomesh.VertexI, len(positions))
+	for i, p := range positions {
+		vertexBuffer[i] = &gomesh.Vertex{
+			Vec3:   p,
+			Meshes: make(map[gomesh.Mesh]int),
+		}
+	}
+	faceBuffer := make([]gomesh.FaceI, 0, len(triangles))
+	for _, t := range triangles {
+		for _, vi := range t {
+			if vi < 0 || vi >= len(vertexBuffer) {
+				err = errors.New("Face references missing vertex in mesh: " + name)
+				return
+			}
+		}
+		faceBuffer = append(faceBuffer, &gomesh.Face{Vertices: [3]gomesh.VertexI{
+			vertexBuffer[t[0]],
+			vertexBuffer[t[1]],
+			vertexBuffer[t[2]],
+		}})
+	}
+	m.Vertices.Append(vertexBuffer...)
+	m.Faces.Append(faceBuffer...)
+	m.ReindexVerticesAndFaces()
+	return
+}
+
+// Flattens a mesh into buffers of positions and triangle vertex indices, as
+// consumed by the format writers.
+func meshToBuffers(m *gomesh.Mesh) (positions []geom.Vec3, triangles [][3]int) {
+	vertex_indices := make(map[gomesh.VertexI]int, m.Vertices.Len())
+	positions = make([]geom.Vec3, m.Vertices.Len())
+	for i := 0; i < m.Vertices.Len(); i++ {
+		v := m.Vertices.Get(i)[0]
+		vertex_indices[v] = i
+		positions[i] = geom.Vec3{v.GetX(), v.GetY(), v.GetZ()}
+	}
+	triangles = make([][3]int, 0, m.Faces.Len())
+	m.Faces.Each(func(f gomesh.FaceI) {
+		triangles = append(triangles, [3]int{
+			vertex_indices[f.GetA()],
+			vertex_indices[f.GetB()],
+			vertex_indices[f.GetC()],
+		})
+	})
+	return
+}
@@ -0,0 +1,212 @@
+package shapeset
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The mesh of the left box of boxRow, as a plain gomesh Mesh
+func boxMesh(t *testing.T) *gomesh.Mesh {
+	positions, mesh_faces := boxRow()
+	faces := append(mesh_faces[MeshId{0, 1}], mesh_faces[MeshId{1, 2}]...)
+	verts, indices, _ := localBuffers(positions, faces)
+	box_positions := make([]geom.Vec3, len(verts)/3)
+	for i := range box_positions {
+		box_positions[i] = geom.Vec3{verts[i*3], verts[i*3+1], verts[i*3+2]}
+	}
+	triangles := make([][3]int, len(indices)/3)
+	for i := range triangles {
+		triangles[i] = [3]int{indices[i*3], indices[i*3+1], indices[i*3+2]}
+	}
+	m, err := meshFromBuffers("box", box_positions, triangles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// Checks that two meshes have the same triangles, comparing the positions of
+// their corners rather than vertex indices.
+func checkSameTriangles(t *testing.T, format string, expected, actual *gomesh.Mesh) {
+	corners := func(m *gomesh.Mesh) (triangles map[[3]geom.Vec3]bool) {
+		positions, faces := meshToBuffers(m)
+		triangles = make(map[[3]geom.Vec3]bool)
+		for _, f := range faces {
+			triangles[[3]geom.Vec3{positions[f[0]], positions[f[1]], positions[f[2]]}] = true
+		}
+		return
+	}
+	expected_triangles, actual_triangles := corners(expected), corners(actual)
+	if len(actual_triangles) != len(expected_triangles) || actual.Vertices.Len() != expected.Vertices.Len() {
+		t.Fatalf("Expected %d vertices and %d triangles from %s, got %d and %d", expected.Vertices.Len(),
+			len(expected_triangles), format, actual.Vertices.Len(), len(actual_triangles))
+	}
+	for triangle, _ := range expected_triangles {
+		if !actual_triangles[triangle] {
+			t.Errorf("Triangle %v was lost by %s", triangle, format)
+		}
+	}
+}
+
+func TestPLYRoundTrip(t *testing.T) {
+	m := boxMesh(t)
+	for _, ascii := range []bool{true, false} {
+		var buf bytes.Buffer
+		if err := WritePLY(m, &buf, ascii); err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadPLY("box", bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSameTriangles(t, "ply", m, read)
+
+		// ply preserves the order of vertices
+		expected_positions, _ := meshToBuffers(m)
+		read_positions, _ := meshToBuffers(read)
+		for i, p := range expected_positions {
+			if read_positions[i] != p {
+				t.Errorf("Expected vertex %d at %v, got %v", i, p, read_positions[i])
+			}
+		}
+	}
+}
+
+func TestReadPLYTriangulatesPolygons(t *testing.T) {
+	doc := "ply\nformat ascii 1.0\nelement vertex 4\n" +
+		"property float x\nproperty float y\nproperty float z\n" +
+		"element face 1\nproperty list uchar int vertex_indices\nend_header\n" +
+		"0 0 0\n1 0 0\n1 1 0\n0 1 0\n4 0 1 2 3\n"
+	m, err := ReadPLY("quad", bufio.NewReader(strings.NewReader(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Vertices.Len() != 4 || m.Faces.Len() != 2 {
+		t.Errorf("Expected a quad as two triangles, got %d vertices and %d faces",
+			m.Vertices.Len(), m.Faces.Len())
+	}
+
+	if _, err := ReadPLY("obj", bufio.NewReader(strings.NewReader("v 0 0 0\n"))); err == nil {
+		t.Errorf("Expected a file without a ply header to be rejected")
+	}
+}
+
+func TestSTLRoundTrip(t *testing.T) {
+	m := boxMesh(t)
+	var buf bytes.Buffer
+	if err := WriteSTL(m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 84+50*m.Faces.Len() {
+		t.Errorf("Expected %d bytes of binary stl, got %d", 84+50*m.Faces.Len(), buf.Len())
+	}
+	read, err := ReadSTL("box", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the corners of each triangle are welded back into shared vertices
+	checkSameTriangles(t, "stl", m, read)
+
+	if _, err := ReadSTL("short", bytes.NewReader(make([]byte, 40))); err == nil {
+		t.Errorf("Expected a truncated stl to be rejected")
+	}
+}
+
+func TestMeshFormatFor(t *testing.T) {
+	for path, name := range map[string]string{"a/0-1.obj": "obj", "0-1.PLY": "ply", "0-1.stl": "stl"} {
+		format, err := MeshFormatFor(path)
+		if err != nil || format.Name != name {
+			t.Errorf("Expected %s to be %s, got %v %v", path, name, format, err)
+		}
+	}
+	if _, err := MeshFormatFor("0-1.vtk"); err == nil {
+		t.Errorf("Expected an unknown extension to be rejected")
+	}
+	if format, _ := MeshFormatNamed("stl"); format == nil || !format.ReordersVertices {
+		t.Errorf("Expected stl to reorder vertices")
+	}
+}
+
+// Writes each mesh of the shapeset to dir in the given format, moved by offset
+func writeMovedMeshes(t *testing.T, ss *ShapeSet, dir, ext string, offset geom.Vec3) {
+	for _, m := range ss.Meshes {
+		positions, triangles := meshToBuffers(&m.Mesh)
+		for i := range positions {
+			positions[i] = geom.Vec3{positions[i].X + offset.X,
+				positions[i].Y + offset.Y, positions[i].Z + offset.Z}
+		}
+		moved, err := meshFromBuffers(m.Name, positions, triangles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = WriteMeshFile(moved, filepath.Join(dir, m.Name+ext)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReloadVertices(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	dir := t.TempDir()
+	writeMovedMeshes(t, ss, dir, ".ply", geom.Vec3{0, 0, 2})
+	if err := ss.ReloadVertices(dir); err != nil {
+		t.Fatal(err)
+	}
+	for mesh_id, m := range ss.Meshes {
+		for i := 0; i < m.Vertices.Len(); i++ {
+			if z := m.Vertices.Get(i)[0].GetZ(); z != 2 && z != 3 {
+				t.Fatalf("Expected vertices of mesh %s to move up by 2, got z of %g",
+					mesh_id.ToString(), z)
+			}
+		}
+	}
+}
+
+func TestReloadVerticesLeavesShapeSetUnchangedOnError(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	before := borderSummaries(ss)
+
+	stl_dir := t.TempDir()
+	writeMovedMeshes(t, ss, stl_dir, ".stl", geom.Vec3{0, 0, 2})
+	if err := ss.ReloadVertices(stl_dir); err == nil || !strings.Contains(err.Error(), "order of vertices") {
+		t.Errorf("Expected stl files to be rejected, got %v", err)
+	}
+
+	// one mesh is missing, the rest have been written and would otherwise move
+	missing_dir := t.TempDir()
+	writeMovedMeshes(t, ss, missing_dir, ".ply", geom.Vec3{0, 0, 2})
+	os.Remove(filepath.Join(missing_dir, "2-3.ply"))
+	if err := ss.ReloadVertices(missing_dir); err == nil {
+		t.Errorf("Expected a missing mesh file to be reported")
+	}
+
+	// a mesh file with the vertices of another mesh
+	mismatched_dir := t.TempDir()
+	writeMovedMeshes(t, ss, mismatched_dir, ".ply", geom.Vec3{0, 0, 2})
+	box_mesh, _ := os.ReadFile(filepath.Join(mismatched_dir, "0-1.ply"))
+	os.WriteFile(filepath.Join(mismatched_dir, "1-2.ply"), box_mesh, 0644)
+	if err := ss.ReloadVertices(mismatched_dir); err == nil || !strings.Contains(err.Error(), "vertices") {
+		t.Errorf("Expected a vertex count mismatch to be reported, got %v", err)
+	}
+
+	after := borderSummaries(ss)
+	for border_id, summary := range before {
+		if after[border_id] != summary {
+			t.Errorf("Expected border %d to stay at %s, got %s", border_id, summary, after[border_id])
+		}
+	}
+	for mesh_id, m := range ss.Meshes {
+		for i := 0; i < m.Vertices.Len(); i++ {
+			if z := m.Vertices.Get(i)[0].GetZ(); z != 0 && z != 1 {
+				t.Fatalf("Expected vertices of mesh %s to stay put, got z of %g",
+					mesh_id.ToString(), z)
+			}
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package shapeset
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Reading and writing of Stanford PLY meshes, in ascii or binary encoding.
+ * Only vertex positions and triangle faces are retained, polygonal faces are
+ * triangulated as fans and any other elements or properties are skipped.
+ */
+
+type plyProperty struct {
+	Name      string
+	Type      string
+	CountType string // non empty for list properties
+}
+
+type plyElement struct {
+	Name       string
+	Count      int
+	Properties []plyProperty
+}
+
+func (e *plyElement) propertyIndex(names ...string) int {
+	for i, p := range e.Properties {
+		if stringInSlice(p.Name, names) {
+			return i
+		}
+	}
+	return -1
+}
+
+var plyTypeSizes = map[string]int{
+	"char": 1, "uchar": 1, "int8": 1, "uint8": 1,
+	"short": 2, "ushort": 2, "int16": 2, "uint16": 2,
+	"int": 4, "uint": 4, "int32": 4, "uint32": 4,
+	"float": 4, "float32": 4,
+	"double": 8, "float64": 8,
+}
+
+// Reads values of the given ply property types from either an ascii or a
+// binary stream
+type plyValueReader struct {
+	r       *bufio.Reader
+	order   binary.ByteOrder // nil for ascii
+	fields  []string
+	scratch [8]byte
+}
+
+func (pr *plyValueReader) read(ply_type string) (value float64, err error) {
+	if pr.order == nil {
+		for len(pr.fields) == 0 {
+			var line string
+			line, err = pr.r.ReadString('\n')
+			if err != nil && (err != io.EOF || len(line) == 0) {
+				return
+			}
+			err = nil
+			pr.fields = strings.Fields(line)
+		}
+		value, err = strconv.ParseFloat(pr.fields[0], 64)
+		pr.fields = pr.fields[1:]
+		return
+	}
+
+	size, known := plyTypeSizes[ply_type]
+	if !known {
+		err = errors.New("Unsupported ply property type: " + ply_type)
+		return
+	}
+	buf := pr.scratch[:size]
+	if _, err = io.ReadFull(pr.r, buf); err != nil {
+		return
+	}
+	switch ply_type {
+	case "char", "int8":
+		value = float64(int8(buf[0]))
+	case "uchar", "uint8":
+		value = float64(buf[0])
+	case "short", "int16":
+		value = float64(int16(pr.order.Uint16(buf)))
+	case "ushort", "uint16":
+		value = float64(pr.order.Uint16(buf))
+	case "int", "int32":
+		value = float64(int32(pr.order.Uint32(buf)))
+	case "uint", "uint32":
+		value = float64(pr.order.Uint32(buf))
+	case "float", "float32":
+		value = float64(math.Float32frombits(pr.order.Uint32(buf)))
+	case "double", "float64":
+		value = math.Float64frombits(pr.order.Uint64(buf))
+	}
+	return
+}
+
+// Ascii elements are one per line, so discard whatever is left of the line
+func (pr *plyValueReader) endElement() {
+	pr.fields = nil
+}
+
+func ReadPLYFile(mesh_file_path string) (m *gomesh.Mesh, err error) {
+	input_file, err := os.Open(mesh_file_path)
+	if err != nil {
+		return
+	}
+	defer input_file.Close()
+	name := strings.TrimSuffix(filepath.Base(mesh_file_path), filepath.Ext(mesh_file_path))
+	return ReadPLY(name, bufio.NewReader(input_file))
+}
+
+func ReadPLY(name string, r *bufio.Reader) (m *gomesh.Mesh, err error) {
+	// parse header
+	elements := make([]*plyElement, 0)
+	pr := &plyValueReader{r: r}
+	encoding := ""
+header:
+	for line_no := 1; ; line_no++ {
+		var line string
+		line, err = r.ReadString('\n')
+		if err != nil {
+			err = errors.New("Unexpected end of ply header in mesh: " + name)
+			return
+		}
+		words := strings.Fields(line)
+		if line_no == 1 {
+			if len(words) != 1 || words[0] != "ply" {
+				err = errors.New("Not a ply file: " + name)
+				return
+			}
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		malformed := errors.New("Malformed ply header on line " +
+			strconv.Itoa(line_no) + " of mesh: " + name)
+		switch words[0] {
+		case "format":
+			if len(words) < 2 {
+				err = malformed
+				return
+			}
+			encoding = words[1]
+		case "element":
+			if len(words) != 3 {
+				err = malformed
+				return
+			}
+			count, e := strconv.Atoi(words[2])
+			if e != nil || count < 0 {
+				err = malformed
+				return
+			}
+			elements = append(elements, &plyElement{Name: words[1], Count: count})
+		case "property":
+			if len(elements) == 0 {
+				err = malformed
+				return
+			}
+			element := elements[len(elements)-1]
+			if len(words) == 5 && words[1] == "list" {
+				element.Properties = append(element.Properties,
+					plyProperty{Name: words[4], Type: words[3], CountType: words[2]})
+			} else if len(words) == 3 {
+				element.Properties = append(element.Properties,
+					plyProperty{Name: words[2], Type: words[1]})
+			} else {
+				err = malformed
+				return
+			}
+		case "comment", "obj_info":
+		case "end_header":
+			break header
+		default:
+			err = malformed
+			return
+		}
+	}
+
+	switch encoding {
+	case "ascii":
+	case "binary_little_endian":
+		pr.order = binary.LittleEndian
+	case "binary_big_endian":
+		pr.order = binary.BigEndian
+	default:
+		err = errors.New("Unsupported ply format " + encoding + " in mesh: " + name)
+		return
+	}
+
+	positions, triangles, err := pr.readElements(elements)
+	if err != nil {
+		err = errors.New("Could not read ply data for mesh " + name + ": " + err.Error())
+		return
+	}
+
+	return meshFromBuffers(name, positions, triangles)
+}
+
+// Reads the body of a ply file, collecting the positions of vertex elements
+// and triangulating face elements.
+func (pr *plyValueReader) readElements(elements []*plyElement) (
+	positions []geom.Vec3,
+	triangles [][3]int,
+	err error,
+) {
+	positions = make([]geom.Vec3, 0)
+	triangles = make([][3]int, 0)
+	for _, element := range elements {
+		xi := element.propertyIndex("x")
+		yi := element.propertyIndex("y")
+		zi := element.propertyIndex("z")
+		fi := element.propertyIndex("vertex_indices", "vertex_index")
+		is_vertex := element.Name == "vertex" && xi >= 0 && yi >= 0 && zi >= 0
+		is_face := element.Name == "face" && fi >= 0 &&
+			element.Properties[fi].CountType != ""
+
+		values := make([]float64, len(element.Properties))
+		var polygon []int
+		for i := 0; i < element.Count; i++ {
+			for pi, p := range element.Properties {
+				if p.CountType == "" {
+					if values[pi], err = pr.read(p.Type); err != nil {
+						return
+					}
+					continue
+				}
+				var count float64
+				if count, err = pr.read(p.CountType); err != nil {
+					return
+				}
+				if pi == fi {
+					polygon = polygon[:0]
+				}
+				for j := 0; j < int(count); j++ {
+					var index float64
+					if index, err = pr.read(p.Type); err != nil {
+						return
+					}
+					if pi == fi {
+						polygon = append(polygon, int(index))
+					}
+				}
+			}
+			pr.endElement()
+
+			if is_vertex {
+				positions = append(positions, geom.Vec3{values[xi], values[yi], values[zi]})
+			} else if is_face {
+				// triangulate polygons as a fan around their first vertex
+				for j := 2; j < len(polygon); j++ {
+					triangles = append(triangles, [3]int{polygon[0], polygon[j-1], polygon[j]})
+				}
+			}
+		}
+	}
+	return
+}
+
+// Writes a mesh as a ply file with double vertex positions and int indices,
+// either as ascii or as little endian binary.
+func WritePLY(m *gomesh.Mesh, w io.Writer, ascii bool) (err error) {
+	positions, triangles := meshToBuffers(m)
+
+	encoding := "binary_little_endian"
+	if ascii {
+		encoding = "ascii"
+	}
+	_, err = fmt.Fprintf(w, "ply\nformat %s 1.0\ncomment %s\n"+
+		"element vertex %d\nproperty double x\nproperty double y\nproperty double z\n"+
+		"element face %d\nproperty list uchar int vertex_indices\nend_header\n",
+		encoding, m.GetName(), len(positions), len(triangles))
+	if err != nil {
+		return
+	}
+
+	if ascii {
+		bw := bufio.NewWriter(w)
+		for _, p := range positions {
+			fmt.Fprintf(bw, "%s %s %s\n",
+				strconv.FormatFloat(p.X, 'g', -1, 64),
+				strconv.FormatFloat(p.Y, 'g', -1, 64),
+				strconv.FormatFloat(p.Z, 'g', -1, 64))
+		}
+		for _, t := range triangles {
+			fmt.Fprintf(bw, "3 %d %d %d\n", t[0], t[1], t[2])
+		}
+		return bw.Flush()
+	}
+
+	bw := &binaryWriter{w: w}
+	for _, p := range positions {
+		bw.write([3]float64{p.X, p.Y, p.Z})
+	}
+	for _, t := range triangles {
+		bw.write(uint8(3))
+		bw.write([3]int32{int32(t[0]), int32(t[1]), int32(t[2])})
+	}
+	return bw.err
+}
@@ -0,0 +1,95 @@
+package shapeset
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Reading and writing of binary STL meshes. STL stores each triangle with its
+ * own copies of its corners, so vertices at identical positions are welded
+ * back together when reading. As such the vertex order of a mesh is not
+ * preserved by a round trip through STL.
+ */
+
+type stlTriangle struct {
+	Normal    [3]float32
+	Corners   [3][3]float32
+	Attribute uint16
+}
+
+func ReadSTLFile(mesh_file_path string) (m *gomesh.Mesh, err error) {
+	input_file, err := os.Open(mesh_file_path)
+	if err != nil {
+		return
+	}
+	defer input_file.Close()
+	name := strings.TrimSuffix(filepath.Base(mesh_file_path), filepath.Ext(mesh_file_path))
+	return ReadSTL(name, bufio.NewReader(input_file))
+}
+
+func ReadSTL(name string, r io.Reader) (m *gomesh.Mesh, err error) {
+	header := make([]byte, 80)
+	var triangle_count uint32
+	if _, err = io.ReadFull(r, header); err == nil {
+		err = binary.Read(r, binary.LittleEndian, &triangle_count)
+	}
+	if err != nil {
+		err = errors.New("Could not read stl header for mesh: " + name)
+		return
+	}
+
+	positions := make([]geom.Vec3, 0)
+	triangles := make([][3]int, 0, triangle_count)
+	vertex_indices := make(map[[3]float32]int)
+	for i := uint32(0); i < triangle_count; i++ {
+		var t stlTriangle
+		if err = binary.Read(r, binary.LittleEndian, &t); err != nil {
+			err = errors.New("Could not read stl triangle " +
+				strconv.Itoa(int(i)) + " for mesh: " + name)
+			return
+		}
+		var triangle [3]int
+		for j, corner := range t.Corners {
+			index, seen := vertex_indices[corner]
+			if !seen {
+				index = len(positions)
+				positions = append(positions, geom.Vec3{
+					float64(corner[0]), float64(corner[1]), float64(corner[2])})
+				vertex_indices[corner] = index
+			}
+			triangle[j] = index
+		}
+		triangles = append(triangles, triangle)
+	}
+
+	return meshFromBuffers(name, positions, triangles)
+}
+
+func WriteSTL(m *gomesh.Mesh, w io.Writer) (err error) {
+	positions, triangles := meshToBuffers(m)
+
+	header := make([]byte, 80)
+	copy(header, "shapeset "+m.GetName())
+	bw := &binaryWriter{w: w}
+	bw.write(header)
+	bw.write(uint32(len(triangles)))
+	for _, triangle := range triangles {
+		a, b, c := positions[triangle[0]], positions[triangle[1]], positions[triangle[2]]
+		n := normalize(triangleNormal(a, b, c))
+		var t stlTriangle
+		t.Normal = [3]float32{float32(n.X), float32(n.Y), float32(n.Z)}
+		for j, p := range [3]geom.Vec3{a, b, c} {
+			t.Corners[j] = [3]float32{float32(p.X), float32(p.Y), float32(p.Z)}
+		}
+		bw.write(&t)
+	}
+	return bw.err
+}
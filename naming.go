@@ -0,0 +1,267 @@
+package shapeset
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* A scheme for naming mesh files after the two shapes each mesh separates.
+ *
+ * Pattern matches file names without their extension, and must have the named
+ * groups a and b, which capture either the ShapeIds of the two shapes, or if
+ * ByLabel is set, their labels. Labels may also be matched in the form they
+ * take in file names written with the scheme, where characters which aren't
+ * safe in file names are replaced, and shapes without a label (such as the
+ * exterior) are matched by their ShapeId.
+ *
+ * Template describes file names for writing, with {a} and {b} replaced by the
+ * ShapeIds or labels of the lower and higher shape respectively.
+ */
+type MeshNaming struct {
+	Pattern  *regexp.Regexp
+	Template string
+	ByLabel  bool
+}
+
+// Names meshes by their MeshId, e.g. 3-17.obj
+var DefaultMeshNaming = &MeshNaming{
+	Pattern:  regexp.MustCompile(`^(?P<a>\d+)-(?P<b>\d+)$`),
+	Template: "{a}-{b}",
+}
+
+func NewMeshNaming(pattern, template string, by_label bool) (naming *MeshNaming, err error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return
+	}
+	if r.SubexpIndex("a") < 0 || r.SubexpIndex("b") < 0 {
+		err = errors.New("Mesh naming pattern must have the named groups a and b: " + pattern)
+		return
+	}
+	if !strings.Contains(template, "{a}") || !strings.Contains(template, "{b}") {
+		err = errors.New("Mesh naming template must contain {a} and {b}: " + template)
+		return
+	}
+	naming = &MeshNaming{Pattern: r, Template: template, ByLabel: by_label}
+	return
+}
+
+/* Creates a naming scheme from a template alone, deriving the pattern by
+ * matching {a} and {b} against ShapeIds, or any text if by_label is set,
+ * e.g. "interface_{a}_{b}" or "{a}__{b}".
+ */
+func MeshNamingFromTemplate(template string, by_label bool) (naming *MeshNaming, err error) {
+	group := `\d+`
+	if by_label {
+		group = `.+?`
+	}
+	pattern := "^" + regexp.QuoteMeta(template) + "$"
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{a}"), "(?P<a>"+group+")", 1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{b}"), "(?P<b>"+group+")", 1)
+	return NewMeshNaming(pattern, template, by_label)
+}
+
+/* Parses a naming scheme as given on the command line, either "default", or a
+ * template prefixed by "ids:" or "labels:" as for MeshNamingFromTemplate.
+ */
+func ParseMeshNaming(spec string) (naming *MeshNaming, err error) {
+	switch {
+	case spec == "default":
+		naming = DefaultMeshNaming
+	case strings.HasPrefix(spec, "ids:"):
+		naming, err = MeshNamingFromTemplate(strings.TrimPrefix(spec, "ids:"), false)
+	case strings.HasPrefix(spec, "labels:"):
+		naming, err = MeshNamingFromTemplate(strings.TrimPrefix(spec, "labels:"), true)
+	default:
+		err = errors.New("Mesh naming must be default, ids:<template> or labels:<template>")
+	}
+	return
+}
+
+// Derives the file name, without extension, of the mesh with the given id
+func (naming *MeshNaming) FileName(mesh_id MeshId, shapes map[ShapeId]string) string {
+	name_of := func(shape_id ShapeId) string {
+		if label, exists := shapes[shape_id]; naming.ByLabel && exists && label != "" {
+			return unsafeFileNameChars.ReplaceAllString(label, "_")
+		}
+		return shape_id.ToString()
+	}
+	return strings.NewReplacer(
+		"{a}", name_of(mesh_id[0]),
+		"{b}", name_of(mesh_id[1]),
+	).Replace(naming.Template)
+}
+
+// Parses the MeshId from a file name without its extension, failing if the
+// name doesn't match the pattern or refers to unknown shapes.
+func (naming *MeshNaming) MeshId(name string, shapes map[ShapeId]string) (mesh_id MeshId, err error) {
+	match := naming.Pattern.FindStringSubmatch(name)
+	if match == nil {
+		err = errors.New("Doesn't match the naming pattern")
+		return
+	}
+	names := [2]string{
+		match[naming.Pattern.SubexpIndex("a")],
+		match[naming.Pattern.SubexpIndex("b")],
+	}
+
+	var label_ids map[string][]ShapeId
+	if naming.ByLabel {
+		label_ids = make(map[string][]ShapeId)
+		for shape_id, label := range shapes {
+			label_ids[label] = append(label_ids[label], shape_id)
+			if safe_label := unsafeFileNameChars.ReplaceAllString(label, "_"); safe_label != label {
+				label_ids[safe_label] = append(label_ids[safe_label], shape_id)
+			}
+		}
+	}
+
+	for i, shape_name := range names {
+		if !naming.ByLabel {
+			shape_num, e := strconv.Atoi(shape_name)
+			if e != nil {
+				err = errors.New("Invalid shape id: " + shape_name)
+				return
+			}
+			mesh_id[i] = ShapeId(shape_num)
+			continue
+		}
+		switch ids := label_ids[shape_name]; {
+		case len(ids) == 1:
+			mesh_id[i] = ids[0]
+		case len(ids) > 1:
+			err = errors.New("Ambiguous shape label: " + shape_name)
+			return
+		default:
+			// shapes without labels are referred to by id
+			shape_num, e := strconv.Atoi(shape_name)
+			if e != nil {
+				err = errors.New("Unknown shape label: " + shape_name)
+				return
+			}
+			mesh_id[i] = ShapeId(shape_num)
+		}
+	}
+
+	if mesh_id[0] == mesh_id[1] {
+		err = errors.New("A mesh must separate two different shapes")
+		return
+	}
+	if mesh_id[0] > mesh_id[1] {
+		mesh_id[0], mesh_id[1] = mesh_id[1], mesh_id[0]
+	}
+	return
+}
+
+// Describes mesh files which were passed over when creating a shapeset
+type NamingReport struct {
+	// Mesh files with names which couldn't be interpreted, with the reason why
+	Unmatched map[string]string
+	// Mesh files which were ignored because another file in the directory has
+	// the same MeshId, namely the one that was read instead
+	Duplicates map[string]string
+}
+
+func (report *NamingReport) Empty() bool {
+	return len(report.Unmatched) == 0 && len(report.Duplicates) == 0
+}
+
+/* Create a new shapeset from a label table file and a directory of meshes,
+ * named according to the given naming scheme. Every file with the extension
+ * of a readable mesh format is considered. Files which can't be attributed
+ * to a MeshId, or which have the same MeshId as a file before them in
+ * alphabetical order, are skipped and listed in the report.
+ */
+func CreateNewWithNaming(
+	meshes_dir, labels_path string,
+	naming *MeshNaming,
+) (ss *ShapeSet, report *NamingReport, err error) {
+	table, err := ReadLabelTable(labels_path)
+	if err != nil {
+		return
+	}
+	files, err := ioutil.ReadDir(meshes_dir)
+	if err != nil {
+		return
+	}
+
+	shapes := New("", table.Labels, nil).Shapes
+	report = &NamingReport{
+		Unmatched:  make(map[string]string),
+		Duplicates: make(map[string]string),
+	}
+	mesh_files := make(map[MeshId]string)
+	file_names := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() {
+			file_names = append(file_names, f.Name())
+		}
+	}
+	sort.Strings(file_names)
+
+	meshes := make([]*Mesh, 0)
+	for _, file_name := range file_names {
+		if _, e := MeshFormatFor(file_name); e != nil {
+			// not a mesh file
+			continue
+		}
+		base_name := strings.TrimSuffix(file_name, filepath.Ext(file_name))
+		mesh_id, e := naming.MeshId(base_name, shapes)
+		if e != nil {
+			report.Unmatched[file_name] = e.Error()
+			continue
+		}
+		if first_file, exists := mesh_files[mesh_id]; exists {
+			report.Duplicates[file_name] = first_file
+			continue
+		}
+		mesh_files[mesh_id] = file_name
+
+		m, e := ReadMeshFile(filepath.Join(meshes_dir, file_name))
+		if e != nil {
+			err = e
+			return
+		}
+		m.Name = mesh_id.ToString()
+		meshes = append(meshes, WrapMesh(m))
+	}
+
+	ss = New("New ShapeSet", table.Labels, meshes)
+	ss.ApplyLabelTable(table)
+	return
+}
+
+// Writes every mesh into the given directory as a file of the named mesh
+// format, named according to the given naming scheme.
+func (ss *ShapeSet) WriteMeshFiles(meshes_dir, format_name string, naming *MeshNaming) (err error) {
+	format, err := MeshFormatNamed(format_name)
+	if err != nil {
+		return
+	}
+
+	// check for clashing names before writing anything
+	file_meshes := make(map[string]MeshId)
+	for mesh_id, _ := range ss.Meshes {
+		file_name := naming.FileName(mesh_id, ss.Shapes) + format.Extension
+		if other_id, exists := file_meshes[file_name]; exists {
+			err = errors.New("Meshes " + mesh_id.ToString() + " and " +
+				other_id.ToString() + " would both be written to " + file_name)
+			return
+		}
+		file_meshes[file_name] = mesh_id
+	}
+
+	for file_name, mesh_id := range file_meshes {
+		m := ss.Meshes[mesh_id]
+		err = format.WriteFile(&m.Mesh, filepath.Join(meshes_dir, file_name))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
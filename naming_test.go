@@ -0,0 +1,156 @@
+package shapeset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMeshNamingFileNames(t *testing.T) {
+	shapes := map[ShapeId]string{1: "lh cortex", 2: "white_matter", 3: ""}
+	by_label, err := ParseMeshNaming("labels:{a}__{b}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	by_id, err := ParseMeshNaming("ids:interface_{a}_{b}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		naming    *MeshNaming
+		mesh_id   MeshId
+		file_name string
+	}{
+		{DefaultMeshNaming, MeshId{3, 17}, "3-17"},
+		{by_id, MeshId{3, 17}, "interface_3_17"},
+		{by_label, MeshId{1, 2}, "lh_cortex__white_matter"},
+		// shapes without a label are named by id
+		{by_label, MeshId{0, 1}, "0__lh_cortex"},
+		{by_label, MeshId{2, 3}, "white_matter__3"},
+	} {
+		if file_name := c.naming.FileName(c.mesh_id, shapes); file_name != c.file_name {
+			t.Errorf("Expected mesh %s to be named %s, got %s", c.mesh_id.ToString(), c.file_name, file_name)
+		}
+		mesh_id, err := c.naming.MeshId(c.file_name, shapes)
+		if err != nil || mesh_id != c.mesh_id {
+			t.Errorf("Expected %s to be mesh %s, got %s (%v)", c.file_name, c.mesh_id.ToString(), mesh_id.ToString(), err)
+		}
+	}
+
+	// labels are matched as they are too, and ids may come in either order
+	if mesh_id, err := by_label.MeshId("white_matter__lh cortex", shapes); err != nil || mesh_id != (MeshId{1, 2}) {
+		t.Errorf("Expected an unsafe label to be matched, got %s (%v)", mesh_id.ToString(), err)
+	}
+}
+
+func TestMeshNamingRejectsInvalidNames(t *testing.T) {
+	shapes := map[ShapeId]string{1: "a b", 2: "a_b", 3: "c"}
+	by_label, err := MeshNamingFromTemplate("{a}__{b}", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		naming  *MeshNaming
+		name    string
+		message string
+	}{
+		{DefaultMeshNaming, "3_17", "Doesn't match the naming pattern"},
+		{DefaultMeshNaming, "3-3", "A mesh must separate two different shapes"},
+		{by_label, "c__d", "Unknown shape label: d"},
+		// "a b" is written as a_b, which another shape is labelled as
+		{by_label, "a_b__c", "Ambiguous shape label: a_b"},
+		{by_label, "c", "Doesn't match the naming pattern"},
+	} {
+		if _, err := c.naming.MeshId(c.name, shapes); err == nil || err.Error() != c.message {
+			t.Errorf("Expected %s to fail with %q, got %v", c.name, c.message, err)
+		}
+	}
+
+	for _, spec := range []string{"", "ids:{a}", "labels:{b}-{b}"} {
+		if _, err := ParseMeshNaming(spec); err == nil {
+			t.Errorf("Expected the naming %q to be rejected", spec)
+		}
+	}
+	if _, err := NewMeshNaming(`^(\d+)-(?P<b>\d+)$`, "{a}-{b}", false); err == nil {
+		t.Errorf("Expected a pattern without the group a to be rejected")
+	}
+}
+
+func TestCreateNewWithNaming(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	naming, err := ParseMeshNaming("labels:{a}__{b}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meshes_dir := t.TempDir()
+	if err = ss.WriteMeshFiles(meshes_dir, "ply-ascii", naming); err != nil {
+		t.Fatal(err)
+	}
+	labels_path := writeLabelTable(t, "labels.json", `{"1":"left","2":"middle","3":"right"}`)
+
+	// a file for the same mesh as left__middle.ply, which comes before it
+	data, err := os.ReadFile(filepath.Join(meshes_dir, "left__middle.ply"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file_name, contents := range map[string][]byte{
+		"middle__left.ply": data,
+		"notes.ply":        data,
+		"notes.txt":        []byte("not a mesh"),
+	} {
+		if err = os.WriteFile(filepath.Join(meshes_dir, file_name), contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	created, report, err := CreateNewWithNaming(meshes_dir, labels_path, naming)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched["notes.ply"] == "" {
+		t.Errorf("Expected notes.ply to be reported as unmatched, got %v", report.Unmatched)
+	}
+	if len(report.Duplicates) != 1 || report.Duplicates["middle__left.ply"] != "left__middle.ply" {
+		t.Errorf("Expected middle__left.ply to be reported as a duplicate, got %v", report.Duplicates)
+	}
+	if report.Empty() {
+		t.Errorf("Expected the report not to be empty")
+	}
+
+	if len(created.Meshes) != len(ss.Meshes) {
+		t.Fatalf("Expected %d meshes, got %d", len(ss.Meshes), len(created.Meshes))
+	}
+	for mesh_id, m := range ss.Meshes {
+		created_mesh, exists := created.Meshes[mesh_id]
+		if !exists {
+			t.Errorf("Expected mesh %s to be created", mesh_id.ToString())
+			continue
+		}
+		triangles := orientedTriangles(&created_mesh.Mesh)
+		for triangle, _ := range orientedTriangles(&m.Mesh) {
+			if !triangles[triangle] {
+				t.Errorf("Expected mesh %s to have the triangle %v", mesh_id.ToString(), triangle)
+			}
+		}
+	}
+	if created.Shapes[2] != "middle" {
+		t.Errorf("Expected the shapes to be labelled, got %v", created.Shapes)
+	}
+}
+
+func TestWriteMeshFilesRejectsClashingNames(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	ss.Shapes[3] = "middle"
+	naming, err := ParseMeshNaming("labels:{a}__{b}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = ss.WriteMeshFiles(dir, "ply-ascii", naming); err == nil {
+		t.Fatalf("Expected meshes 0-2 and 0-3 to clash")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected nothing to be written, got %d files", len(files))
+	}
+}
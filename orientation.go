@@ -0,0 +1,372 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	"github.com/nat-n/gomesh/mesh"
+	"math"
+	"sort"
+)
+
+/* Repair of the orientation of interface meshes, so that every face of the
+ * mesh a-b has its normal pointing out of shape b into shape a, the lower of
+ * the two ids.
+ *
+ * Each mesh is split into connected components of faces joined by edges which
+ * no other face of the mesh shares, and the faces of each component are first
+ * wound consistently with one another. The side of each component facing the
+ * lower shape is then determined by ray parity: from a few of its faces, rays
+ * are cast to count how many times they cross the surfaces of the shapes
+ * either side, which tells whether the space just in front of the face lies
+ * within each shape regardless of how any face is oriented. Components for
+ * which the rays are inconclusive are oriented to agree with their neighbours
+ * across shared borders instead, as the surface of each shape must be wound
+ * consistently across the borders between its meshes.
+ */
+
+// The ways in which the orientation of a component can be determined
+const (
+	OrientedByRayParity     = "ray-parity"
+	OrientedByBorders       = "borders"
+	OrientationUndetermined = "undetermined"
+)
+
+// Describes the repair of a connected component of an interface mesh
+type OrientationRepair struct {
+	MeshId MeshId
+	// The index of the component among those of the mesh
+	Component    int
+	Faces        int
+	FlippedFaces int
+	DeterminedBy string
+}
+
+type OrientationReport struct {
+	Meshes       int
+	Components   int
+	FlippedFaces int
+	Undetermined int
+	// Every component which had faces flipped or couldn't be oriented
+	Repairs []OrientationRepair
+}
+
+// The number of faces of each component whose surroundings are sampled by rays
+const orientationSamples = 5
+
+// Perturbations of the normal of a sampled face, each giving the direction of a
+// ray cast into the space in front of it, chosen not to be aligned with any
+// axis or diagonal so that rays are unlikely to pass exactly through edges.
+var orientationProbes = []geom.Vec3{
+	shapeAtDirection,
+	normalize(geom.Vec3{-0.7139, 0.1953, 0.6725}),
+	normalize(geom.Vec3{0.2417, -0.6181, -0.7481}),
+}
+
+/* Flips the faces of interface meshes which don't face towards the lower of
+ * their shapes, reporting what was changed. Any BVH of the shapeset must be
+ * rebuilt afterwards.
+ */
+func (ss *ShapeSet) RepairOrientation() (report *OrientationReport) {
+	positions, mesh_faces := ss.sharedFaceBuffers()
+	flips, repairs, components := orientationRepairs(positions, mesh_faces)
+
+	report = &OrientationReport{
+		Meshes:     len(mesh_faces),
+		Components: components,
+		Repairs:    repairs,
+	}
+	for _, repair := range repairs {
+		report.FlippedFaces += repair.FlippedFaces
+		if repair.DeterminedBy == OrientationUndetermined {
+			report.Undetermined++
+		}
+	}
+
+	for mesh_id, flip := range flips {
+		ss.Meshes[mesh_id].Faces.EachWithIndex(func(i int, fi mesh.FaceI) {
+			if flip[i] {
+				// swap the first and second vertices to invert the face
+				f := fi.(*Face)
+				f.Vertices[0], f.Vertices[1] = f.Vertices[1], f.Vertices[0]
+			}
+		})
+	}
+	return
+}
+
+// A reference to a face of a mesh
+type meshFace struct {
+	MeshId MeshId
+	Face   int
+}
+
+// Whether the face traverses the edge from a to b
+func windsAlong(face [3]int, a, b int) bool {
+	for i := 0; i < 3; i++ {
+		if face[i] == a && face[(i+1)%3] == b {
+			return true
+		}
+	}
+	return false
+}
+
+// A connected component of a mesh, with each face's winding relative to that
+// of the first, and the winding of the first which faces the lower shape.
+type orientationComponent struct {
+	MeshId     MeshId
+	Index      int
+	Faces      []int
+	Decision   int
+	Determined string
+}
+
+/* Determines which faces of the meshes, given as indices into a shared list of
+ * positions, must be flipped to face the lower shape of their MeshId, and
+ * describes the repair of each component with flipped or undetermined faces.
+ */
+func orientationRepairs(
+	positions []geom.Vec3,
+	mesh_faces map[MeshId][][3]int,
+) (flips map[MeshId][]bool, repairs []OrientationRepair, component_count int) {
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(mesh_faces))
+	for mesh_id, _ := range mesh_faces {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+
+	edge_faces := make(map[[2]int][]meshFace)
+	for _, mesh_id := range mesh_ids {
+		for fi, face := range mesh_faces[mesh_id] {
+			for i := 0; i < 3; i++ {
+				key := sliceEdgeKey(face[i], face[(i+1)%3])
+				edge_faces[key] = append(edge_faces[key], meshFace{mesh_id, fi})
+			}
+		}
+	}
+
+	// split each mesh into components, winding each face relative to the first
+	// face of its component
+	components := make([]*orientationComponent, 0)
+	face_components := make(map[MeshId][]int)
+	relative := make(map[MeshId][]int)
+	for _, mesh_id := range mesh_ids {
+		faces := mesh_faces[mesh_id]
+		face_components[mesh_id] = make([]int, len(faces))
+		relative[mesh_id] = make([]int, len(faces))
+		mesh_components := 0
+		for seed := range faces {
+			if relative[mesh_id][seed] != 0 {
+				continue
+			}
+			component := &orientationComponent{MeshId: mesh_id, Index: mesh_components}
+			mesh_components++
+			relative[mesh_id][seed] = 1
+			queue := []int{seed}
+			for len(queue) > 0 {
+				fi := queue[0]
+				queue = queue[1:]
+				face_components[mesh_id][fi] = len(components)
+				component.Faces = append(component.Faces, fi)
+				for i := 0; i < 3; i++ {
+					a, b := faces[fi][i], faces[fi][(i+1)%3]
+					var neighbours []int
+					for _, ref := range edge_faces[sliceEdgeKey(a, b)] {
+						if ref.MeshId == mesh_id && ref.Face != fi {
+							neighbours = append(neighbours, ref.Face)
+						}
+					}
+					// don't propagate across edges where the mesh isn't manifold
+					if len(neighbours) != 1 || relative[mesh_id][neighbours[0]] != 0 {
+						continue
+					}
+					// consistently wound neighbours traverse shared edges in
+					// opposite directions
+					relative[mesh_id][neighbours[0]] = relative[mesh_id][fi]
+					if windsAlong(faces[neighbours[0]], a, b) {
+						relative[mesh_id][neighbours[0]] = -relative[mesh_id][fi]
+					}
+					queue = append(queue, neighbours[0])
+				}
+			}
+			components = append(components, component)
+		}
+	}
+	component_count = len(components)
+
+	// orient components by the parity of the number of crossings of rays from
+	// their faces with the surfaces of the shapes either side
+	bvh := newBVH(positions, mesh_faces)
+	eachConcurrently(len(components), func(ci int) {
+		component := components[ci]
+		mesh_id := component.MeshId
+		votes := 0
+		step := int(math.Ceil(float64(len(component.Faces)) / orientationSamples))
+		for i := 0; i < len(component.Faces); i += step {
+			fi := component.Faces[i]
+			face := mesh_faces[mesh_id][fi]
+			a, b, c := positions[face[0]], positions[face[1]], positions[face[2]]
+			normal := triangleNormal(a, b, c)
+			if normal.X == 0 && normal.Y == 0 && normal.Z == 0 {
+				continue
+			}
+			normal = normalize(normal)
+			sign := float64(relative[mesh_id][fi])
+			centroid := geom.Vec3{(a.X + b.X + c.X) / 3, (a.Y + b.Y + c.Y) / 3, (a.Z + b.Z + c.Z) / 3}
+			for _, shape_id := range mesh_id {
+				bounds_shape := func(other MeshId) bool {
+					return (other[0] == shape_id) != (other[1] == shape_id)
+				}
+				for _, probe := range orientationProbes {
+					direction := geom.Vec3{
+						normal.X*sign + probe.X*0.5,
+						normal.Y*sign + probe.Y*0.5,
+						normal.Z*sign + probe.Z*0.5,
+					}
+					// the exterior is outside the surfaces of the shapes it bounds
+					inside := bvh.crossings(centroid, direction, bounds_shape)%2 == 1
+					if shape_id == 0 {
+						inside = !inside
+					}
+					// the front of the face should be within the lower shape
+					if inside == (shape_id == mesh_id[0]) {
+						votes++
+					} else {
+						votes--
+					}
+				}
+			}
+		}
+		if votes != 0 {
+			component.Decision = votes / int(math.Abs(float64(votes)))
+			component.Determined = OrientedByRayParity
+		}
+	})
+
+	// orient the rest to agree with oriented neighbours across shared borders,
+	// until no more can be oriented
+	for changed := true; changed; {
+		changed = false
+		for _, component := range components {
+			if component.Decision != 0 {
+				continue
+			}
+			mesh_id := component.MeshId
+			votes := 0
+			for _, fi := range component.Faces {
+				face := mesh_faces[mesh_id][fi]
+				for i := 0; i < 3; i++ {
+					a, b := face[i], face[(i+1)%3]
+					if relative[mesh_id][fi] < 0 {
+						a, b = b, a
+					}
+					for _, shape_id := range mesh_id {
+						var neighbours []meshFace
+						for _, ref := range edge_faces[sliceEdgeKey(a, b)] {
+							if ref.MeshId != mesh_id &&
+								(ref.MeshId[0] == shape_id) != (ref.MeshId[1] == shape_id) {
+								neighbours = append(neighbours, ref)
+							}
+						}
+						if len(neighbours) != 1 {
+							continue
+						}
+						ref := neighbours[0]
+						neighbour := components[face_components[ref.MeshId][ref.Face]]
+						if neighbour.Decision == 0 {
+							continue
+						}
+						// wound to face out of the shape, the faces either side
+						// of the edge should traverse it in opposite directions
+						along := mesh_id[0] != shape_id
+						neighbour_along := windsAlong(mesh_faces[ref.MeshId][ref.Face], a, b) !=
+							(relative[ref.MeshId][ref.Face]*neighbour.Decision < 0) !=
+							(ref.MeshId[0] == shape_id)
+						if along != neighbour_along {
+							votes++
+						} else {
+							votes--
+						}
+					}
+				}
+			}
+			if votes != 0 {
+				component.Decision = votes / int(math.Abs(float64(votes)))
+				component.Determined = OrientedByBorders
+				changed = true
+			}
+		}
+	}
+
+	flips = make(map[MeshId][]bool)
+	repairs = make([]OrientationRepair, 0)
+	for _, component := range components {
+		mesh_id := component.MeshId
+		if component.Decision == 0 {
+			// leave as many faces as possible as they are
+			against := 0
+			for _, fi := range component.Faces {
+				if relative[mesh_id][fi] < 0 {
+					against++
+				}
+			}
+			component.Decision = 1
+			if 2*against > len(component.Faces) {
+				component.Decision = -1
+			}
+			component.Determined = OrientationUndetermined
+		}
+
+		repair := OrientationRepair{
+			MeshId:       mesh_id,
+			Component:    component.Index,
+			Faces:        len(component.Faces),
+			DeterminedBy: component.Determined,
+		}
+		for _, fi := range component.Faces {
+			if relative[mesh_id][fi]*component.Decision < 0 {
+				if flips[mesh_id] == nil {
+					flips[mesh_id] = make([]bool, len(mesh_faces[mesh_id]))
+				}
+				flips[mesh_id][fi] = true
+				repair.FlippedFaces++
+			}
+		}
+		if repair.FlippedFaces > 0 || repair.DeterminedBy == OrientationUndetermined {
+			repairs = append(repairs, repair)
+		}
+	}
+	return
+}
+
+// Counts the faces of the meshes accepted by include which the ray crosses at
+// a positive distance.
+func (bvh *BVH) crossings(origin, direction geom.Vec3, include func(MeshId) bool) (count int) {
+	if len(bvh.nodes) == 0 {
+		return
+	}
+	o := [3]float64{origin.X, origin.Y, origin.Z}
+	inv_dir := [3]float64{1 / direction.X, 1 / direction.Y, 1 / direction.Z}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &bvh.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if _, entered := node.rayEntry(o, inv_dir, math.Inf(1)); !entered {
+			continue
+		}
+		if node.Count == 0 {
+			stack = append(stack, node.Left, node.Right)
+			continue
+		}
+		for fi := node.First; fi < node.First+node.Count; fi++ {
+			if !include(bvh.faceMeshes[fi]) {
+				continue
+			}
+			face := bvh.faces[fi]
+			_, _, _, crossed := rayTriangle(origin, direction,
+				bvh.positions[face[0]], bvh.positions[face[1]], bvh.positions[face[2]])
+			if crossed {
+				count++
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package shapeset
+
+import (
+	"testing"
+)
+
+func TestOrientationRepairsOfConsistentMeshes(t *testing.T) {
+	positions, mesh_faces := boxRow()
+	flips, repairs, components := orientationRepairs(positions, mesh_faces)
+	if len(flips) != 0 || len(repairs) != 0 {
+		t.Errorf("Expected no repairs, got %+v", repairs)
+	}
+	if components != len(mesh_faces) {
+		t.Errorf("Expected a component per mesh, got %d", components)
+	}
+}
+
+func TestOrientationRepairsOfFlippedMeshes(t *testing.T) {
+	positions, mesh_faces := boxRow()
+	// flip a whole mesh, which must be oriented by ray parity or its borders,
+	// and some faces of another, which must agree with the rest of their mesh
+	flipped := flipFaces(mesh_faces, MeshId{1, 2}, 0, 1)
+	flipped = flipFaces(flipped, MeshId{0, 3}, 2, 5, 6)
+
+	flips, repairs, _ := orientationRepairs(positions, flipped)
+	expected := map[MeshId][]int{{0, 3}: {2, 5, 6}, {1, 2}: {0, 1}}
+	for mesh_id, faces := range flipped {
+		for fi := range faces {
+			should_flip := intInSlice(fi, expected[mesh_id])
+			if flips[mesh_id] == nil && should_flip ||
+				flips[mesh_id] != nil && flips[mesh_id][fi] != should_flip {
+				t.Errorf("Face %d of mesh %s should flip: %v", fi, mesh_id.ToString(), should_flip)
+			}
+		}
+	}
+	for _, repair := range repairs {
+		if repair.DeterminedBy == OrientationUndetermined {
+			t.Errorf("Orientation of mesh %s wasn't determined", repair.MeshId.ToString())
+		}
+	}
+}
+
+func TestRepairOrientation(t *testing.T) {
+	positions, mesh_faces := boxRow()
+	flipped := flipFaces(mesh_faces, MeshId{1, 2}, 0, 1)
+	flipped = flipFaces(flipped, MeshId{0, 2}, 3, 4)
+	ss := shapeSetFromBuffers(t, positions, flipped)
+
+	report := ss.RepairOrientation()
+	if report.FlippedFaces != 4 || report.Undetermined != 0 {
+		t.Errorf("Expected 4 faces to be flipped, got %+v", report)
+	}
+	if validation := ss.ValidateShapes(); !validation.Valid {
+		t.Errorf("Expected valid shapes after repair, got %+v", validation.Shapes)
+	}
+	if report = ss.RepairOrientation(); report.FlippedFaces != 0 {
+		t.Errorf("Expected nothing left to repair, got %+v", report)
+	}
+}
@@ -0,0 +1,207 @@
+package shapeset
+
+import (
+	"errors"
+	"github.com/nat-n/geom"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+/* Voxelisation of the shapeset into a label volume, the inverse of extracting
+ * meshes from a volume.
+ *
+ * Each row of voxels along the x axis is treated as a ray cast through the
+ * voxel centers. Wherever the ray crosses a face of an interface mesh, it
+ * passes from the shape behind the face into the shape in front of it, or
+ * vice versa, as given by the orientation of the face and the MeshId. Voxels
+ * are labelled with the shape the ray is within at their centers, so no
+ * assumptions are made about the exterior or the order of nesting of shapes.
+ * Rays which cross no faces are labelled as exterior.
+ *
+ * Faces are binned by the rows they may cross, and rows are rasterized
+ * concurrently.
+ */
+
+// A face crossed by a ray along the x axis at distance X, after which the ray
+// is within the shape Enters, having left the shape Leaves.
+type rasterHit struct {
+	X      float64
+	Enters ShapeId
+	Leaves ShapeId
+}
+
+// Labels a volume of the given dimensions with voxel centers at
+// origin + spacing * index with the shapes which contain them.
+func (ss *ShapeSet) Rasterize(spacing, origin [3]float64, dims [3]int) (lv *LabelVolume, err error) {
+	for axis := 0; axis < 3; axis++ {
+		if dims[axis] < 1 || !(spacing[axis] > 0) {
+			err = errors.New("Raster dimensions and spacing must be positive")
+			return
+		}
+	}
+	if lv, err = NewLabelVolume(dims, spacing, origin); err != nil {
+		return
+	}
+	positions, mesh_faces := ss.sharedFaceBuffers()
+	rasterizeFaces(lv, positions, mesh_faces)
+	return
+}
+
+// Labels every voxel of lv from the faces of every mesh, given as indices into
+// a shared list of positions.
+func rasterizeFaces(lv *LabelVolume, positions []geom.Vec3, mesh_faces map[MeshId][][3]int) {
+	dims, spacing, origin := lv.Dims, lv.Spacing, lv.Origin
+
+	// bin faces by the rows of voxel centers their projections onto the yz
+	// plane may contain
+	row_faces := make([][]int, dims[1]*dims[2])
+	face_meshes := make([]MeshId, 0)
+	faces := make([][3]int, 0)
+	for mesh_id, faces_of_mesh := range mesh_faces {
+		for _, face := range faces_of_mesh {
+			face_index := len(faces)
+			faces = append(faces, face)
+			face_meshes = append(face_meshes, mesh_id)
+
+			min_y, max_y := math.Inf(1), math.Inf(-1)
+			min_z, max_z := math.Inf(1), math.Inf(-1)
+			for _, pi := range face {
+				p := positions[pi]
+				min_y, max_y = math.Min(min_y, p.Y), math.Max(max_y, p.Y)
+				min_z, max_z = math.Min(min_z, p.Z), math.Max(max_z, p.Z)
+			}
+			y0 := int(math.Max(0, math.Ceil((min_y-origin[1])/spacing[1])))
+			y1 := int(math.Min(float64(dims[1]-1), math.Floor((max_y-origin[1])/spacing[1])))
+			z0 := int(math.Max(0, math.Ceil((min_z-origin[2])/spacing[2])))
+			z1 := int(math.Min(float64(dims[2]-1), math.Floor((max_z-origin[2])/spacing[2])))
+			for z := z0; z <= z1; z++ {
+				for y := y0; y <= y1; y++ {
+					row := y + dims[1]*z
+					row_faces[row] = append(row_faces[row], face_index)
+				}
+			}
+		}
+	}
+
+	rasterize_row := func(y, z int) {
+		ray_y := origin[1] + spacing[1]*float64(y)
+		ray_z := origin[2] + spacing[2]*float64(z)
+		hits := make([]rasterHit, 0)
+		for _, face_index := range row_faces[y+dims[1]*z] {
+			face := faces[face_index]
+			a, b, c := positions[face[0]], positions[face[1]], positions[face[2]]
+			x, facing, crossed := rayCrossesFace(ray_y, ray_z, a, b, c)
+			if !crossed {
+				continue
+			}
+			// normals point towards the front shape, the lower of the MeshId
+			mesh_id := face_meshes[face_index]
+			hit := rasterHit{X: x, Enters: mesh_id[1], Leaves: mesh_id[0]}
+			if facing > 0 {
+				hit.Enters, hit.Leaves = mesh_id[0], mesh_id[1]
+			}
+			hits = append(hits, hit)
+		}
+		if len(hits) == 0 {
+			return
+		}
+		sort.Slice(hits, func(i, j int) bool { return hits[i].X < hits[j].X })
+
+		current := hits[0].Leaves
+		next_hit := 0
+		for x := 0; x < dims[0]; x++ {
+			voxel_x := origin[0] + spacing[0]*float64(x)
+			for next_hit < len(hits) && hits[next_hit].X <= voxel_x {
+				current = hits[next_hit].Enters
+				next_hit++
+			}
+			lv.Set(x, y, z, current)
+		}
+	}
+
+	var wg sync.WaitGroup
+	pending := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for z := range pending {
+				for y := 0; y < dims[1]; y++ {
+					rasterize_row(y, z)
+				}
+			}
+		}()
+	}
+	for z := 0; z < dims[2]; z++ {
+		pending <- z
+	}
+	close(pending)
+	wg.Wait()
+}
+
+/* Tests whether the ray through (y, z) along the x axis crosses the triangle
+ * abc, and if so where, and whether it crosses it in the direction of its
+ * normal (facing > 0) or against it. Rays passing exactly through an edge are
+ * attributed to only one of the triangles sharing it, by only including edges
+ * on one side of each triangle, so that crossings are neither missed nor
+ * counted twice.
+ */
+func rayCrossesFace(y, z float64, a, b, c geom.Vec3) (x, facing float64, crossed bool) {
+	// twice the signed area of the triangle projected onto the yz plane, which
+	// is the x component of its normal
+	area := (b.Y-a.Y)*(c.Z-a.Z) - (b.Z-a.Z)*(c.Y-a.Y)
+	if area == 0 {
+		return
+	}
+	sign := 1.0
+	if area < 0 {
+		sign = -1
+	}
+
+	corners := [3]geom.Vec3{a, b, c}
+	var weights [3]float64
+	for i := 0; i < 3; i++ {
+		p, q := corners[(i+1)%3], corners[(i+2)%3]
+		dy, dz := (q.Y-p.Y)*sign, (q.Z-p.Z)*sign
+		weights[i] = dy*(z-p.Z) - dz*(y-p.Y)
+		if weights[i] < 0 {
+			return
+		}
+		if weights[i] == 0 && !(dz < 0 || (dz == 0 && dy > 0)) {
+			return
+		}
+	}
+
+	total := weights[0] + weights[1] + weights[2]
+	x = (weights[0]*a.X + weights[1]*b.X + weights[2]*c.X) / total
+	facing = area
+	crossed = true
+	return
+}
+
+/* Determines the origin and dimensions of a volume with the given isotropic
+ * spacing covering every mesh, with the given number of voxels of padding on
+ * each side.
+ */
+func (ss *ShapeSet) RasterGrid(spacing float64, padding int) (origin [3]float64, dims [3]int) {
+	positions, _ := ss.sharedFaceBuffers()
+	if len(positions) == 0 {
+		dims = [3]int{1, 1, 1}
+		return
+	}
+	min := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range positions {
+		for axis, x := range [3]float64{p.X, p.Y, p.Z} {
+			min[axis] = math.Min(min[axis], x)
+			max[axis] = math.Max(max[axis], x)
+		}
+	}
+	for axis := 0; axis < 3; axis++ {
+		origin[axis] = min[axis] - spacing*float64(padding)
+		dims[axis] = int(math.Ceil((max[axis]-min[axis])/spacing)) + 1 + 2*padding
+	}
+	return
+}
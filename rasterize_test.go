@@ -0,0 +1,46 @@
+package shapeset
+
+import (
+	"testing"
+)
+
+func TestRasterize(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	// voxel centers run from x of -0.25 to 3.25, through the middle of each box
+	lv, err := ss.Rasterize([3]float64{0.5, 0.2, 1}, [3]float64{-0.25, 0.3, 0.6}, [3]int{8, 2, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := []ShapeId{0, 1, 1, 2, 2, 3, 3, 0}
+	checkLabelVolume(t, lv, [3]int{8, 2, 1}, [3]float64{0.5, 0.2, 1}, [3]float64{-0.25, 0.3, 0.6},
+		append(append([]ShapeId{}, row...), row...)...)
+	if lv.RAS {
+		t.Errorf("Expected a rasterized volume not to be known to be RAS")
+	}
+
+	for _, spacing := range [][3]float64{{0, 1, 1}, {1, -1, 1}} {
+		if _, err = ss.Rasterize(spacing, [3]float64{}, [3]int{1, 1, 1}); err == nil {
+			t.Errorf("Expected the spacing %v to be rejected", spacing)
+		}
+	}
+}
+
+func TestRasterGrid(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	origin, dims := ss.RasterGrid(0.5, 1)
+	if origin != [3]float64{-0.5, -0.5, -0.5} || dims != [3]int{9, 5, 5} {
+		t.Errorf("Expected a grid of 9x5x5 voxels from -0.5, got %v from %v", dims, origin)
+	}
+
+	// a volume of the grid is labelled back into the boxes
+	lv, err := ss.Rasterize([3]float64{0.5, 0.5, 0.5}, origin, dims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := lv.DistinctLabels(); len(labels) != 4 {
+		t.Errorf("Expected the exterior and 3 boxes in the volume, got %v", labels)
+	}
+	if lv.At(0, 0, 0) != 0 || lv.At(8, 4, 4) != 0 {
+		t.Errorf("Expected the padding to be exterior")
+	}
+}
@@ -0,0 +1,191 @@
+package shapeset
+
+import (
+	"errors"
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Create a new shapeset from a label table file and a directory of closed meshes,
+ * one per shape, named by ShapeId, e.g. 5.obj. ShapeId 0 is reserved for the
+ * exterior.
+ */
+func CreateFromShapeMeshes(meshes_dir, labels_path string) (ss *ShapeSet, err error) {
+	// Ensure meshes_dir ends with a slash
+	if meshes_dir[len(meshes_dir)-1] != 47 {
+		meshes_dir += "/"
+	}
+
+	table, err := ReadLabelTable(labels_path)
+	if err != nil {
+		return
+	}
+	files, err := ioutil.ReadDir(meshes_dir)
+	if err != nil {
+		return
+	}
+
+	quoted_extensions := make([]string, 0)
+	for _, ext := range meshFileExtensions() {
+		quoted_extensions = append(quoted_extensions, regexp.QuoteMeta(ext))
+	}
+	r := regexp.MustCompile(`^(\d+)(?i:` + strings.Join(quoted_extensions, "|") + `)$`)
+	shape_meshes := make(map[ShapeId]*gomesh.Mesh)
+	for _, f := range files {
+		if !r.MatchString(f.Name()) {
+			continue
+		}
+		shape_id := ShapeIdFromString(r.FindStringSubmatch(f.Name())[1])
+		if _, exists := shape_meshes[shape_id]; exists {
+			err = errors.New("Found more than one mesh for shape: " + shape_id.ToString())
+			return
+		}
+		shape_meshes[shape_id], err = ReadMeshFile(meshes_dir + f.Name())
+		if err != nil {
+			return
+		}
+	}
+
+	ss, err = FromShapeMeshes("New ShapeSet", table.Labels, shape_meshes)
+	if err != nil {
+		return
+	}
+	ss.ApplyLabelTable(table)
+	return
+}
+
+// A triangle identified by the positions of its corners regardless of their
+// order, so that coincident faces from different meshes have the same key.
+type faceKey [3]geom.Vec3
+
+func makeFaceKey(corners [3]geom.Vec3) faceKey {
+	key := faceKey(corners)
+	less := func(a, b geom.Vec3) bool {
+		return a.X < b.X || a.X == b.X && (a.Y < b.Y || a.Y == b.Y && a.Z < b.Z)
+	}
+	sort.Slice(key[:], func(i, j int) bool { return less(key[i], key[j]) })
+	return key
+}
+
+type interfaceMesh struct {
+	Positions []geom.Vec3
+	Triangles [][3]int
+	indices   map[geom.Vec3]int
+}
+
+// Returns the index of the vertex at the given position, adding it if needed
+func (im *interfaceMesh) vertexAt(position geom.Vec3) int {
+	if index, exists := im.indices[position]; exists {
+		return index
+	}
+	index := len(im.Positions)
+	im.Positions = append(im.Positions, position)
+	im.indices[position] = index
+	return index
+}
+
+type shapeFace struct {
+	Shape   ShapeId
+	Corners [3]geom.Vec3
+}
+
+/* Builds a shapeset from a closed, outward facing, surface mesh per shape.
+ * Faces which coincide exactly between the surfaces of two shapes make up the
+ * interface mesh between those shapes, all other faces make up the interface
+ * mesh between their shape and the exterior. The surfaces of neighbouring
+ * shapes must therefore be triangulated identically where they touch.
+ *
+ * Interface faces are taken from the surface of the higher ShapeId, so that
+ * they face the lower ShapeId as assumed by ComposeRegion, which consequently
+ * reproduces each input surface.
+ */
+func FromShapeMeshes(
+	name string,
+	labels map[string]string,
+	shape_meshes map[ShapeId]*gomesh.Mesh,
+) (ss *ShapeSet, err error) {
+	shape_ids := make([]int, 0, len(shape_meshes))
+	for shape_id, _ := range shape_meshes {
+		if shape_id == 0 {
+			err = errors.New("ShapeId 0 is reserved for the exterior")
+			return
+		}
+		shape_ids = append(shape_ids, int(shape_id))
+	}
+	sort.Ints(shape_ids)
+
+	// group the faces of all surfaces by their corner positions
+	face_order := make([]faceKey, 0)
+	coincident_faces := make(map[faceKey][]shapeFace)
+	for _, sid := range shape_ids {
+		shape_id := ShapeId(sid)
+		positions, triangles := meshToBuffers(shape_meshes[shape_id])
+		for _, t := range triangles {
+			corners := [3]geom.Vec3{positions[t[0]], positions[t[1]], positions[t[2]]}
+			key := makeFaceKey(corners)
+			if _, seen := coincident_faces[key]; !seen {
+				face_order = append(face_order, key)
+			}
+			coincident_faces[key] = append(coincident_faces[key], shapeFace{shape_id, corners})
+		}
+	}
+
+	// assign each group of faces to the interface between the shapes it's from
+	interfaces := make(map[MeshId]*interfaceMesh)
+	for _, key := range face_order {
+		faces := coincident_faces[key]
+		var mesh_id MeshId
+		var face shapeFace
+		switch {
+		case len(faces) == 1:
+			face = faces[0]
+			mesh_id = MeshId{0, face.Shape}
+		case len(faces) == 2 && faces[0].Shape != faces[1].Shape:
+			// faces are grouped in ShapeId order, so the second has the higher id
+			face = faces[1]
+			mesh_id = MeshId{faces[0].Shape, faces[1].Shape}
+		default:
+			err = errors.New("Face at " + strconv.FormatFloat(key[0].X, 'g', -1, 64) + "," +
+				strconv.FormatFloat(key[0].Y, 'g', -1, 64) + "," +
+				strconv.FormatFloat(key[0].Z, 'g', -1, 64) +
+				" is duplicated or shared by more than two shapes")
+			return
+		}
+
+		im, exists := interfaces[mesh_id]
+		if !exists {
+			im = &interfaceMesh{indices: make(map[geom.Vec3]int)}
+			interfaces[mesh_id] = im
+		}
+		var triangle [3]int
+		for i, corner := range face.Corners {
+			triangle[i] = im.vertexAt(corner)
+		}
+		im.Triangles = append(im.Triangles, triangle)
+	}
+
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(interfaces))
+	for mesh_id, _ := range interfaces {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+
+	meshes := make([]*Mesh, 0, len(mesh_ids))
+	for _, mesh_id := range mesh_ids {
+		im := interfaces[mesh_id]
+		var m *gomesh.Mesh
+		m, err = meshFromBuffers(mesh_id.ToString(), im.Positions, im.Triangles)
+		if err != nil {
+			return
+		}
+		meshes = append(meshes, WrapMesh(m))
+	}
+
+	ss = New(name, labels, meshes)
+	return
+}
@@ -0,0 +1,113 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	gomesh "github.com/nat-n/gomesh/mesh"
+	"strings"
+	"testing"
+)
+
+// A closed, outward facing, unit box moved along x by offset
+func closedBox(t *testing.T, offset float64) *gomesh.Mesh {
+	positions := make([]geom.Vec3, 0, 24)
+	triangles := make([][3]int, 0, 12)
+	for _, side := range boxSides {
+		start := len(positions)
+		for _, corner := range side {
+			positions = append(positions, geom.Vec3{corner[0] + offset, corner[1], corner[2]})
+		}
+		triangles = append(triangles,
+			[3]int{start, start + 1, start + 2},
+			[3]int{start, start + 2, start + 3})
+	}
+	m, err := meshFromBuffers("box", positions, triangles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// Lists the triangles of a mesh by the positions of their corners, each
+// starting from its least corner so that triangles facing the same way match.
+func orientedTriangles(m *gomesh.Mesh) (triangles map[[3]geom.Vec3]bool) {
+	positions, faces := meshToBuffers(m)
+	triangles = make(map[[3]geom.Vec3]bool)
+	for _, f := range faces {
+		corners := [3]geom.Vec3{positions[f[0]], positions[f[1]], positions[f[2]]}
+		least := 0
+		for i, c := range corners {
+			l := corners[least]
+			if c.X < l.X || c.X == l.X && (c.Y < l.Y || c.Y == l.Y && c.Z < l.Z) {
+				least = i
+			}
+		}
+		triangles[[3]geom.Vec3{corners[least], corners[(least+1)%3], corners[(least+2)%3]}] = true
+	}
+	return
+}
+
+func TestFromShapeMeshes(t *testing.T) {
+	boxes := map[ShapeId]*gomesh.Mesh{1: closedBox(t, 0), 2: closedBox(t, 1), 3: closedBox(t, 2)}
+	ss, err := FromShapeMeshes("boxes", boxRowLabels, boxes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the interfaces are those of boxRow, facing the same way
+	positions, mesh_faces := boxRow()
+	expected := shapeSetFromBuffers(t, positions, mesh_faces)
+	if len(ss.Meshes) != len(expected.Meshes) {
+		t.Fatalf("Expected %d interface meshes, got %d", len(expected.Meshes), len(ss.Meshes))
+	}
+	for mesh_id, expected_mesh := range expected.Meshes {
+		m, exists := ss.Meshes[mesh_id]
+		if !exists {
+			t.Fatalf("Expected an interface mesh %s", mesh_id.ToString())
+		}
+		actual_triangles := orientedTriangles(&m.Mesh)
+		for triangle, _ := range orientedTriangles(&expected_mesh.Mesh) {
+			if !actual_triangles[triangle] {
+				t.Errorf("Expected mesh %s to have the triangle %v", mesh_id.ToString(), triangle)
+			}
+		}
+		if m.Faces.Len() != expected_mesh.Faces.Len() {
+			t.Errorf("Expected mesh %s to have %d faces, got %d",
+				mesh_id.ToString(), expected_mesh.Faces.Len(), m.Faces.Len())
+		}
+	}
+
+	// composing each shape reproduces its surface
+	if err = ss.IndexBorders(); err != nil {
+		t.Fatal(err)
+	}
+	for shape_id, box := range boxes {
+		composed, err := ss.ComposeRegion(int(shape_id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		composed_triangles := orientedTriangles(&composed)
+		box_triangles := orientedTriangles(box)
+		if len(composed_triangles) != len(box_triangles) {
+			t.Errorf("Expected shape %d to compose to %d triangles, got %d",
+				shape_id, len(box_triangles), len(composed_triangles))
+		}
+		for triangle, _ := range box_triangles {
+			if !composed_triangles[triangle] {
+				t.Errorf("Expected shape %d to compose to the triangle %v", shape_id, triangle)
+			}
+		}
+	}
+}
+
+func TestFromShapeMeshesRejectsInvalidShapes(t *testing.T) {
+	_, err := FromShapeMeshes("boxes", nil, map[ShapeId]*gomesh.Mesh{0: closedBox(t, 0)})
+	if err == nil || !strings.Contains(err.Error(), "reserved for the exterior") {
+		t.Errorf("Expected shape 0 to be rejected, got %v", err)
+	}
+
+	overlapping := map[ShapeId]*gomesh.Mesh{1: closedBox(t, 0), 2: closedBox(t, 0), 3: closedBox(t, 0)}
+	_, err = FromShapeMeshes("boxes", nil, overlapping)
+	if err == nil || !strings.Contains(err.Error(), "shared by more than two shapes") {
+		t.Errorf("Expected faces of three shapes to be rejected, got %v", err)
+	}
+}
@@ -10,8 +10,11 @@ import (
 type ShapeSet struct {
 	Name         string
 	Shapes       map[ShapeId]string
+	Metadata     map[ShapeId]*ShapeMetadata
 	Meshes       map[MeshId]*Mesh
 	BordersIndex BorderIndex
+	// meshes which borders may list but weren't read, see LazyShapeSet.Load
+	unloadedMeshes map[MeshId]bool
 }
 
 type Mesh struct {
@@ -137,9 +140,10 @@ func (ss *ShapeSet) EachMesh(cb func(gomesh.MeshI)) {
 
 func New(name string, labels map[string]string, meshes []*Mesh) (ss *ShapeSet) {
 	ss = &ShapeSet{
-		Name:   name,
-		Shapes: make(map[ShapeId]string),
-		Meshes: make(map[MeshId]*Mesh),
+		Name:     name,
+		Shapes:   make(map[ShapeId]string),
+		Metadata: make(map[ShapeId]*ShapeMetadata),
+		Meshes:   make(map[MeshId]*Mesh),
 	}
 	ss.ResetBorders()
 
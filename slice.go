@@ -0,0 +1,471 @@
+package shapeset
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nat-n/geom"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Planar cross-sections of the shapeset.
+ *
+ * Every mesh is intersected with the plane once, giving segments which cross
+ * each face between two of its edges. Since meshes share vertices along their
+ * borders, segments are identified by the edges they cross, so the segments of
+ * neighbouring meshes join up exactly without any tolerance. The surface of
+ * each shape is then made up of the meshes it is either side of, with the
+ * segments of meshes on which it is the front shape reversed as their normals
+ * point towards it, and stitched into closed contours.
+ *
+ * Contours are expressed in 2D coordinates within the plane, and wind
+ * counterclockwise around the area of the shape they enclose, so those of
+ * negative area are holes.
+ */
+
+// A plane through Origin perpendicular to Normal
+type Plane struct {
+	Origin geom.Vec3
+	Normal geom.Vec3
+}
+
+// An area of a shape within the plane, as a counterclockwise outer ring and
+// any number of clockwise holes. Rings are closed, their last point repeating
+// their first.
+type SlicePolygon struct {
+	Outer [][2]float64
+	Holes [][][2]float64
+}
+
+/* A section through the shapeset. Points (u, v) of the polygons are located in
+ * 3D at Plane.Origin + u*U + v*V.
+ */
+type Slice struct {
+	Plane    Plane
+	U        geom.Vec3
+	V        geom.Vec3
+	Polygons map[ShapeId][]*SlicePolygon
+}
+
+// A segment crossing a face, between the points where the plane crosses two of
+// its edges, identified by the indices of the positions at either end of them.
+type sliceSegment struct {
+	Start, End [2]int
+}
+
+// Calculates the basis vectors within the plane, such that U x V = Normal
+func (plane Plane) basis() (normal, u, v geom.Vec3) {
+	normal = normalize(plane.Normal)
+	axis := geom.Vec3{1, 0, 0}
+	if math.Abs(normal.X) > 0.9 {
+		axis = geom.Vec3{0, 1, 0}
+	}
+	u = normalize(geom.Vec3{
+		axis.Y*normal.Z - axis.Z*normal.Y,
+		axis.Z*normal.X - axis.X*normal.Z,
+		axis.X*normal.Y - axis.Y*normal.X,
+	})
+	v = geom.Vec3{
+		normal.Y*u.Z - normal.Z*u.Y,
+		normal.Z*u.X - normal.X*u.Z,
+		normal.X*u.Y - normal.Y*u.X,
+	}
+	return
+}
+
+/* Intersects the shapeset with the given plane, giving the polygons of each
+ * shape in the section. Contours which fail to close, where the surface of a
+ * shape is not closed, are discarded.
+ */
+func (ss *ShapeSet) Slice(plane Plane) (slice *Slice, err error) {
+	normal, u, v := plane.basis()
+	if normal.X == 0 && normal.Y == 0 && normal.Z == 0 {
+		err = errors.New("Slice plane must have a non-zero normal")
+		return
+	}
+	slice = &Slice{
+		Plane: Plane{Origin: plane.Origin, Normal: normal},
+		U:     u,
+		V:     v,
+	}
+
+	positions, mesh_faces := ss.sharedFaceBuffers()
+	slice.Polygons = slicePolygons(slice, positions, mesh_faces)
+	return
+}
+
+// Slices the faces of every mesh, given as indices into a shared list of
+// positions, and assembles the polygons of each shape.
+func slicePolygons(
+	slice *Slice,
+	positions []geom.Vec3,
+	mesh_faces map[MeshId][][3]int,
+) (polygons map[ShapeId][]*SlicePolygon) {
+	plane, normal, u, v := slice.Plane, slice.Plane.Normal, slice.U, slice.V
+	polygons = make(map[ShapeId][]*SlicePolygon)
+
+	// signed distance of every position from the plane, positions in the plane
+	// are treated as lying in front of it, so that every crossing falls on an
+	// edge from behind to in front of the plane or vice versa.
+	in_front := make([]bool, len(positions))
+	distances := make([]float64, len(positions))
+	for i, p := range positions {
+		distances[i] = (p.X-plane.Origin.X)*normal.X +
+			(p.Y-plane.Origin.Y)*normal.Y +
+			(p.Z-plane.Origin.Z)*normal.Z
+		in_front[i] = distances[i] >= 0
+	}
+
+	// intersect every mesh with the plane
+	mesh_segments := make(map[MeshId][]sliceSegment)
+	shape_ids := make([]ShapeId, 0)
+	seen_shapes := make(map[ShapeId]bool)
+	for mesh_id, faces := range mesh_faces {
+		for _, shape_id := range mesh_id {
+			if !seen_shapes[shape_id] {
+				seen_shapes[shape_id] = true
+				shape_ids = append(shape_ids, shape_id)
+			}
+		}
+		segments := make([]sliceSegment, 0)
+		for _, face := range faces {
+			var segment sliceSegment
+			crossings := 0
+			for i := 0; i < 3; i++ {
+				a, b := face[i], face[(i+1)%3]
+				if in_front[a] == in_front[b] {
+					continue
+				}
+				crossings++
+				// the segment runs from where the face edges pass from in front
+				// to behind the plane, to where they pass back, so the inside of
+				// the surface lies to its left.
+				if in_front[a] {
+					segment.Start = sliceEdgeKey(a, b)
+				} else {
+					segment.End = sliceEdgeKey(a, b)
+				}
+			}
+			if crossings == 2 {
+				segments = append(segments, segment)
+			}
+		}
+		mesh_segments[mesh_id] = segments
+	}
+	sort.Sort(ByShapeId(shape_ids))
+
+	project := func(edge [2]int) [2]float64 {
+		a, b := positions[edge[0]], positions[edge[1]]
+		t := distances[edge[0]] / (distances[edge[0]] - distances[edge[1]])
+		p := geom.Vec3{
+			a.X + t*(b.X-a.X) - plane.Origin.X,
+			a.Y + t*(b.Y-a.Y) - plane.Origin.Y,
+			a.Z + t*(b.Z-a.Z) - plane.Origin.Z,
+		}
+		return [2]float64{
+			p.X*u.X + p.Y*u.Y + p.Z*u.Z,
+			p.X*v.X + p.Y*v.Y + p.Z*v.Z,
+		}
+	}
+
+	for _, shape_id := range shape_ids {
+		if shape_id == 0 {
+			// the exterior encloses no area
+			continue
+		}
+		next := make(map[[2]int][2]int)
+		for mesh_id, segments := range mesh_segments {
+			if (mesh_id[0] == shape_id) == (mesh_id[1] == shape_id) {
+				continue
+			}
+			for _, segment := range segments {
+				if mesh_id[0] == shape_id {
+					next[segment.End] = segment.Start
+				} else {
+					next[segment.Start] = segment.End
+				}
+			}
+		}
+		shape_polygons := assembleSlicePolygons(sliceContours(next), project)
+		if len(shape_polygons) > 0 {
+			polygons[shape_id] = shape_polygons
+		}
+	}
+	return
+}
+
+func sliceEdgeKey(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+// Follows the links between segments from each crossing to the next to find
+// all closed contours, in a deterministic order.
+func sliceContours(next map[[2]int][2]int) (contours [][][2]int) {
+	starts := make([][2]int, 0, len(next))
+	for start, _ := range next {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		if starts[i][0] != starts[j][0] {
+			return starts[i][0] < starts[j][0]
+		}
+		return starts[i][1] < starts[j][1]
+	})
+
+	visited := make(map[[2]int]bool)
+	for _, start := range starts {
+		if visited[start] {
+			continue
+		}
+		contour := [][2]int{start}
+		visited[start] = true
+		closed := false
+		for key, exists := next[start]; exists; key, exists = next[key] {
+			if key == start {
+				closed = true
+				break
+			}
+			if visited[key] {
+				break
+			}
+			visited[key] = true
+			contour = append(contour, key)
+		}
+		if closed && len(contour) >= 3 {
+			contours = append(contours, contour)
+		}
+	}
+	return
+}
+
+// Projects contours into the plane, and groups them into polygons by nesting
+// each hole within the smallest outer ring which contains it.
+func assembleSlicePolygons(
+	contours [][][2]int,
+	project func([2]int) [2]float64,
+) (polygons []*SlicePolygon) {
+	outer_areas := make([]float64, 0)
+	holes := make([][][2]float64, 0)
+	for _, contour := range contours {
+		ring := make([][2]float64, 0, len(contour)+1)
+		for _, key := range contour {
+			p := project(key)
+			// crossings at a vertex in the plane are reached by several edges
+			if len(ring) == 0 || ring[len(ring)-1] != p {
+				ring = append(ring, p)
+			}
+		}
+		if len(ring) > 1 && ring[len(ring)-1] == ring[0] {
+			ring = ring[:len(ring)-1]
+		}
+		if len(ring) < 3 {
+			continue
+		}
+		ring = append(ring, ring[0])
+
+		area := ringArea(ring)
+		if area > 0 {
+			polygons = append(polygons, &SlicePolygon{Outer: ring})
+			outer_areas = append(outer_areas, area)
+		} else if area < 0 {
+			holes = append(holes, ring)
+		}
+	}
+
+	for _, hole := range holes {
+		owner := -1
+		for i, polygon := range polygons {
+			if ringContains(polygon.Outer, hole[0]) &&
+				(owner < 0 || outer_areas[i] < outer_areas[owner]) {
+				owner = i
+			}
+		}
+		if owner >= 0 {
+			polygons[owner].Holes = append(polygons[owner].Holes, hole)
+		}
+	}
+	return
+}
+
+// Calculates the signed area of a closed ring, positive if counterclockwise
+func ringArea(ring [][2]float64) (area float64) {
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// Tests whether a point lies within a closed ring by the even-odd rule
+func ringContains(ring [][2]float64, p [2]float64) (inside bool) {
+	for i := 0; i < len(ring)-1; i++ {
+		a, b := ring[i], ring[i+1]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < a[0]+(p[1]-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			inside = !inside
+		}
+	}
+	return
+}
+
+// Lists the ids of the shapes with area in the slice in ascending order
+func (slice *Slice) ShapeIds() (shape_ids []ShapeId) {
+	for shape_id, _ := range slice.Polygons {
+		shape_ids = append(shape_ids, shape_id)
+	}
+	sort.Sort(ByShapeId(shape_ids))
+	return
+}
+
+// Calculates the bounds of the slice within the plane
+func (slice *Slice) Bounds() (min, max [2]float64) {
+	min = [2]float64{math.Inf(1), math.Inf(1)}
+	max = [2]float64{math.Inf(-1), math.Inf(-1)}
+	for _, polygons := range slice.Polygons {
+		for _, polygon := range polygons {
+			for _, p := range polygon.Outer {
+				for i := 0; i < 2; i++ {
+					min[i] = math.Min(min[i], p[i])
+					max[i] = math.Max(max[i], p[i])
+				}
+			}
+		}
+	}
+	return
+}
+
+/* Writes the slice as an SVG document, with a path per shape filled with its
+ * color. The v axis points up the page, so the section appears as seen looking
+ * back along the plane normal.
+ */
+func (ss *ShapeSet) WriteSliceSVG(w io.Writer, slice *Slice) (err error) {
+	if len(slice.Polygons) == 0 {
+		err = errors.New("Slice plane doesn't intersect any shapes")
+		return
+	}
+	min, max := slice.Bounds()
+	margin := 0.02 * math.Max(max[0]-min[0], max[1]-min[1])
+	format := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', 7, 64)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" viewBox=\"%s %s %s %s\">\n",
+		format(min[0]-margin), format(-max[1]-margin),
+		format(max[0]-min[0]+2*margin), format(max[1]-min[1]+2*margin))
+	for _, shape_id := range slice.ShapeIds() {
+		color := ss.ShapeColor(shape_id)
+		path := make([]string, 0)
+		for _, polygon := range slice.Polygons[shape_id] {
+			for _, ring := range append([][][2]float64{polygon.Outer}, polygon.Holes...) {
+				for i, p := range ring[:len(ring)-1] {
+					command := "L"
+					if i == 0 {
+						command = "M"
+					}
+					path = append(path, command+format(p[0])+","+format(-p[1]))
+				}
+				path = append(path, "Z")
+			}
+		}
+		fmt.Fprintf(bw, "  <path id=\"shape-%d\" fill=\"%s\" fill-opacity=\"%s\" "+
+			"fill-rule=\"evenodd\" stroke=\"black\" stroke-width=\"%s\" d=\"%s\">"+
+			"<title>%s</title></path>\n",
+			shape_id, color.Hex(), format(color[3]), format(margin/20),
+			strings.Join(path, " "), svgEscape(ss.Shapes[shape_id]))
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+func svgEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;").Replace(text)
+}
+
+type sliceFeatureCollection struct {
+	Type     string                 `json:"type"`
+	Plane    map[string]interface{} `json:"plane"`
+	Features []sliceFeature         `json:"features"`
+}
+
+type sliceFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   sliceGeometry          `json:"geometry"`
+}
+
+type sliceGeometry struct {
+	Type        string           `json:"type"`
+	Coordinates [][][][2]float64 `json:"coordinates"`
+}
+
+/* Writes the slice as a GeoJSON style FeatureCollection, with a MultiPolygon
+ * feature per shape carrying its id, label and color, in plane coordinates.
+ * The plane and its basis vectors are included to locate them in 3D.
+ */
+func (ss *ShapeSet) WriteSliceGeoJSON(w io.Writer, slice *Slice) (err error) {
+	collection := sliceFeatureCollection{
+		Type: "FeatureCollection",
+		Plane: map[string]interface{}{
+			"origin": [3]float64{slice.Plane.Origin.X, slice.Plane.Origin.Y, slice.Plane.Origin.Z},
+			"normal": [3]float64{slice.Plane.Normal.X, slice.Plane.Normal.Y, slice.Plane.Normal.Z},
+			"u":      [3]float64{slice.U.X, slice.U.Y, slice.U.Z},
+			"v":      [3]float64{slice.V.X, slice.V.Y, slice.V.Z},
+		},
+		Features: make([]sliceFeature, 0),
+	}
+	for _, shape_id := range slice.ShapeIds() {
+		coordinates := make([][][][2]float64, 0)
+		for _, polygon := range slice.Polygons[shape_id] {
+			coordinates = append(coordinates,
+				append([][][2]float64{polygon.Outer}, polygon.Holes...))
+		}
+		collection.Features = append(collection.Features, sliceFeature{
+			Type: "Feature",
+			Properties: map[string]interface{}{
+				"shape_id": shape_id,
+				"label":    ss.Shapes[shape_id],
+				"color":    ss.ShapeColor(shape_id).Hex(),
+			},
+			Geometry: sliceGeometry{Type: "MultiPolygon", Coordinates: coordinates},
+		})
+	}
+
+	bw := bufio.NewWriter(w)
+	if err = json.NewEncoder(bw).Encode(collection); err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+// Writes the slice as SVG or GeoJSON according to the file extension, which
+// must be .svg, .json or .geojson
+func (ss *ShapeSet) WriteSliceFile(slice_file_path string, slice *Slice) (err error) {
+	var write func(io.Writer, *Slice) error
+	switch strings.ToLower(filepath.Ext(slice_file_path)) {
+	case ".svg":
+		write = ss.WriteSliceSVG
+	case ".json", ".geojson":
+		write = ss.WriteSliceGeoJSON
+	default:
+		err = errors.New("Unsupported slice file type: " + slice_file_path)
+		return
+	}
+
+	output_file, err := os.Create(slice_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	err = write(output_file, slice)
+	return
+}
@@ -0,0 +1,150 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/nat-n/geom"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Locates a point of a slice in 3D
+func slicePoint(slice *Slice, p [2]float64) geom.Vec3 {
+	o, u, v := slice.Plane.Origin, slice.U, slice.V
+	return geom.Vec3{
+		o.X + p[0]*u.X + p[1]*v.X,
+		o.Y + p[0]*u.Y + p[1]*v.Y,
+		o.Z + p[0]*u.Z + p[1]*v.Z,
+	}
+}
+
+func TestSlice(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	slice, err := ss.Slice(Plane{Origin: geom.Vec3{0, 0, 0.5}, Normal: geom.Vec3{0, 0, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slice.Plane.Normal != (geom.Vec3{0, 0, 1}) {
+		t.Errorf("Expected the plane normal to be normalized, got %v", slice.Plane.Normal)
+	}
+	shape_ids := slice.ShapeIds()
+	if len(shape_ids) != 3 || shape_ids[0] != 1 || shape_ids[2] != 3 {
+		t.Fatalf("Expected each box in the slice, got %v", shape_ids)
+	}
+
+	// each box is cut into a unit square, wound counterclockwise
+	for _, shape_id := range shape_ids {
+		polygons := slice.Polygons[shape_id]
+		if len(polygons) != 1 || len(polygons[0].Holes) != 0 {
+			t.Fatalf("Expected shape %d to be a single polygon without holes, got %d", shape_id, len(polygons))
+		}
+		outer := polygons[0].Outer
+		if area := ringArea(outer); math.Abs(area-1) > 1e-9 {
+			t.Errorf("Expected shape %d to have an area of 1, got %g", shape_id, area)
+		}
+		if outer[0] != outer[len(outer)-1] {
+			t.Errorf("Expected the ring of shape %d to be closed, got %v", shape_id, outer)
+		}
+		for _, p := range outer {
+			point := slicePoint(slice, p)
+			x_min := float64(shape_id - 1)
+			if math.Abs(point.Z-0.5) > 1e-9 || point.X < x_min-1e-9 || point.X > x_min+1+1e-9 ||
+				point.Y < -1e-9 || point.Y > 1+1e-9 {
+				t.Errorf("Expected shape %d to be sliced within its box, got %v", shape_id, point)
+			}
+		}
+	}
+
+	// a plane through the middle box alone
+	slice, err = ss.Slice(Plane{Origin: geom.Vec3{1.5, 0, 0}, Normal: geom.Vec3{1, 0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shape_ids := slice.ShapeIds(); len(shape_ids) != 1 || shape_ids[0] != 2 {
+		t.Errorf("Expected only the middle box in the slice, got %v", shape_ids)
+	}
+
+	if _, err = ss.Slice(Plane{Normal: geom.Vec3{0, 0, 0}}); err == nil {
+		t.Errorf("Expected a plane without a normal to be rejected")
+	}
+}
+
+func TestAssembleSlicePolygons(t *testing.T) {
+	points := [][2]float64{
+		{0, 0}, {4, 0}, {4, 4}, {0, 4}, // outer square
+		{1, 1}, {1, 3}, {3, 3}, {3, 1}, // clockwise hole
+		{5, 0}, {6, 0}, {6, 1}, {5, 1}, // separate square
+	}
+	contour := func(indices ...int) (keys [][2]int) {
+		for _, i := range indices {
+			keys = append(keys, [2]int{i, i})
+		}
+		return
+	}
+	polygons := assembleSlicePolygons(
+		[][][2]int{contour(4, 5, 6, 7), contour(0, 1, 2, 3), contour(8, 9, 10, 11)},
+		func(key [2]int) [2]float64 { return points[key[0]] },
+	)
+	if len(polygons) != 2 {
+		t.Fatalf("Expected 2 polygons, got %d", len(polygons))
+	}
+	if len(polygons[0].Holes) != 1 || len(polygons[1].Holes) != 0 {
+		t.Errorf("Expected the hole to be within the first square, got %d and %d holes",
+			len(polygons[0].Holes), len(polygons[1].Holes))
+	}
+	if area := ringArea(polygons[0].Outer) + ringArea(polygons[0].Holes[0]); area != 12 {
+		t.Errorf("Expected the square with a hole to have an area of 12, got %g", area)
+	}
+}
+
+func TestWriteSlice(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	ss.Shapes[2] = "a < b"
+	slice, err := ss.Slice(Plane{Origin: geom.Vec3{0, 0.5, 0}, Normal: geom.Vec3{0, 1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var svg bytes.Buffer
+	if err = ss.WriteSliceSVG(&svg, slice); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(svg.String(), "<path ") != 3 || !strings.Contains(svg.String(), "<title>a &lt; b</title>") {
+		t.Errorf("Expected a labelled path per shape, got:\n%s", svg.String())
+	}
+	color := ss.ShapeColor(3)
+	if !strings.Contains(svg.String(), `id="shape-3" fill="`+color.Hex()+`"`) {
+		t.Errorf("Expected shape 3 to be filled with %s, got:\n%s", color.Hex(), svg.String())
+	}
+
+	var geojson bytes.Buffer
+	if err = ss.WriteSliceGeoJSON(&geojson, slice); err != nil {
+		t.Fatal(err)
+	}
+	var collection sliceFeatureCollection
+	if err = json.Unmarshal(geojson.Bytes(), &collection); err != nil {
+		t.Fatal(err)
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 3 {
+		t.Fatalf("Expected a feature per shape, got %d", len(collection.Features))
+	}
+	feature := collection.Features[1]
+	if feature.Properties["label"] != "a < b" || feature.Properties["shape_id"] != float64(2) ||
+		feature.Geometry.Type != "MultiPolygon" || len(feature.Geometry.Coordinates) != 1 ||
+		math.Abs(ringArea(feature.Geometry.Coordinates[0][0])-1) > 1e-9 {
+		t.Errorf("Expected the middle box as a unit square, got %+v", feature)
+	}
+
+	empty, err := ss.Slice(Plane{Origin: geom.Vec3{0, 0, 5}, Normal: geom.Vec3{0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.WriteSliceSVG(&svg, empty); err == nil {
+		t.Errorf("Expected a slice missing the shapes to be rejected")
+	}
+	if err = ss.WriteSliceFile(filepath.Join(t.TempDir(), "slice.png"), slice); err == nil {
+		t.Errorf("Expected an unsupported slice file type to be rejected")
+	}
+}
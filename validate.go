@@ -0,0 +1,397 @@
+package shapeset
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/nat-n/geom"
+	"io"
+	"math"
+	"sort"
+)
+
+/* Validation of the closed surface of each shape, as composed from the meshes
+ * either side of it with their faces wound to face out of the shape.
+ *
+ * A valid shape's surface has every edge shared by exactly two faces which
+ * traverse it in opposite directions, so that it has no holes and a consistent
+ * winding, and its faces face outwards as the MeshId front/back convention
+ * requires. So each connected component of it encloses a positive volume,
+ * unless it's the wall of a cavity within another component, which faces
+ * inwards and so encloses a negative volume.
+ */
+
+// A loop of edges around a hole in the surface of a shape
+type OpenLoop struct {
+	Vertices int
+	Length   float64
+	Start    [3]float64
+	Closed   bool // false if the loop runs into a non-manifold vertex
+}
+
+// Counts the edges along which faces from two meshes are wound inconsistently
+type WindingConflict struct {
+	MeshIds [2]MeshId
+	Edges   int
+}
+
+// A connected component of the surface of a shape
+type SurfaceComponent struct {
+	Faces               int
+	Vertices            int
+	Edges               int
+	EulerCharacteristic int
+	// Whether every edge of the component is shared by two faces
+	Closed bool
+	// The volume enclosed by the component, negative if it's inside out
+	SignedVolume float64
+	// The number of other components which enclose this one, odd for the walls
+	// of cavities
+	EnclosedBy int
+	MeshIds    []MeshId
+}
+
+type ShapeValidation struct {
+	ShapeId           ShapeId
+	Label             string
+	Faces             int
+	OpenLoops         []OpenLoop
+	NonManifoldEdges  [][2][3]float64
+	InconsistentEdges int
+	WindingConflicts  []WindingConflict
+	Components        []SurfaceComponent
+	Valid             bool
+}
+
+type ShapeValidationReport struct {
+	Shapes []ShapeValidation
+	Valid  bool
+}
+
+// Collects the faces of the surface of the shape, wound to face out of it, as
+// indices into the shared positions, along with the mesh of each face.
+func shapeSurface(
+	shape_id ShapeId,
+	mesh_faces map[MeshId][][3]int,
+) (triangles [][3]int, triangle_meshes []MeshId) {
+	mesh_ids := make(ByMeshIdPrecedence, 0)
+	for mesh_id, _ := range mesh_faces {
+		if (mesh_id[0] == shape_id) != (mesh_id[1] == shape_id) {
+			mesh_ids = append(mesh_ids, mesh_id)
+		}
+	}
+	sort.Sort(mesh_ids)
+	for _, mesh_id := range mesh_ids {
+		for _, face := range mesh_faces[mesh_id] {
+			// faces point towards the front shape, so must be inverted to face
+			// out of the shape if it is the front shape
+			if mesh_id[0] == shape_id {
+				face[0], face[1] = face[1], face[0]
+			}
+			triangles = append(triangles, face)
+			triangle_meshes = append(triangle_meshes, mesh_id)
+		}
+	}
+	return
+}
+
+/* Validates the surface of every shape other than the exterior, reporting in
+ * order of ShapeId. Shapes with labels but no meshes are reported as invalid.
+ */
+func (ss *ShapeSet) ValidateShapes() (report *ShapeValidationReport) {
+	positions, mesh_faces := ss.sharedFaceBuffers()
+
+	seen_shapes := make(map[ShapeId]bool)
+	for shape_id, _ := range ss.Shapes {
+		seen_shapes[shape_id] = true
+	}
+	for mesh_id, _ := range mesh_faces {
+		seen_shapes[mesh_id[0]] = true
+		seen_shapes[mesh_id[1]] = true
+	}
+	delete(seen_shapes, 0)
+	shape_ids := make([]ShapeId, 0, len(seen_shapes))
+	for shape_id, _ := range seen_shapes {
+		shape_ids = append(shape_ids, shape_id)
+	}
+	sort.Sort(ByShapeId(shape_ids))
+
+	report = &ShapeValidationReport{Shapes: make([]ShapeValidation, len(shape_ids))}
+	eachConcurrently(len(shape_ids), func(i int) {
+		triangles, triangle_meshes := shapeSurface(shape_ids[i], mesh_faces)
+		report.Shapes[i] = validateSurface(positions, triangles, triangle_meshes)
+		report.Shapes[i].ShapeId = shape_ids[i]
+		report.Shapes[i].Label = ss.Shapes[shape_ids[i]]
+	})
+
+	report.Valid = true
+	for _, shape := range report.Shapes {
+		report.Valid = report.Valid && shape.Valid
+	}
+	return
+}
+
+// The faces which traverse an edge in each direction
+type edgeUse struct {
+	Forward  []int // faces traversing the edge from its lower to higher vertex
+	Backward []int
+}
+
+func validateSurface(
+	positions []geom.Vec3,
+	triangles [][3]int,
+	triangle_meshes []MeshId,
+) (result ShapeValidation) {
+	result.Faces = len(triangles)
+	result.OpenLoops = make([]OpenLoop, 0)
+	result.NonManifoldEdges = make([][2][3]float64, 0)
+	result.WindingConflicts = make([]WindingConflict, 0)
+	result.Components = make([]SurfaceComponent, 0)
+	position := func(i int) [3]float64 {
+		return [3]float64{positions[i].X, positions[i].Y, positions[i].Z}
+	}
+
+	edges := make(map[[2]int]*edgeUse)
+	edge_order := make([][2]int, 0)
+	for fi, face := range triangles {
+		for i := 0; i < 3; i++ {
+			a, b := face[i], face[(i+1)%3]
+			key := sliceEdgeKey(a, b)
+			use, exists := edges[key]
+			if !exists {
+				use = &edgeUse{}
+				edges[key] = use
+				edge_order = append(edge_order, key)
+			}
+			if a < b {
+				use.Forward = append(use.Forward, fi)
+			} else {
+				use.Backward = append(use.Backward, fi)
+			}
+		}
+	}
+
+	// classify edges, and join faces across manifold edges into components
+	parents := make([]int, len(triangles))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	conflicts := make(map[[2]MeshId]int)
+	boundary_next := make(map[int][]int) // open edges, in the direction of their face
+	open_edges := make(map[[2]int]bool)
+	for _, key := range edge_order {
+		use := edges[key]
+		faces := append(append([]int{}, use.Forward...), use.Backward...)
+		for _, fi := range faces[1:] {
+			parents[find(fi)] = find(faces[0])
+		}
+		switch {
+		case len(faces) == 1:
+			open_edges[key] = true
+			if len(use.Forward) == 1 {
+				boundary_next[key[0]] = append(boundary_next[key[0]], key[1])
+			} else {
+				boundary_next[key[1]] = append(boundary_next[key[1]], key[0])
+			}
+		case len(faces) > 2:
+			result.NonManifoldEdges = append(result.NonManifoldEdges,
+				[2][3]float64{position(key[0]), position(key[1])})
+		case len(use.Forward) != 1:
+			result.InconsistentEdges++
+			mesh_pair := [2]MeshId{triangle_meshes[faces[0]], triangle_meshes[faces[1]]}
+			if mesh_pair[1].LessThan(mesh_pair[0]) {
+				mesh_pair[0], mesh_pair[1] = mesh_pair[1], mesh_pair[0]
+			}
+			conflicts[mesh_pair]++
+		}
+	}
+
+	for mesh_pair, count := range conflicts {
+		result.WindingConflicts = append(result.WindingConflicts, WindingConflict{mesh_pair, count})
+	}
+	sort.Slice(result.WindingConflicts, func(i, j int) bool {
+		a, b := result.WindingConflicts[i].MeshIds, result.WindingConflicts[j].MeshIds
+		if a[0] != b[0] {
+			return a[0].LessThan(b[0])
+		}
+		return a[1].LessThan(b[1])
+	})
+
+	result.OpenLoops = openLoops(boundary_next, edge_order, open_edges, position)
+	result.Components = surfaceComponents(positions, triangles, triangle_meshes, edges, find)
+
+	result.Valid = len(triangles) > 0 &&
+		len(result.OpenLoops) == 0 &&
+		len(result.NonManifoldEdges) == 0 &&
+		result.InconsistentEdges == 0
+	for _, component := range result.Components {
+		if component.EnclosedBy%2 == 0 {
+			result.Valid = result.Valid && component.SignedVolume > 0
+		} else {
+			result.Valid = result.Valid && component.SignedVolume < 0
+		}
+	}
+	return
+}
+
+// Follows open edges, each in the direction of its face, into loops around the
+// holes of a surface.
+func openLoops(
+	boundary_next map[int][]int,
+	edge_order [][2]int,
+	open_edges map[[2]int]bool,
+	position func(int) [3]float64,
+) (loops []OpenLoop) {
+	loops = make([]OpenLoop, 0)
+	distance := func(a, b int) float64 {
+		p, q := position(a), position(b)
+		return math.Sqrt((q[0]-p[0])*(q[0]-p[0]) + (q[1]-p[1])*(q[1]-p[1]) + (q[2]-p[2])*(q[2]-p[2]))
+	}
+	visited := make(map[[2]int]bool)
+	for _, key := range edge_order {
+		if !open_edges[key] || visited[key] {
+			continue
+		}
+		// start from the end of the edge its face traverses first
+		start := key[0]
+		if !intInSlice(key[1], boundary_next[key[0]]) {
+			start = key[1]
+		}
+		loop := OpenLoop{Start: position(start)}
+		v := start
+		for {
+			var next int
+			found := false
+			for _, candidate := range boundary_next[v] {
+				if !visited[sliceEdgeKey(v, candidate)] {
+					next, found = candidate, true
+					break
+				}
+			}
+			if !found {
+				break
+			}
+			visited[sliceEdgeKey(v, next)] = true
+			loop.Vertices++
+			loop.Length += distance(v, next)
+			v = next
+			if v == start {
+				loop.Closed = true
+				break
+			}
+		}
+		loops = append(loops, loop)
+	}
+	return
+}
+
+// Measures each connected component of a surface, given the union-find
+// structure joining faces which share edges.
+func surfaceComponents(
+	positions []geom.Vec3,
+	triangles [][3]int,
+	triangle_meshes []MeshId,
+	edges map[[2]int]*edgeUse,
+	find func(int) int,
+) (components []SurfaceComponent) {
+	components = make([]SurfaceComponent, 0)
+	component_index := make(map[int]int)
+	component_vertices := make([]map[int]bool, 0)
+	component_meshes := make([]map[MeshId]bool, 0)
+	first_faces := make([]int, 0)
+	face_components := make([]int, len(triangles))
+	for fi, face := range triangles {
+		root := find(fi)
+		ci, exists := component_index[root]
+		if !exists {
+			ci = len(components)
+			component_index[root] = ci
+			first_faces = append(first_faces, fi)
+			components = append(components, SurfaceComponent{Closed: true})
+			component_vertices = append(component_vertices, make(map[int]bool))
+			component_meshes = append(component_meshes, make(map[MeshId]bool))
+		}
+		face_components[fi] = ci
+		component := &components[ci]
+		component.Faces++
+		for _, pi := range face {
+			component_vertices[ci][pi] = true
+		}
+		component_meshes[ci][triangle_meshes[fi]] = true
+
+		// signed volume of the tetrahedron from the origin to the face
+		a, b, c := positions[face[0]], positions[face[1]], positions[face[2]]
+		component.SignedVolume += (a.X*(b.Y*c.Z-b.Z*c.Y) -
+			a.Y*(b.X*c.Z-b.Z*c.X) +
+			a.Z*(b.X*c.Y-b.Y*c.X)) / 6
+	}
+	for _, use := range edges {
+		faces := len(use.Forward) + len(use.Backward)
+		var fi int
+		if len(use.Forward) > 0 {
+			fi = use.Forward[0]
+		} else {
+			fi = use.Backward[0]
+		}
+		component := &components[component_index[find(fi)]]
+		component.Edges++
+		if faces != 2 {
+			component.Closed = false
+		}
+	}
+	for ci := range components {
+		component := &components[ci]
+		component.Vertices = len(component_vertices[ci])
+		component.EulerCharacteristic = component.Vertices - component.Edges + component.Faces
+		mesh_ids := make(ByMeshIdPrecedence, 0, len(component_meshes[ci]))
+		for mesh_id, _ := range component_meshes[ci] {
+			mesh_ids = append(mesh_ids, mesh_id)
+		}
+		sort.Sort(mesh_ids)
+		component.MeshIds = mesh_ids
+	}
+
+	// a component lies within another if a ray from it crosses the other an
+	// odd number of times
+	if len(components) > 1 {
+		eachConcurrently(len(components), func(ci int) {
+			face := triangles[first_faces[ci]]
+			a, b, c := positions[face[0]], positions[face[1]], positions[face[2]]
+			origin := geom.Vec3{(a.X + b.X + c.X) / 3, (a.Y + b.Y + c.Y) / 3, (a.Z + b.Z + c.Z) / 3}
+			crossings := make([]int, len(components))
+			for fi, other := range triangles {
+				if face_components[fi] == ci {
+					continue
+				}
+				_, _, _, crossed := rayTriangle(origin, shapeAtDirection,
+					positions[other[0]], positions[other[1]], positions[other[2]])
+				if crossed {
+					crossings[face_components[fi]]++
+				}
+			}
+			for _, count := range crossings {
+				if count%2 == 1 {
+					components[ci].EnclosedBy++
+				}
+			}
+		})
+	}
+	return
+}
+
+// Writes the report as indented JSON
+func (report *ShapeValidationReport) WriteJSON(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(report); err != nil {
+		return
+	}
+	return bw.Flush()
+}
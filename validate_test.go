@@ -0,0 +1,136 @@
+package shapeset
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/nat-n/geom"
+	"testing"
+)
+
+// Validates the surface of the shape from the meshes given as faces indexing
+// the shared positions
+func validateShapeSurface(positions []geom.Vec3, mesh_faces map[MeshId][][3]int, shape_id ShapeId) ShapeValidation {
+	triangles, triangle_meshes := shapeSurface(shape_id, mesh_faces)
+	return validateSurface(positions, triangles, triangle_meshes)
+}
+
+func TestValidateSurface(t *testing.T) {
+	positions, mesh_faces := boxRow()
+	for _, shape_id := range []ShapeId{1, 2, 3} {
+		result := validateShapeSurface(positions, mesh_faces, shape_id)
+		if !result.Valid || len(result.Components) != 1 || result.Components[0].EulerCharacteristic != 2 {
+			t.Errorf("Expected shape %d to be a valid closed surface, got %+v", shape_id, result)
+		}
+	}
+
+	// inverting the mesh between shapes 1 and 2 makes it disagree with the
+	// meshes around it in both
+	flipped := flipFaces(mesh_faces, MeshId{1, 2}, 0, 1)
+	for _, shape_id := range []ShapeId{1, 2} {
+		result := validateShapeSurface(positions, flipped, shape_id)
+		if result.Valid || result.InconsistentEdges != 4 {
+			t.Errorf("Expected shape %d to have 4 inconsistent edges, got %+v", shape_id, result)
+		}
+	}
+
+	// without the mesh the shapes are open
+	delete(flipped, MeshId{1, 2})
+	result := validateShapeSurface(positions, flipped, 1)
+	if result.Valid || len(result.OpenLoops) != 1 || result.OpenLoops[0].Vertices != 4 {
+		t.Errorf("Expected shape 1 to have a hole of 4 vertices, got %+v", result.OpenLoops)
+	}
+}
+
+func TestValidateSurfaceWithCavity(t *testing.T) {
+	// a box inside another, of the shapes 1 and 2, makes a cavity in shape 1
+	positions, mesh_faces := make([]geom.Vec3, 0), make(map[MeshId][][3]int)
+	for _, box := range []struct {
+		mesh_id MeshId
+		min     float64
+		size    float64
+	}{{MeshId{0, 1}, 0, 3}, {MeshId{1, 2}, 1, 1}} {
+		for _, side := range boxSides {
+			base := len(positions)
+			for _, corner := range side {
+				positions = append(positions, geom.Vec3{
+					box.min + corner[0]*box.size,
+					box.min + corner[1]*box.size,
+					box.min + corner[2]*box.size,
+				})
+			}
+			mesh_faces[box.mesh_id] = append(mesh_faces[box.mesh_id],
+				[3]int{base, base + 1, base + 2}, [3]int{base, base + 2, base + 3})
+		}
+	}
+	// weld the corners of adjacent sides
+	welded := make(map[geom.Vec3]int)
+	for mesh_id, faces := range mesh_faces {
+		for fi, face := range faces {
+			for i, pi := range face {
+				if index, exists := welded[positions[pi]]; exists {
+					mesh_faces[mesh_id][fi][i] = index
+				} else {
+					welded[positions[pi]] = pi
+				}
+			}
+		}
+	}
+
+	result := validateShapeSurface(positions, mesh_faces, 1)
+	if !result.Valid || len(result.Components) != 2 {
+		t.Fatalf("Expected shape 1 to be valid with 2 components, got %+v", result)
+	}
+	for _, component := range result.Components {
+		cavity := component.EnclosedBy == 1
+		if cavity != (component.SignedVolume < 0) {
+			t.Errorf("Expected only the cavity to have negative volume, got %+v", component)
+		}
+	}
+	if result := validateShapeSurface(positions, mesh_faces, 2); !result.Valid {
+		t.Errorf("Expected shape 2 to be valid, got %+v", result)
+	}
+
+	// the cavity facing outwards, as if it were another shape, isn't valid
+	flipped := flipFaces(mesh_faces, MeshId{1, 2}, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+	if result := validateShapeSurface(positions, flipped, 1); result.Valid {
+		t.Errorf("Expected shape 1 with an outward facing cavity to be invalid")
+	}
+}
+
+func TestValidateShapes(t *testing.T) {
+	ss := boxRowShapeSet(t)
+	report := ss.ValidateShapes()
+	if !report.Valid || len(report.Shapes) != 3 {
+		t.Fatalf("Expected the 3 boxes to be valid, got %+v", report)
+	}
+	for i, shape := range report.Shapes {
+		if shape.ShapeId != ShapeId(i+1) || shape.Faces != 12 || shape.Label != ss.Shapes[shape.ShapeId] {
+			t.Errorf("Expected shape %d to be reported as a box of 12 faces, got %+v", i+1, shape)
+		}
+	}
+
+	// labelled shapes without meshes have no surface
+	ss.Shapes[4] = "missing"
+	delete(ss.Meshes, MeshId{2, 3})
+	report = ss.ValidateShapes()
+	if report.Valid || len(report.Shapes) != 4 {
+		t.Fatalf("Expected 4 shapes to be reported as invalid, got %+v", report)
+	}
+	for i, valid := range []bool{true, false, false, false} {
+		if report.Shapes[i].Valid != valid {
+			t.Errorf("Expected shape %d to be valid %t, got %+v", i+1, valid, report.Shapes[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ShapeValidationReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Valid || len(decoded.Shapes) != 4 || len(decoded.Shapes[1].OpenLoops) != 1 {
+		t.Errorf("Expected the report to survive being written as JSON, got %+v", decoded)
+	}
+}
@@ -0,0 +1,174 @@
+package shapeset
+
+import (
+	"sort"
+	"strconv"
+)
+
+/* Consistency checks of the borders index, for catching corruption of the
+ * references between borders, meshes, vertices and edges, e.g. between
+ * indexing and simplifying borders.
+ */
+
+// The kinds of inconsistency reported by VerifyBorders
+const (
+	// The border's description doesn't lead back to it through the index
+	BorderNotIndexed = "border-not-indexed"
+	// A mesh listed in Border.MeshIds doesn't exist, and wasn't just left out
+	// when reading the shapeset partially
+	BorderMeshMissing = "border-mesh-missing"
+	// A vertex of Border.Vertices has a different Border
+	VertexBorderMismatch = "vertex-border-mismatch"
+	// A mesh listed in Border.MeshIds doesn't reference a vertex of the border
+	VertexNotInMesh = "vertex-not-in-mesh"
+	// An edge of Border.Edges doesn't join two vertices of the border, or of a
+	// border it meets at a junction
+	EdgeNotOnBorder = "edge-not-on-border"
+	// An edge of Border.Edges has a different Border
+	EdgeBorderMismatch = "edge-border-mismatch"
+	// A mesh listed in Border.MeshIds doesn't have the border in Mesh.Borders
+	MeshMissingBorder = "mesh-missing-border"
+	// Mesh.Borders has a border which isn't in the index or doesn't list the mesh
+	MeshUnknownBorder = "mesh-unknown-border"
+)
+
+type BorderViolation struct {
+	Kind     string
+	BorderId BorderId
+	MeshId   *MeshId `json:",omitempty"`
+	Detail   string
+}
+
+type BorderReport struct {
+	Borders    int
+	Vertices   int
+	Edges      int
+	Violations []BorderViolation
+}
+
+func (report *BorderReport) Ok() bool {
+	return len(report.Violations) == 0
+}
+
+// Checks that the borders index and the references between borders, meshes,
+// vertices and edges are consistent, reporting every inconsistency found in
+// order of BorderId.
+func (ss *ShapeSet) VerifyBorders() (report *BorderReport) {
+	report = &BorderReport{Violations: make([]BorderViolation, 0)}
+	violation := func(kind string, b *Border, mesh_id *MeshId, detail string) {
+		report.Violations = append(report.Violations, BorderViolation{
+			Kind:     kind,
+			BorderId: b.Id,
+			MeshId:   mesh_id,
+			Detail:   detail,
+		})
+	}
+	describe := func(v *Vertex) string {
+		return "vertex at (" + strconv.FormatFloat(v.X, 'g', -1, 64) + ", " +
+			strconv.FormatFloat(v.Y, 'g', -1, 64) + ", " +
+			strconv.FormatFloat(v.Z, 'g', -1, 64) + ")"
+	}
+
+	borders := ss.sortedBorders()
+	report.Borders = len(borders)
+	for _, b := range borders {
+		desc := b.Description()
+		if ss.BordersIndex.BorderFor(desc) != b || ss.BordersIndex.BorderFor(b.Id) != b {
+			violation(BorderNotIndexed, b, nil, "border "+desc.ToString())
+		}
+
+		border_vertices := make(map[*Vertex]bool, len(b.Vertices))
+		for _, v := range b.Vertices {
+			border_vertices[v] = true
+			report.Vertices++
+			if v.Border != b {
+				violation(VertexBorderMismatch, b, nil, describe(v))
+			}
+		}
+
+		for i, _ := range b.MeshIds {
+			mesh_id := b.MeshIds[i]
+			m, exists := ss.Meshes[mesh_id]
+			if !exists && ss.unloadedMeshes[mesh_id] {
+				continue
+			} else if !exists {
+				violation(BorderMeshMissing, b, &mesh_id, "mesh "+mesh_id.ToString())
+				continue
+			}
+			if m.Borders[b.Id] != b {
+				violation(MeshMissingBorder, b, &mesh_id, "mesh "+mesh_id.ToString())
+			}
+			vertex_count := m.Vertices.Len()
+			for _, v := range b.Vertices {
+				index := v.GetLocationInMesh(&m.Mesh)
+				if index < 0 || index >= vertex_count || m.Vertices.Get(index)[0] != v {
+					violation(VertexNotInMesh, b, &mesh_id, describe(v))
+				}
+			}
+		}
+
+		for _, e := range b.Edges {
+			if e.Collapsed || e.Removed {
+				continue
+			}
+			report.Edges++
+			if !b.endsEdge(border_vertices, e.Vertex1()) || !b.endsEdge(border_vertices, e.Vertex2()) {
+				violation(EdgeNotOnBorder, b, nil,
+					"edge from "+describe(e.Vertex1())+" to "+describe(e.Vertex2()))
+			}
+			if e.Border != b {
+				violation(EdgeBorderMismatch, b, nil,
+					"edge from "+describe(e.Vertex1())+" to "+describe(e.Vertex2()))
+			}
+		}
+	}
+
+	// check the borders meshes refer to match the index, in a stable order
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(ss.Meshes))
+	for mesh_id, _ := range ss.Meshes {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+	for i, _ := range mesh_ids {
+		mesh_id := mesh_ids[i]
+		border_ids := make([]int, 0)
+		for border_id, _ := range ss.Meshes[mesh_id].Borders {
+			border_ids = append(border_ids, int(border_id))
+		}
+		sort.Ints(border_ids)
+		for _, border_num := range border_ids {
+			b := ss.Meshes[mesh_id].Borders[BorderId(border_num)]
+			listed := false
+			for _, border_mesh_id := range b.MeshIds {
+				listed = listed || border_mesh_id == mesh_id
+			}
+			if ss.BordersIndex.BorderFor(b.Id) != b || !listed {
+				violation(MeshUnknownBorder, b, &mesh_id, "mesh "+mesh_id.ToString())
+			}
+		}
+	}
+	return
+}
+
+/* Tests whether v may be the end of an edge of the border, i.e. it is one of
+ * the border's vertices, or where the border ends at a junction, a vertex of a
+ * border between a superset of its meshes.
+ */
+func (b *Border) endsEdge(border_vertices map[*Vertex]bool, v *Vertex) bool {
+	if border_vertices[v] {
+		return true
+	}
+	if v.Border == nil {
+		return false
+	}
+	for _, mesh_id := range b.MeshIds {
+		found := false
+		for _, other_mesh_id := range v.Border.MeshIds {
+			found = found || other_mesh_id == mesh_id
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package shapeset
+
+import (
+	"testing"
+)
+
+func violationKinds(report *BorderReport) map[string]int {
+	kinds := make(map[string]int)
+	for _, violation := range report.Violations {
+		kinds[violation.Kind]++
+	}
+	return kinds
+}
+
+func TestVerifyBorders(t *testing.T) {
+	report := boxRowShapeSet(t).VerifyBorders()
+	if !report.Ok() {
+		t.Fatalf("Expected the borders of the boxes to be consistent, got %+v", report.Violations)
+	}
+	if report.Borders != 2 || report.Vertices != 8 || report.Edges == 0 {
+		t.Errorf("Expected 2 borders of 4 vertices each, got %d borders, %d vertices and %d edges",
+			report.Borders, report.Vertices, report.Edges)
+	}
+}
+
+func TestVerifyBordersReportsViolations(t *testing.T) {
+	for kind, corrupt := range map[string]func(ss *ShapeSet, first, second *Border){
+		BorderNotIndexed: func(ss *ShapeSet, first, second *Border) {
+			first.Id = 99
+		},
+		BorderMeshMissing: func(ss *ShapeSet, first, second *Border) {
+			delete(ss.Meshes, MeshId{1, 2})
+		},
+		VertexBorderMismatch: func(ss *ShapeSet, first, second *Border) {
+			first.Vertices[0].Border = second
+		},
+		VertexNotInMesh: func(ss *ShapeSet, first, second *Border) {
+			first.Vertices = append(first.Vertices, second.Vertices[0])
+		},
+		EdgeNotOnBorder: func(ss *ShapeSet, first, second *Border) {
+			first.Edges = append(first.Edges, second.Edges[0])
+		},
+		EdgeBorderMismatch: func(ss *ShapeSet, first, second *Border) {
+			first.Edges[0].Border = second
+		},
+		MeshMissingBorder: func(ss *ShapeSet, first, second *Border) {
+			delete(ss.Meshes[MeshId{0, 1}].Borders, first.Id)
+		},
+		MeshUnknownBorder: func(ss *ShapeSet, first, second *Border) {
+			ss.Meshes[MeshId{0, 3}].Borders[first.Id] = first
+		},
+	} {
+		ss := boxRowShapeSet(t)
+		borders := ss.sortedBorders()
+		corrupt(ss, borders[0], borders[1])
+		report := ss.VerifyBorders()
+		if report.Ok() || violationKinds(report)[kind] == 0 {
+			t.Errorf("Expected a %s violation, got %+v", kind, report.Violations)
+			continue
+		}
+		for _, violation := range report.Violations {
+			if violation.Kind == kind && violation.BorderId != borders[0].Id {
+				t.Errorf("Expected the %s violation to be of border %d, got %d",
+					kind, borders[0].Id, violation.BorderId)
+			}
+		}
+	}
+}
@@ -0,0 +1,800 @@
+package shapeset
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* A regular grid of shape labels, indexed with x varying fastest. Voxel
+ * centers lie at Origin + Spacing * index. RAS is set if world coordinates are
+ * known to be right-anterior-superior, as for volumes read from files which
+ * declare their space.
+ */
+type LabelVolume struct {
+	Dims    [3]int
+	Spacing [3]float64
+	Origin  [3]float64
+	Labels  []ShapeId
+	RAS     bool
+}
+
+// The largest number of voxels a label volume may have
+const maxLabelVolumeVoxels = math.MaxInt32
+
+// Counts the voxels of a volume with the given dimensions, rejecting any whose
+// product is larger than maxLabelVolumeVoxels, which also rules out overflow.
+func voxelCount(dims [3]int) (count int, err error) {
+	count = 1
+	for _, dim := range dims {
+		if dim < 1 {
+			err = errors.New("Volume dimensions must be positive")
+			return
+		}
+		if dim > maxLabelVolumeVoxels/count {
+			err = errors.New("Volume dimensions exceed " +
+				strconv.Itoa(maxLabelVolumeVoxels) + " voxels")
+			return
+		}
+		count *= dim
+	}
+	return
+}
+
+func NewLabelVolume(dims [3]int, spacing, origin [3]float64) (lv *LabelVolume, err error) {
+	count, err := voxelCount(dims)
+	if err != nil {
+		return
+	}
+	lv = &LabelVolume{
+		Dims:    dims,
+		Spacing: spacing,
+		Origin:  origin,
+		Labels:  make([]ShapeId, count),
+	}
+	return
+}
+
+func (lv *LabelVolume) index(x, y, z int) int {
+	return x + lv.Dims[0]*(y+lv.Dims[1]*z)
+}
+
+// Returns the label at the given voxel, voxels outside of the volume are
+// considered to be exterior, i.e. label 0.
+func (lv *LabelVolume) At(x, y, z int) ShapeId {
+	if x < 0 || y < 0 || z < 0 || x >= lv.Dims[0] || y >= lv.Dims[1] || z >= lv.Dims[2] {
+		return 0
+	}
+	return lv.Labels[lv.index(x, y, z)]
+}
+
+func (lv *LabelVolume) Set(x, y, z int, label ShapeId) {
+	lv.Labels[lv.index(x, y, z)] = label
+}
+
+// Returns the world position of the center of the given voxel, which may lie
+// outside the volume.
+func (lv *LabelVolume) VoxelCenter(x, y, z float64) [3]float64 {
+	return [3]float64{
+		lv.Origin[0] + lv.Spacing[0]*x,
+		lv.Origin[1] + lv.Spacing[1]*y,
+		lv.Origin[2] + lv.Spacing[2]*z,
+	}
+}
+
+// Reads a label volume from a NRRD (.nrrd, .nhdr) or NIfTI-1 (.nii, .nii.gz)
+// file.
+func ReadLabelVolumeFile(volume_file_path string) (lv *LabelVolume, err error) {
+	lower_path := strings.ToLower(volume_file_path)
+	switch {
+	case strings.HasSuffix(lower_path, ".nrrd"), strings.HasSuffix(lower_path, ".nhdr"):
+		return ReadNRRDFile(volume_file_path)
+	case strings.HasSuffix(lower_path, ".nii"), strings.HasSuffix(lower_path, ".nii.gz"):
+		return ReadNIfTIFile(volume_file_path)
+	}
+	err = errors.New("Unsupported volume file type: " + volume_file_path)
+	return
+}
+
+// Describes how voxel values of a given scalar type are decoded
+type voxelType struct {
+	Size   int
+	Decode func(order binary.ByteOrder, b []byte) float64
+}
+
+var voxelTypes = map[string]voxelType{
+	"int8":    {1, func(o binary.ByteOrder, b []byte) float64 { return float64(int8(b[0])) }},
+	"uint8":   {1, func(o binary.ByteOrder, b []byte) float64 { return float64(b[0]) }},
+	"int16":   {2, func(o binary.ByteOrder, b []byte) float64 { return float64(int16(o.Uint16(b))) }},
+	"uint16":  {2, func(o binary.ByteOrder, b []byte) float64 { return float64(o.Uint16(b)) }},
+	"int32":   {4, func(o binary.ByteOrder, b []byte) float64 { return float64(int32(o.Uint32(b))) }},
+	"uint32":  {4, func(o binary.ByteOrder, b []byte) float64 { return float64(o.Uint32(b)) }},
+	"int64":   {8, func(o binary.ByteOrder, b []byte) float64 { return float64(int64(o.Uint64(b))) }},
+	"uint64":  {8, func(o binary.ByteOrder, b []byte) float64 { return float64(o.Uint64(b)) }},
+	"float32": {4, func(o binary.ByteOrder, b []byte) float64 { return float64(math.Float32frombits(o.Uint32(b))) }},
+	"float64": {8, func(o binary.ByteOrder, b []byte) float64 { return math.Float64frombits(o.Uint64(b)) }},
+}
+
+/* Reads a volume of the given dimensions from voxel values of the given type,
+ * rounding any non integer values to the nearest label. The data is read
+ * before the volume is allocated, so that dimensions which the data doesn't
+ * fill are reported rather than allocated.
+ */
+func readLabelVolume(
+	r io.Reader,
+	type_name string,
+	order binary.ByteOrder,
+	dims [3]int,
+	spacing, origin [3]float64,
+) (lv *LabelVolume, err error) {
+	vt, known := voxelTypes[type_name]
+	if !known {
+		err = errors.New("Unsupported voxel type: " + type_name)
+		return
+	}
+	count, err := voxelCount(dims)
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(count)*int64(vt.Size)))
+	if err != nil {
+		return
+	}
+	if len(data) < count*vt.Size {
+		err = errors.New("Volume data ended after " + strconv.Itoa(len(data)/vt.Size) +
+			" of " + strconv.Itoa(count) + " voxels")
+		return
+	}
+	if lv, err = NewLabelVolume(dims, spacing, origin); err != nil {
+		return
+	}
+	for i := range lv.Labels {
+		lv.Labels[i] = ShapeId(math.Floor(vt.Decode(order, data[i*vt.Size:]) + 0.5))
+	}
+	return
+}
+
+// Wraps r in a gzip reader if its content starts with the gzip magic bytes
+func maybeGunzip(r *bufio.Reader) (io.Reader, error) {
+	head, err := r.Peek(2)
+	if err == nil && head[0] == 0x1f && head[1] == 0x8b {
+		return gzip.NewReader(r)
+	}
+	return r, nil
+}
+
+var nrrdTypes = map[string]string{
+	"signed char": "int8", "int8": "int8", "int8_t": "int8",
+	"uchar": "uint8", "unsigned char": "uint8", "uint8": "uint8", "uint8_t": "uint8",
+	"short": "int16", "short int": "int16", "signed short": "int16",
+	"signed short int": "int16", "int16": "int16", "int16_t": "int16",
+	"ushort": "uint16", "unsigned short": "uint16", "unsigned short int": "uint16",
+	"uint16": "uint16", "uint16_t": "uint16",
+	"int": "int32", "signed int": "int32", "int32": "int32", "int32_t": "int32",
+	"uint": "uint32", "unsigned int": "uint32", "uint32": "uint32", "uint32_t": "uint32",
+	"longlong": "int64", "long long": "int64", "long long int": "int64",
+	"signed long long": "int64", "signed long long int": "int64",
+	"int64": "int64", "int64_t": "int64",
+	"ulonglong": "uint64", "unsigned long long": "uint64",
+	"unsigned long long int": "uint64", "uint64": "uint64", "uint64_t": "uint64",
+	"float": "float32", "double": "float64",
+}
+
+var nrrdVectorPattern = regexp.MustCompile(`\([^)]*\)|none`)
+
+// The signs which convert world coordinates of each supported NRRD space to
+// right-anterior-superior
+var nrrdSpaceSigns = map[string][3]float64{
+	"right-anterior-superior": {1, 1, 1},
+	"ras":                     {1, 1, 1},
+	"left-anterior-superior":  {-1, 1, 1},
+	"las":                     {-1, 1, 1},
+	"left-posterior-superior": {-1, -1, 1},
+	"lps":                     {-1, -1, 1},
+}
+
+// Parses a NRRD vector such as (1.5,0,0)
+func parseNRRDVector(s string) (vec []float64, err error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		err = errors.New("Malformed NRRD vector: " + s)
+		return
+	}
+	return parseCSFloats(strings.Replace(s[1:len(s)-1], " ", "", -1))
+}
+
+func ReadNRRDFile(nrrd_file_path string) (lv *LabelVolume, err error) {
+	input_file, err := os.Open(nrrd_file_path)
+	if err != nil {
+		return
+	}
+	defer input_file.Close()
+	r := bufio.NewReader(input_file)
+
+	magic, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(magic, "NRRD000") {
+		err = errors.New("Not a NRRD file: " + nrrd_file_path)
+		return
+	}
+
+	// parse header fields up to the blank line which seperates the data
+	fields := make(map[string]string)
+	for {
+		var line string
+		line, err = r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if err != nil {
+			err = errors.New("Unexpected end of NRRD header: " + nrrd_file_path)
+			return
+		}
+		if strings.HasPrefix(line, "#") || strings.Contains(line, ":=") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			err = errors.New("Malformed NRRD header line: " + line)
+			return
+		}
+		fields[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	err = nil
+
+	if fields["dimension"] != "3" {
+		err = errors.New("Only 3 dimensional NRRD volumes are supported")
+		return
+	}
+	var dims [3]int
+	sizes := strings.Fields(fields["sizes"])
+	if len(sizes) != 3 {
+		err = errors.New("Malformed NRRD sizes: " + fields["sizes"])
+		return
+	}
+	for i, size := range sizes {
+		if dims[i], err = strconv.Atoi(size); err != nil || dims[i] < 1 {
+			err = errors.New("Malformed NRRD sizes: " + fields["sizes"])
+			return
+		}
+	}
+
+	directions := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	var origin [3]float64
+	if spacings, exists := fields["spacings"]; exists {
+		for i, s := range strings.Fields(spacings) {
+			if v, e := strconv.ParseFloat(s, 64); e == nil && i < 3 && !math.IsNaN(v) {
+				directions[i][i] = v
+			}
+		}
+	}
+	if space_directions, exists := fields["space directions"]; exists {
+		vectors := nrrdVectorPattern.FindAllString(space_directions, -1)
+		if len(vectors) != 3 {
+			err = errors.New("Malformed NRRD space directions: " + space_directions)
+			return
+		}
+		for i, dir := range vectors {
+			vec, e := parseNRRDVector(dir)
+			if e != nil || len(vec) != 3 {
+				err = errors.New("Malformed NRRD space directions: " + space_directions)
+				return
+			}
+			copy(directions[i][:], vec)
+		}
+	}
+	if space_origin, exists := fields["space origin"]; exists {
+		vec, e := parseNRRDVector(space_origin)
+		if e != nil || len(vec) != 3 {
+			err = errors.New("Malformed NRRD space origin: " + space_origin)
+			return
+		}
+		copy(origin[:], vec)
+	}
+
+	// world coordinates are converted to RAS where the space is given, volumes
+	// without a space are read as they are
+	space, has_space := fields["space"]
+	if has_space {
+		signs, known := nrrdSpaceSigns[strings.ToLower(space)]
+		if !known {
+			err = errors.New("Unsupported NRRD space: " + space)
+			return
+		}
+		for axis, sign := range signs {
+			for i := range directions {
+				directions[i][axis] *= sign
+			}
+			origin[axis] *= sign
+		}
+	}
+	spacing, origin, flipped, err := axisAlignedGrid(dims, directions, origin)
+	if err != nil {
+		return
+	}
+
+	type_name, known := nrrdTypes[fields["type"]]
+	if !known {
+		err = errors.New("Unsupported NRRD type: " + fields["type"])
+		return
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if fields["endian"] == "big" {
+		order = binary.BigEndian
+	}
+
+	// data is either attached after the header or in a detached file
+	var data io.Reader = r
+	data_file := fields["data file"]
+	if data_file == "" {
+		data_file = fields["datafile"]
+	}
+	if data_file != "" {
+		if !filepath.IsAbs(data_file) {
+			data_file = filepath.Join(filepath.Dir(nrrd_file_path), data_file)
+		}
+		var detached *os.File
+		detached, err = os.Open(data_file)
+		if err != nil {
+			return
+		}
+		defer detached.Close()
+		data = bufio.NewReader(detached)
+	}
+
+	switch fields["encoding"] {
+	case "raw":
+		lv, err = readLabelVolume(data, type_name, order, dims, spacing, origin)
+	case "gzip", "gz":
+		if data, err = gzip.NewReader(data); err != nil {
+			return
+		}
+		lv, err = readLabelVolume(data, type_name, order, dims, spacing, origin)
+	case "ascii", "text", "txt":
+		var text []byte
+		if text, err = ioutil.ReadAll(data); err != nil {
+			return
+		}
+		values := strings.Fields(string(text))
+		var count int
+		if count, err = voxelCount(dims); err != nil {
+			return
+		}
+		if len(values) < count {
+			err = errors.New("NRRD volume has too few values")
+			return
+		}
+		if lv, err = NewLabelVolume(dims, spacing, origin); err != nil {
+			return
+		}
+		for i := range lv.Labels {
+			var v float64
+			if v, err = strconv.ParseFloat(values[i], 64); err != nil {
+				return
+			}
+			lv.Labels[i] = ShapeId(math.Floor(v + 0.5))
+		}
+	default:
+		err = errors.New("Unsupported NRRD encoding: " + fields["encoding"])
+		return
+	}
+	if err == nil {
+		lv.flipAxes(flipped)
+		lv.RAS = has_space
+	}
+	return
+}
+
+var niftiTypes = map[int16]string{
+	2: "uint8", 4: "int16", 8: "int32", 16: "float32", 64: "float64",
+	256: "int8", 512: "uint16", 768: "uint32", 1024: "int64", 1280: "uint64",
+}
+
+// Reads a single file NIfTI-1 volume, which may be gzipped
+func ReadNIfTIFile(nifti_file_path string) (lv *LabelVolume, err error) {
+	input_file, err := os.Open(nifti_file_path)
+	if err != nil {
+		return
+	}
+	defer input_file.Close()
+	r, err := maybeGunzip(bufio.NewReader(input_file))
+	if err != nil {
+		return
+	}
+
+	header := make([]byte, 348)
+	if _, err = io.ReadFull(r, header); err != nil {
+		err = errors.New("Could not read NIfTI header: " + nifti_file_path)
+		return
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(header[0:4]) != 348 {
+		order = binary.BigEndian
+		if order.Uint32(header[0:4]) != 348 {
+			err = errors.New("Not a NIfTI-1 file: " + nifti_file_path)
+			return
+		}
+	}
+	float32At := func(offset int) float64 {
+		return float64(math.Float32frombits(order.Uint32(header[offset:])))
+	}
+	int16At := func(offset int) int16 {
+		return int16(order.Uint16(header[offset:]))
+	}
+
+	if ndim := int16At(40); ndim < 3 || ndim > 4 || (ndim == 4 && int16At(48) > 1) {
+		err = errors.New("Only 3 dimensional NIfTI volumes are supported")
+		return
+	}
+	var dims [3]int
+	pixdims := [3]float64{1, 1, 1}
+	for i := 0; i < 3; i++ {
+		dims[i] = int(int16At(42 + i*2))
+		if dims[i] < 1 {
+			err = errors.New("Malformed NIfTI dimensions: " + nifti_file_path)
+			return
+		}
+		if pixdim := math.Abs(float32At(80 + i*4)); pixdim > 0 {
+			pixdims[i] = pixdim
+		}
+	}
+
+	// prefer the sform affine transform where given, otherwise the rotation of
+	// the qform quaternion, otherwise just the voxel sizes
+	var directions [3][3]float64
+	var origin [3]float64
+	switch {
+	case int16At(254) > 0:
+		for i := 0; i < 3; i++ {
+			for row := 0; row < 3; row++ {
+				directions[i][row] = float32At(280 + row*16 + i*4)
+			}
+			origin[i] = float32At(280 + i*16 + 12)
+		}
+	case int16At(252) > 0:
+		b, c, d := float32At(256), float32At(260), float32At(264)
+		a := math.Sqrt(math.Max(0, 1-b*b-c*c-d*d))
+		rotation := [3][3]float64{
+			{a*a + b*b - c*c - d*d, 2 * (b*c - a*d), 2 * (b*d + a*c)},
+			{2 * (b*c + a*d), a*a + c*c - b*b - d*d, 2 * (c*d - a*b)},
+			{2 * (b*d - a*c), 2 * (c*d + a*b), a*a + d*d - b*b - c*c},
+		}
+		// qfac, stored in pixdim[0], reverses the third axis if negative
+		qfac := 1.0
+		if float32At(76) < 0 {
+			qfac = -1
+		}
+		for i := 0; i < 3; i++ {
+			scale := pixdims[i]
+			if i == 2 {
+				scale *= qfac
+			}
+			for row := 0; row < 3; row++ {
+				directions[i][row] = rotation[row][i] * scale
+			}
+		}
+		origin = [3]float64{float32At(268), float32At(272), float32At(276)}
+	default:
+		for i := 0; i < 3; i++ {
+			directions[i][i] = pixdims[i]
+		}
+	}
+	spacing, origin, flipped, err := axisAlignedGrid(dims, directions, origin)
+	if err != nil {
+		return
+	}
+
+	type_name, known := niftiTypes[int16At(70)]
+	if !known {
+		err = errors.New("Unsupported NIfTI datatype: " + strconv.Itoa(int(int16At(70))))
+		return
+	}
+
+	// skip any extensions between the header and the voxel data
+	vox_offset := int64(float32At(108))
+	if vox_offset > 348 {
+		if _, err = io.CopyN(ioutil.Discard, r, vox_offset-348); err != nil {
+			return
+		}
+	}
+
+	if lv, err = readLabelVolume(r, type_name, order, dims, spacing, origin); err == nil {
+		lv.flipAxes(flipped)
+		// either transform maps onto RAS coordinates
+		lv.RAS = int16At(252) > 0 || int16At(254) > 0
+	}
+	return
+}
+
+/* Resolves the world space step along each axis of a grid to a positive
+ * spacing, moving the origin to the far end of any axis which runs backwards so
+ * that its voxels can be flipped into order. Grids which are rotated or whose
+ * axes are permuted relative to the world axes aren't supported.
+ */
+func axisAlignedGrid(
+	dims [3]int,
+	directions [3][3]float64,
+	origin [3]float64,
+) (spacing, aligned_origin [3]float64, flipped [3]bool, err error) {
+	aligned_origin = origin
+	for i, dir := range directions {
+		for axis, step := range dir {
+			// allow for rounding in directions stored as float32
+			if axis != i && math.Abs(step) > 1e-6*math.Abs(dir[i]) {
+				err = errors.New("Only volumes with axes aligned to the world axes are supported")
+				return
+			}
+		}
+		if dir[i] == 0 || math.IsNaN(dir[i]) || math.IsInf(dir[i], 0) {
+			err = errors.New("Volume spacing must be finite and non-zero")
+			return
+		}
+		spacing[i] = math.Abs(dir[i])
+		if dir[i] < 0 {
+			flipped[i] = true
+			aligned_origin[i] += dir[i] * float64(dims[i]-1)
+		}
+	}
+	return
+}
+
+// Reverses the order of voxels along each flipped axis
+func (lv *LabelVolume) flipAxes(flipped [3]bool) {
+	if !flipped[0] && !flipped[1] && !flipped[2] {
+		return
+	}
+	labels := make([]ShapeId, len(lv.Labels))
+	for z := 0; z < lv.Dims[2]; z++ {
+		for y := 0; y < lv.Dims[1]; y++ {
+			for x := 0; x < lv.Dims[0]; x++ {
+				from := [3]int{x, y, z}
+				for axis := range from {
+					if flipped[axis] {
+						from[axis] = lv.Dims[axis] - 1 - from[axis]
+					}
+				}
+				labels[lv.index(x, y, z)] = lv.Labels[lv.index(from[0], from[1], from[2])]
+			}
+		}
+	}
+	lv.Labels = labels
+}
+
+// Lists the distinct labels which occur in the volume
+func (lv *LabelVolume) DistinctLabels() (labels []ShapeId) {
+	seen := make(map[ShapeId]bool)
+	for _, label := range lv.Labels {
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	return
+}
+
+// Chooses the smallest integer voxel type able to hold every label
+func (lv *LabelVolume) labelType() string {
+	min_label, max_label := ShapeId(0), ShapeId(0)
+	for _, label := range lv.Labels {
+		if label < min_label {
+			min_label = label
+		}
+		if label > max_label {
+			max_label = label
+		}
+	}
+	switch {
+	case min_label >= 0 && max_label <= math.MaxUint8:
+		return "uint8"
+	case min_label >= 0 && max_label <= math.MaxUint16:
+		return "uint16"
+	case min_label >= math.MinInt16 && max_label <= math.MaxInt16:
+		return "int16"
+	}
+	return "int32"
+}
+
+// Encodes the labels of lv as little endian values of the given type
+func (lv *LabelVolume) encodeLabels(w io.Writer, type_name string) (err error) {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, voxelTypes[type_name].Size)
+	for _, label := range lv.Labels {
+		switch type_name {
+		case "uint8":
+			buf[0] = byte(label)
+		case "uint16", "int16":
+			binary.LittleEndian.PutUint16(buf, uint16(label))
+		default:
+			binary.LittleEndian.PutUint32(buf, uint32(label))
+		}
+		if _, err = bw.Write(buf); err != nil {
+			return
+		}
+	}
+	return bw.Flush()
+}
+
+// Writes a label volume as a NRRD (.nrrd) or NIfTI-1 (.nii, .nii.gz) file
+func WriteLabelVolumeFile(lv *LabelVolume, volume_file_path string) (err error) {
+	lower_path := strings.ToLower(volume_file_path)
+	switch {
+	case strings.HasSuffix(lower_path, ".nrrd"):
+		return WriteNRRDFile(lv, volume_file_path)
+	case strings.HasSuffix(lower_path, ".nii"), strings.HasSuffix(lower_path, ".nii.gz"):
+		return WriteNIfTIFile(lv, volume_file_path)
+	}
+	err = errors.New("Unsupported volume file type: " + volume_file_path)
+	return
+}
+
+// Writes a label volume as a gzip encoded NRRD file with attached data, which
+// only declares its space if the volume is RAS.
+func WriteNRRDFile(lv *LabelVolume, nrrd_file_path string) (err error) {
+	type_name := lv.labelType()
+	return writeNRRDFile(nrrd_file_path, lv.Dims, lv.Spacing, lv.Origin, lv.RAS, type_name,
+		func(w io.Writer) error { return lv.encodeLabels(w, type_name) })
+}
+
+// Writes a label volume as a single file NIfTI-1 volume, gzipped if the path
+// ends with .gz
+func WriteNIfTIFile(lv *LabelVolume, nifti_file_path string) (err error) {
+	type_name := lv.labelType()
+	return writeNIfTIFile(nifti_file_path, lv.Dims, lv.Spacing, lv.Origin, type_name,
+		func(w io.Writer) error { return lv.encodeLabels(w, type_name) })
+}
+
+// Writes a gzip encoded NRRD file of the given grid, with attached voxel data
+// of the given type written by encode in little endian order. The space is
+// declared as RAS if ras is set, otherwise just its dimension is given.
+func writeNRRDFile(
+	nrrd_file_path string,
+	dims [3]int,
+	spacing, origin [3]float64,
+	ras bool,
+	type_name string,
+	encode func(io.Writer) error,
+) (err error) {
+	output_file, err := os.Create(nrrd_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	bw := bufio.NewWriter(output_file)
+
+	format := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	// NRRD names floating point types as C does
+	nrrd_type := type_name
+	switch type_name {
+	case "float32":
+		nrrd_type = "float"
+	case "float64":
+		nrrd_type = "double"
+	}
+	space := "space dimension: 3"
+	if ras {
+		space = "space: right-anterior-superior"
+	}
+	header := []string{
+		"NRRD0004",
+		"type: " + nrrd_type,
+		"dimension: 3",
+		space,
+		"sizes: " + strconv.Itoa(dims[0]) + " " + strconv.Itoa(dims[1]) + " " +
+			strconv.Itoa(dims[2]),
+		"space directions: (" + format(spacing[0]) + ",0,0) (0," +
+			format(spacing[1]) + ",0) (0,0," + format(spacing[2]) + ")",
+		"kinds: domain domain domain",
+		"endian: little",
+		"encoding: gzip",
+		"space origin: (" + format(origin[0]) + "," + format(origin[1]) + "," +
+			format(origin[2]) + ")",
+	}
+	if _, err = bw.WriteString(strings.Join(header, "\n") + "\n\n"); err != nil {
+		return
+	}
+
+	gw := gzip.NewWriter(bw)
+	if err = encode(gw); err != nil {
+		return
+	}
+	if err = gw.Close(); err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+var niftiDatatypes = map[string]int16{
+	"uint8": 2, "int16": 4, "int32": 8, "float32": 16, "uint16": 512,
+}
+
+// Writes a single file NIfTI-1 volume of the given grid, gzipped if the path
+// ends with .gz, with voxel data of the given type written by encode in little
+// endian order.
+func writeNIfTIFile(
+	nifti_file_path string,
+	dims [3]int,
+	spacing, origin [3]float64,
+	type_name string,
+	encode func(io.Writer) error,
+) (err error) {
+	// NIfTI-1 stores dimensions as int16
+	for _, dim := range dims {
+		if dim > math.MaxInt16 {
+			err = errors.New("NIfTI-1 volumes are limited to " +
+				strconv.Itoa(math.MaxInt16) + " voxels along each axis, not " +
+				strconv.Itoa(dim))
+			return
+		}
+	}
+
+	output_file, err := os.Create(nifti_file_path)
+	if err != nil {
+		return
+	}
+	defer output_file.Close()
+	bw := bufio.NewWriter(output_file)
+	var w io.Writer = bw
+	var gw *gzip.Writer
+	if strings.HasSuffix(strings.ToLower(nifti_file_path), ".gz") {
+		gw = gzip.NewWriter(bw)
+		w = gw
+	}
+
+	header := make([]byte, 352) // the header followed by an empty extension flag
+	order := binary.LittleEndian
+	putFloat32 := func(offset int, x float64) {
+		order.PutUint32(header[offset:], math.Float32bits(float32(x)))
+	}
+	putInt16 := func(offset int, x int16) {
+		order.PutUint16(header[offset:], uint16(x))
+	}
+
+	order.PutUint32(header[0:], 348)
+	putInt16(40, 3)
+	for i := 0; i < 3; i++ {
+		putInt16(42+i*2, int16(dims[i]))
+		putFloat32(80+i*4, spacing[i])
+	}
+	for i := 3; i < 8; i++ {
+		putInt16(42+i*2, 1)
+	}
+	putInt16(70, niftiDatatypes[type_name])
+	putInt16(72, int16(voxelTypes[type_name].Size*8))
+	putFloat32(76, 1)    // qfac
+	putFloat32(108, 352) // vox_offset
+	putFloat32(112, 1)   // scl_slope
+	header[123] = 2      // xyzt_units: mm
+	putInt16(252, 1)     // qform_code: scanner
+	putInt16(254, 1)     // sform_code: scanner
+	for i := 0; i < 3; i++ {
+		putFloat32(268+i*4, origin[i])
+		// rows of the affine transform from voxel indices to world coordinates
+		putFloat32(280+i*16+i*4, spacing[i])
+		putFloat32(280+i*16+12, origin[i])
+	}
+	copy(header[344:], "n+1\x00")
+
+	if _, err = w.Write(header); err != nil {
+		return
+	}
+	if err = encode(w); err != nil {
+		return
+	}
+	if gw != nil {
+		if err = gw.Close(); err != nil {
+			return
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,199 @@
+package shapeset
+
+import (
+	"github.com/nat-n/geom"
+	"sort"
+	"strconv"
+)
+
+/* Builds a shapeset from a label volume, extracting an interface mesh for
+ * every pair of labels which are adjacent in the volume. Voxels outside of
+ * the volume are treated as the exterior, label 0, so that every shape is
+ * enclosed.
+ *
+ * Meshes are extracted with a multi-label variant of surface nets: each cell
+ * of the dual grid (the cube between 8 neighbouring voxel centers) which
+ * straddles more than one label gets a single vertex, at the mean of the
+ * midpoints of its edges which cross between labels. Every pair of face
+ * adjacent voxels with different labels then contributes a quad joining the
+ * vertices of the 4 cells around it to the mesh for that pair of labels.
+ * Since each cell vertex is computed once and shared by every mesh passing
+ * through that cell, vertices where three or more shapes meet are colocated
+ * exactly across meshes, ready for IndexBorders.
+ *
+ * Faces are wound so that their normals point towards the lower ShapeId, as
+ * assumed by ComposeRegion.
+ */
+func FromLabelVolume(name string, lv *LabelVolume, labels map[string]string) (ss *ShapeSet) {
+	extractor := &surfaceNetsExtractor{
+		volume:      lv,
+		cellVerts:   make(map[int]geom.Vec3),
+		meshBuffers: make(map[MeshId]*extractedMesh),
+	}
+	extractor.extract()
+
+	// ensure every shape present in the volume has a label
+	shape_labels := make(map[string]string)
+	for shape_id_str, shape_label := range labels {
+		shape_labels[shape_id_str] = shape_label
+	}
+	for _, label := range lv.DistinctLabels() {
+		label_str := strconv.Itoa(int(label))
+		if _, exists := shape_labels[label_str]; !exists && label != 0 {
+			shape_labels[label_str] = label_str
+		}
+	}
+
+	mesh_ids := make(ByMeshIdPrecedence, 0, len(extractor.meshBuffers))
+	for mesh_id, _ := range extractor.meshBuffers {
+		mesh_ids = append(mesh_ids, mesh_id)
+	}
+	sort.Sort(mesh_ids)
+
+	meshes := make([]*Mesh, 0, len(mesh_ids))
+	for _, mesh_id := range mesh_ids {
+		buffers := extractor.meshBuffers[mesh_id]
+		m, err := meshFromBuffers(mesh_id.ToString(), buffers.Positions, buffers.Triangles)
+		if err != nil {
+			// buffers are constructed consistently, so this can't happen
+			panic(err)
+		}
+		meshes = append(meshes, WrapMesh(m))
+	}
+
+	ss = New(name, shape_labels, meshes)
+	return
+}
+
+type extractedMesh struct {
+	Positions []geom.Vec3
+	Triangles [][3]int
+	cellIndex map[int]int
+}
+
+type surfaceNetsExtractor struct {
+	volume      *LabelVolume
+	cellVerts   map[int]geom.Vec3
+	meshBuffers map[MeshId]*extractedMesh
+}
+
+// Cells are identified by the voxel at their minimum corner, which ranges from
+// -1 to Dims-1 on each axis to cover the padding around the volume.
+func (sn *surfaceNetsExtractor) cellKey(c [3]int) int {
+	d := sn.volume.Dims
+	return (c[0] + 1) + (d[0]+1)*((c[1]+1)+(d[1]+1)*(c[2]+1))
+}
+
+// Calculates, or looks up, the position of the vertex for the given cell
+func (sn *surfaceNetsExtractor) cellVertex(c [3]int) geom.Vec3 {
+	key := sn.cellKey(c)
+	if vert, exists := sn.cellVerts[key]; exists {
+		return vert
+	}
+
+	var sum [3]float64
+	crossings := 0
+	for axis := 0; axis < 3; axis++ {
+		u, v := (axis+1)%3, (axis+2)%3
+		for a := 0; a < 2; a++ {
+			for b := 0; b < 2; b++ {
+				p := c
+				p[u] += a
+				p[v] += b
+				q := p
+				q[axis]++
+				if sn.volume.At(p[0], p[1], p[2]) == sn.volume.At(q[0], q[1], q[2]) {
+					continue
+				}
+				for i := 0; i < 3; i++ {
+					sum[i] += float64(p[i]+q[i]) / 2
+				}
+				crossings++
+			}
+		}
+	}
+
+	var vert geom.Vec3
+	if crossings > 0 {
+		pos := sn.volume.VoxelCenter(
+			sum[0]/float64(crossings),
+			sum[1]/float64(crossings),
+			sum[2]/float64(crossings),
+		)
+		vert = geom.Vec3{pos[0], pos[1], pos[2]}
+	}
+	sn.cellVerts[key] = vert
+	return vert
+}
+
+// Returns the index of the vertex for the given cell within the given mesh,
+// adding it to the mesh if necessary.
+func (sn *surfaceNetsExtractor) meshVertex(em *extractedMesh, c [3]int) int {
+	key := sn.cellKey(c)
+	if index, exists := em.cellIndex[key]; exists {
+		return index
+	}
+	index := len(em.Positions)
+	em.Positions = append(em.Positions, sn.cellVertex(c))
+	em.cellIndex[key] = index
+	return index
+}
+
+func (sn *surfaceNetsExtractor) extract() {
+	dims := sn.volume.Dims
+	for axis := 0; axis < 3; axis++ {
+		u, v := (axis+1)%3, (axis+2)%3
+		// visit every pair of voxels adjacent along axis, including pairs with
+		// one voxel in the padding around the volume
+		var p [3]int
+		for p[2] = -1; p[2] < dims[2]; p[2]++ {
+			for p[1] = -1; p[1] < dims[1]; p[1]++ {
+				for p[0] = -1; p[0] < dims[0]; p[0]++ {
+					if p[u] < 0 || p[v] < 0 {
+						// both voxels of such a pair are in the padding
+						continue
+					}
+					q := p
+					q[axis]++
+					label_p := sn.volume.At(p[0], p[1], p[2])
+					label_q := sn.volume.At(q[0], q[1], q[2])
+					if label_p == label_q {
+						continue
+					}
+					sn.addQuad(p, axis, u, v, label_p, label_q)
+				}
+			}
+		}
+	}
+}
+
+// Adds the quad which seperates voxel p from its neighbour along axis
+func (sn *surfaceNetsExtractor) addQuad(p [3]int, axis, u, v int, label_p, label_q ShapeId) {
+	mesh_id := MeshId{label_p, label_q}
+	if label_q < label_p {
+		mesh_id = MeshId{label_q, label_p}
+	}
+	em, exists := sn.meshBuffers[mesh_id]
+	if !exists {
+		em = &extractedMesh{cellIndex: make(map[int]int)}
+		sn.meshBuffers[mesh_id] = em
+	}
+
+	// the four cells around the edge from p to q, counterclockwise in the uv
+	// plane so that the quad faces along +axis, i.e. from p towards q
+	corner := func(a, b int) int {
+		c := p
+		c[u] -= a
+		c[v] -= b
+		return sn.meshVertex(em, c)
+	}
+	quad := [4]int{corner(1, 1), corner(0, 1), corner(0, 0), corner(1, 0)}
+	if label_p < label_q {
+		// face towards p, the lower ShapeId
+		quad[1], quad[3] = quad[3], quad[1]
+	}
+	em.Triangles = append(em.Triangles,
+		[3]int{quad[0], quad[1], quad[2]},
+		[3]int{quad[0], quad[2], quad[3]},
+	)
+}
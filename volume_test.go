@@ -0,0 +1,257 @@
+package shapeset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes a NRRD file with the given header fields followed by data
+func writeNRRDFixture(t *testing.T, fields []string, data []byte) string {
+	nrrd_file_path := filepath.Join(t.TempDir(), "labels.nrrd")
+	header := "NRRD0004\n# labels\n" + strings.Join(fields, "\n") + "\n\n"
+	if err := os.WriteFile(nrrd_file_path, append([]byte(header), data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return nrrd_file_path
+}
+
+// Writes a single file NIfTI-1 volume of uint8 labels with the given voxel
+// sizes, preceded by an extension which must be skipped.
+func writeNIfTIFixture(t *testing.T, dims [3]int16, pixdims [3]float32, data []byte) string {
+	header := make([]byte, 348)
+	order := binary.LittleEndian
+	order.PutUint32(header[0:], 348)
+	order.PutUint16(header[40:], 3)
+	for i := 0; i < 3; i++ {
+		order.PutUint16(header[42+i*2:], uint16(dims[i]))
+		order.PutUint32(header[80+i*4:], math.Float32bits(pixdims[i]))
+	}
+	order.PutUint16(header[70:], 2)
+	order.PutUint16(header[72:], 8)
+	order.PutUint32(header[108:], math.Float32bits(356))
+	copy(header[344:], "n+1\x00")
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+	buf.Write(data)
+	nifti_file_path := filepath.Join(t.TempDir(), "labels.nii")
+	if err := os.WriteFile(nifti_file_path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return nifti_file_path
+}
+
+func checkLabelVolume(t *testing.T, lv *LabelVolume, dims [3]int, spacing, origin [3]float64, labels ...ShapeId) {
+	if lv.Dims != dims || lv.Spacing != spacing || lv.Origin != origin {
+		t.Errorf("Expected a %v volume spaced %v from %v, got %v spaced %v from %v",
+			dims, spacing, origin, lv.Dims, lv.Spacing, lv.Origin)
+	}
+	if len(lv.Labels) != len(labels) {
+		t.Fatalf("Expected labels %v, got %v", labels, lv.Labels)
+	}
+	for i, label := range labels {
+		if lv.Labels[i] != label {
+			t.Errorf("Expected labels %v, got %v", labels, lv.Labels)
+			return
+		}
+	}
+}
+
+func TestNewLabelVolumeRejectsOversizedDims(t *testing.T) {
+	for _, dims := range [][3]int{{0, 1, 1}, {1 << 20, 1 << 20, 1 << 20}, {1 << 40, 1 << 40, 2}} {
+		if lv, err := NewLabelVolume(dims, [3]float64{1, 1, 1}, [3]float64{}); err == nil {
+			t.Errorf("Expected dimensions %v to be rejected, got %d voxels", dims, len(lv.Labels))
+		}
+	}
+	lv, err := NewLabelVolume([3]int{2, 3, 4}, [3]float64{1, 1, 1}, [3]float64{})
+	if err != nil || len(lv.Labels) != 24 {
+		t.Errorf("Expected a volume of 24 voxels, got %v", err)
+	}
+}
+
+func TestReadNRRDFile(t *testing.T) {
+	// the x axis runs backwards, so voxels are flipped into order
+	fields := []string{"type: uchar", "dimension: 3", "sizes: 3 1 1",
+		"space directions: (-2,0,0) (0,1,0) (0,0,0.5)", "space origin: (10,0,0)"}
+	lv, err := ReadNRRDFile(writeNRRDFixture(t, append(fields, "encoding: raw"), []byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLabelVolume(t, lv, [3]int{3, 1, 1}, [3]float64{2, 1, 0.5}, [3]float64{6, 0, 0}, 3, 2, 1)
+
+	lv, err = ReadNRRDFile(writeNRRDFixture(t, append(fields, "encoding: ascii"), []byte("1 2\n3\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLabelVolume(t, lv, [3]int{3, 1, 1}, [3]float64{2, 1, 0.5}, [3]float64{6, 0, 0}, 3, 2, 1)
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	binary.Write(gw, binary.BigEndian, []int16{300, 2, 1})
+	gw.Close()
+	fields[0] = "type: short"
+	lv, err = ReadNRRDFile(writeNRRDFixture(t,
+		append(fields, "encoding: gzip", "endian: big"), gzipped.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLabelVolume(t, lv, [3]int{3, 1, 1}, [3]float64{2, 1, 0.5}, [3]float64{6, 0, 0}, 1, 2, 300)
+}
+
+func TestReadNRRDFileRejectsMissingData(t *testing.T) {
+	for sizes, message := range map[string]string{
+		"1000 1000 1000":       "Volume data ended after 3 of 1000000000 voxels",
+		"100000 100000 100000": "Volume dimensions exceed",
+	} {
+		fields := []string{"type: uchar", "dimension: 3", "sizes: " + sizes, "encoding: raw"}
+		_, err := ReadNRRDFile(writeNRRDFixture(t, fields, []byte{1, 2, 3}))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected sizes %s to fail with %q, got %v", sizes, message, err)
+		}
+	}
+}
+
+func TestReadNIfTIFile(t *testing.T) {
+	lv, err := ReadNIfTIFile(writeNIfTIFixture(t, [3]int16{2, 2, 1}, [3]float32{1, 1.5, 2}, []byte{0, 1, 2, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLabelVolume(t, lv, [3]int{2, 2, 1}, [3]float64{1, 1.5, 2}, [3]float64{}, 0, 1, 2, 1)
+
+	_, err = ReadNIfTIFile(writeNIfTIFixture(t, [3]int16{1000, 1000, 1000}, [3]float32{1, 1, 1}, []byte{1}))
+	if err == nil || !strings.Contains(err.Error(), "Volume data ended after 1 of") {
+		t.Errorf("Expected a volume without enough data to be rejected, got %v", err)
+	}
+}
+
+func TestFromLabelVolume(t *testing.T) {
+	lv, err := NewLabelVolume([3]int{2, 1, 1}, [3]float64{1, 1, 1}, [3]float64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv.Set(0, 0, 0, 1)
+	lv.Set(1, 0, 0, 2)
+	ss := FromLabelVolume("voxels", lv, map[string]string{"1": "left"})
+
+	if len(ss.Shapes) != 2 || ss.Shapes[1] != "left" || ss.Shapes[2] != "2" {
+		t.Errorf("Expected shapes left and 2, got %v", ss.Shapes)
+	}
+	if len(ss.Meshes) != 3 || ss.Meshes[MeshId{0, 1}] == nil ||
+		ss.Meshes[MeshId{0, 2}] == nil || ss.Meshes[MeshId{1, 2}] == nil {
+		t.Fatalf("Expected meshes 0-1, 0-2 and 1-2, got %d meshes", len(ss.Meshes))
+	}
+	// the voxels meet halfway between their centers
+	between := ss.Meshes[MeshId{1, 2}]
+	if between.Faces.Len() != 2 {
+		t.Errorf("Expected the voxels to meet at a single quad, got %d faces", between.Faces.Len())
+	}
+	for i := 0; i < between.Vertices.Len(); i++ {
+		if x := between.Vertices.Get(i)[0].GetX(); x != 0.5 {
+			t.Errorf("Expected the voxels to meet at x of 0.5, got %g", x)
+		}
+	}
+
+	if err := ss.IndexBorders(); err != nil {
+		t.Fatal(err)
+	}
+	borders := 0
+	ss.BordersIndex.Each(func(b *Border) {
+		borders++
+		if desc := b.Description(); desc.ToString() != "0-1_0-2_1-2" {
+			t.Errorf("Expected the border to be shared by all three meshes, got %s", desc.ToString())
+		}
+	})
+	if borders != 1 {
+		t.Errorf("Expected one border around the voxels, got %d", borders)
+	}
+}
+
+func TestReadNRRDFileSpaces(t *testing.T) {
+	// world coordinates are converted to RAS, so the x and y directions of LPS
+	// volumes are reversed
+	for space, origin := range map[string][3]float64{
+		"left-posterior-superior": {-10, -5, 1},
+		"LPS":                     {-10, -5, 1},
+		"left-anterior-superior":  {-10, 4, 1},
+		"right-anterior-superior": {10, 4, 1},
+	} {
+		fields := []string{"type: uchar", "dimension: 3", "sizes: 1 2 1", "encoding: raw",
+			"space: " + space, "space directions: (2,0,0) (0,-1,0) (0,0,0.5)", "space origin: (10,5,1)"}
+		lv, err := ReadNRRDFile(writeNRRDFixture(t, fields, []byte{1, 2}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// y runs backwards in the file unless it is negated
+		labels := []ShapeId{2, 1}
+		if origin[1] < 0 {
+			labels = []ShapeId{1, 2}
+		}
+		checkLabelVolume(t, lv, [3]int{1, 2, 1}, [3]float64{2, 1, 0.5}, origin, labels...)
+		if !lv.RAS {
+			t.Errorf("Expected a volume in %s space to be RAS", space)
+		}
+	}
+
+	fields := []string{"type: uchar", "dimension: 3", "sizes: 1 1 1", "encoding: raw"}
+	lv, err := ReadNRRDFile(writeNRRDFixture(t, fields, []byte{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.RAS {
+		t.Errorf("Expected a volume without a space not to be RAS")
+	}
+	_, err = ReadNRRDFile(writeNRRDFixture(t, append(fields, "space: scanner-xyz"), []byte{1}))
+	if err == nil || err.Error() != "Unsupported NRRD space: scanner-xyz" {
+		t.Errorf("Expected an unsupported space to be rejected, got %v", err)
+	}
+}
+
+func TestWriteLabelVolumeFile(t *testing.T) {
+	lv, err := NewLabelVolume([3]int{2, 1, 2}, [3]float64{0.5, 1, 2}, [3]float64{-1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv.Set(1, 0, 0, 300)
+	lv.Set(0, 0, 1, 2)
+
+	dir := t.TempDir()
+	for _, ras := range []bool{false, true} {
+		lv.RAS = ras
+		for _, file_name := range []string{"labels.nrrd", "labels.nii", "labels.nii.gz"} {
+			volume_file_path := filepath.Join(dir, file_name)
+			if err = WriteLabelVolumeFile(lv, volume_file_path); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := ReadLabelVolumeFile(volume_file_path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLabelVolume(t, loaded, lv.Dims, lv.Spacing, lv.Origin, 0, 300, 2, 0)
+			// NIfTI volumes are always RAS
+			if loaded.RAS != (ras || file_name != "labels.nrrd") {
+				t.Errorf("Expected %s written from a volume with RAS %t to be read with RAS %t",
+					file_name, ras, !loaded.RAS)
+			}
+		}
+
+		header, err := os.ReadFile(filepath.Join(dir, "labels.nrrd"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if declared := bytes.Contains(header, []byte("\nspace: right-anterior-superior\n")); declared != ras {
+			t.Errorf("Expected the NRRD space to be declared only for RAS volumes, got:\n%s",
+				header[:bytes.Index(header, []byte("\n\n"))])
+		}
+	}
+
+	if err = WriteLabelVolumeFile(lv, filepath.Join(dir, "labels.mha")); err == nil {
+		t.Errorf("Expected an unsupported volume file type to be rejected")
+	}
+}